@@ -0,0 +1,463 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// ReplicaPolicy selects the replica of a read
+type ReplicaPolicy int
+
+const (
+	// the healthy replicas are used in turn
+	RoundRobin ReplicaPolicy = iota
+	// the healthy replica with the lowest latency of the last health check is used
+	LeastLatency
+)
+
+type ClusterOption func(cluster *Cluster)
+
+func WithReplicaPolicy(policy ReplicaPolicy) ClusterOption {
+	return func(cluster *Cluster) {
+		cluster.replicas.policy = policy
+	}
+}
+
+type replica struct {
+	db      SqlDatabase
+	healthy bool
+	latency time.Duration
+}
+
+type replicaSet struct {
+	sync.RWMutex
+
+	items  []*replica
+	policy ReplicaPolicy
+	next   int
+}
+
+// Cluster is the SqlDatabase of one primary and its read replicas.
+// The reads of the database and of the non-transactional accesses go to a healthy replica,
+// the writes, the transactions and the reads of a context returned by ForcePrimary go to the primary.
+// The primary is read as well while no replica is healthy.
+type Cluster struct {
+	primary  SqlDatabase
+	replicas *replicaSet
+	ctx      context.Context
+	// scopes the replicas to the tenant of ForTenant
+	tenant func(db SqlDatabase) SqlDatabase
+}
+
+func NewCluster(primary SqlDatabase, replicas []SqlDatabase, options ...ClusterOption) *Cluster {
+	cluster := &Cluster{
+		primary:  primary,
+		replicas: &replicaSet{items: make([]*replica, 0, len(replicas))},
+	}
+	for _, db := range replicas {
+		if db != nil {
+			cluster.replicas.items = append(cluster.replicas.items, &replica{db: db, healthy: true})
+		}
+	}
+	for _, option := range options {
+		if option != nil {
+			option(cluster)
+		}
+	}
+
+	return cluster
+}
+
+type primaryContextKey struct{}
+
+// ForcePrimary returns a copy of ctx whose reads go to the primary, e.g. to read the rows just written,
+// pass it to Cluster.WithContext
+func ForcePrimary(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	return context.WithValue(ctx, primaryContextKey{}, true)
+}
+
+func IsPrimaryForced(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	forced, _ := ctx.Value(primaryContextKey{}).(bool)
+
+	return forced
+}
+
+// CheckHealth tests each replica by Test, the failed replicas are not read until they pass again
+func (s *Cluster) CheckHealth() {
+	s.replicas.RLock()
+	items := append([]*replica{}, s.replicas.items...)
+	s.replicas.RUnlock()
+
+	for _, item := range items {
+		start := time.Now()
+		_, err := item.db.Test()
+		latency := time.Since(start)
+
+		s.replicas.Lock()
+		item.healthy = err == nil
+		item.latency = latency
+		s.replicas.Unlock()
+	}
+}
+
+// StartHealthCheck calls CheckHealth every interval until stop is called
+func (s *Cluster) StartHealthCheck(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				s.CheckHealth()
+			}
+		}
+	}()
+
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			close(done)
+		})
+	}
+}
+
+// reader returns the database of the next read
+func (s *Cluster) reader() SqlDatabase {
+	if IsPrimaryForced(s.ctx) {
+		return s.primary
+	}
+
+	db := s.replicas.pick()
+	if db == nil {
+		return s.primary
+	}
+	if s.ctx != nil {
+		db = db.WithContext(s.ctx)
+	}
+	if s.tenant != nil {
+		db = s.tenant(db)
+	}
+
+	return db
+}
+
+func (s *replicaSet) pick() SqlDatabase {
+	s.Lock()
+	defer s.Unlock()
+
+	count := len(s.items)
+	if s.policy == LeastLatency {
+		var best *replica
+		for _, item := range s.items {
+			if item.healthy && (best == nil || item.latency < best.latency) {
+				best = item
+			}
+		}
+		if best == nil {
+			return nil
+		}
+		return best.db
+	}
+
+	for i := 0; i < count; i++ {
+		item := s.items[(s.next+i)%count]
+		if item.healthy {
+			s.next = (s.next + i + 1) % count
+			return item.db
+		}
+	}
+
+	return nil
+}
+
+func (s *Cluster) Primary() SqlDatabase {
+	return s.primary
+}
+
+func (s *Cluster) Test() (string, error) {
+	return s.primary.Test()
+}
+
+func (s *Cluster) Tables() ([]*SqlTable, error) {
+	return s.primary.Tables()
+}
+
+func (s *Cluster) Views() ([]*SqlTable, error) {
+	return s.primary.Views()
+}
+
+func (s *Cluster) Columns(tableName string) ([]*SqlColumn, error) {
+	return s.primary.Columns(tableName)
+}
+
+// NewAccess returns the access of the primary if transactional,
+// otherwise an access reading a replica and writing the primary, which is opened on the first write
+func (s *Cluster) NewAccess(transactional bool) (SqlAccess, error) {
+	if transactional {
+		return s.primary.NewAccess(true)
+	}
+
+	reader, err := s.reader().NewAccess(false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &clusterAccess{SqlAccess: reader, primary: s.primary}, nil
+}
+
+func (s *Cluster) NewAccessTx(ctx context.Context, opts TxOptions) (SqlAccess, error) {
+	return s.primary.NewAccessTx(ctx, opts)
+}
+
+func (s *Cluster) RunInTx(ctx context.Context, opts TxOptions, fn func(tx SqlAccess) error) error {
+	return s.primary.RunInTx(ctx, opts, fn)
+}
+
+// WithContext returns a copy of the cluster whose accesses carry ctx, the reads go to the primary if ctx is returned by ForcePrimary
+func (s *Cluster) WithContext(ctx context.Context) SqlDatabase {
+	return &Cluster{primary: s.primary.WithContext(ctx), replicas: s.replicas, ctx: ctx, tenant: s.tenant}
+}
+
+// ForTenant returns a copy of the cluster whose primary and replicas are scoped to the tenant id
+func (s *Cluster) ForTenant(id interface{}, options ...Option) SqlDatabase {
+	tenant := func(db SqlDatabase) SqlDatabase {
+		return db.ForTenant(id, options...)
+	}
+
+	return &Cluster{primary: tenant(s.primary), replicas: s.replicas, ctx: s.ctx, tenant: tenant}
+}
+
+func (s *Cluster) NewEntity() SqlEntity {
+	return s.primary.NewEntity()
+}
+
+func (s *Cluster) NewBuilder() SqlBuilder {
+	return s.primary.NewBuilder()
+}
+
+func (s *Cluster) NewFilter(entity interface{}, fieldOr, groupOr bool) SqlFilter {
+	return s.primary.NewFilter(entity, fieldOr, groupOr)
+}
+
+func (s *Cluster) IsNoRows(err error) bool {
+	return s.primary.IsNoRows(err)
+}
+
+func (s *Cluster) IsRetryable(err error) bool {
+	return s.primary.IsRetryable(err)
+}
+
+func (s *Cluster) QueryInto(dest interface{}, query string, args ...interface{}) error {
+	return s.reader().QueryInto(dest, query, args...)
+}
+
+func (s *Cluster) Insert(entity interface{}) (uint64, error) {
+	return s.primary.Insert(entity)
+}
+
+func (s *Cluster) InsertSelective(entity interface{}) (uint64, error) {
+	return s.primary.InsertSelective(entity)
+}
+
+func (s *Cluster) Delete(entity interface{}, filters ...SqlFilter) (uint64, error) {
+	return s.primary.Delete(entity, filters...)
+}
+
+func (s *Cluster) Update(entity interface{}, filters ...SqlFilter) (uint64, error) {
+	return s.primary.Update(entity, filters...)
+}
+
+func (s *Cluster) UpdateSelective(entity interface{}, filters ...SqlFilter) (uint64, error) {
+	return s.primary.UpdateSelective(entity, filters...)
+}
+
+func (s *Cluster) UpdateByPrimaryKey(entity interface{}) (uint64, error) {
+	return s.primary.UpdateByPrimaryKey(entity)
+}
+
+func (s *Cluster) UpdateSelectiveByPrimaryKey(entity interface{}) (uint64, error) {
+	return s.primary.UpdateSelectiveByPrimaryKey(entity)
+}
+
+func (s *Cluster) SelectCount(entity interface{}, filters ...SqlFilter) (uint64, error) {
+	return s.reader().SelectCount(entity, filters...)
+}
+
+func (s *Cluster) SelectSum(entity interface{}, column string, filters ...SqlFilter) (float64, error) {
+	return s.reader().SelectSum(entity, column, filters...)
+}
+
+func (s *Cluster) SelectAvg(entity interface{}, column string, filters ...SqlFilter) (float64, error) {
+	return s.reader().SelectAvg(entity, column, filters...)
+}
+
+func (s *Cluster) SelectMax(entity interface{}, column string, filters ...SqlFilter) (interface{}, error) {
+	return s.reader().SelectMax(entity, column, filters...)
+}
+
+func (s *Cluster) SelectMin(entity interface{}, column string, filters ...SqlFilter) (interface{}, error) {
+	return s.reader().SelectMin(entity, column, filters...)
+}
+
+func (s *Cluster) SelectGroupCount(entity interface{}, groupColumns []string, filters ...SqlFilter) ([]*SqlGroupCount, error) {
+	return s.reader().SelectGroupCount(entity, groupColumns, filters...)
+}
+
+func (s *Cluster) Exists(entity interface{}, filters ...SqlFilter) (bool, error) {
+	return s.reader().Exists(entity, filters...)
+}
+
+func (s *Cluster) SelectOne(entity interface{}, filters ...SqlFilter) error {
+	return s.reader().SelectOne(entity, filters...)
+}
+
+func (s *Cluster) SelectDistinct(entity interface{}, row func(), order interface{}, filters ...SqlFilter) error {
+	return s.reader().SelectDistinct(entity, row, order, filters...)
+}
+
+func (s *Cluster) SelectList(entity interface{}, row func(), order interface{}, filters ...SqlFilter) error {
+	return s.reader().SelectList(entity, row, order, filters...)
+}
+
+func (s *Cluster) SelectEach(entity interface{}, row func() error, order interface{}, filters ...SqlFilter) error {
+	return s.reader().SelectEach(entity, row, order, filters...)
+}
+
+func (s *Cluster) Iterate(entity interface{}, order interface{}, filters ...SqlFilter) (SqlIterator, error) {
+	return s.reader().Iterate(entity, order, filters...)
+}
+
+func (s *Cluster) SelectPage(entity interface{}, page func(total, page, size, index uint64), row func(), size, index uint64, order interface{}, filters ...SqlFilter) error {
+	return s.reader().SelectPage(entity, page, row, size, index, order, filters...)
+}
+
+func (s *Cluster) SelectAfter(entity interface{}, page func(total uint64), row func(), cursor string, size uint64, order interface{}, filters ...SqlFilter) (*SqlCursor, error) {
+	return s.reader().SelectAfter(entity, page, row, cursor, size, order, filters...)
+}
+
+// clusterAccess is the non-transactional access of a cluster,
+// the reads go to the embedded access of the replica and the writes to the access of the primary
+type clusterAccess struct {
+	SqlAccess
+
+	primary SqlDatabase
+	writer  SqlAccess
+}
+
+func (s *clusterAccess) write() (SqlAccess, error) {
+	if s.writer == nil {
+		writer, err := s.primary.NewAccess(false)
+		if err != nil {
+			return nil, err
+		}
+		s.writer = writer
+	}
+
+	return s.writer, nil
+}
+
+func (s *clusterAccess) Close() error {
+	err := s.SqlAccess.Close()
+	if s.writer != nil {
+		writerErr := s.writer.Close()
+		if err == nil {
+			err = writerErr
+		}
+	}
+
+	return err
+}
+
+func (s *clusterAccess) Exec(query string, args ...interface{}) (sql.Result, error) {
+	writer, err := s.write()
+	if err != nil {
+		return nil, err
+	}
+
+	return writer.Exec(query, args...)
+}
+
+func (s *clusterAccess) Prepare(query string) (*sql.Stmt, error) {
+	writer, err := s.write()
+	if err != nil {
+		return nil, err
+	}
+
+	return writer.Prepare(query)
+}
+
+func (s *clusterAccess) Insert(entity interface{}) (uint64, error) {
+	writer, err := s.write()
+	if err != nil {
+		return 0, err
+	}
+
+	return writer.Insert(entity)
+}
+
+func (s *clusterAccess) InsertSelective(entity interface{}) (uint64, error) {
+	writer, err := s.write()
+	if err != nil {
+		return 0, err
+	}
+
+	return writer.InsertSelective(entity)
+}
+
+func (s *clusterAccess) Delete(entity interface{}, filters ...SqlFilter) (uint64, error) {
+	writer, err := s.write()
+	if err != nil {
+		return 0, err
+	}
+
+	return writer.Delete(entity, filters...)
+}
+
+func (s *clusterAccess) Update(entity interface{}, filters ...SqlFilter) (uint64, error) {
+	writer, err := s.write()
+	if err != nil {
+		return 0, err
+	}
+
+	return writer.Update(entity, filters...)
+}
+
+func (s *clusterAccess) UpdateSelective(entity interface{}, filters ...SqlFilter) (uint64, error) {
+	writer, err := s.write()
+	if err != nil {
+		return 0, err
+	}
+
+	return writer.UpdateSelective(entity, filters...)
+}
+
+func (s *clusterAccess) UpdateByPrimaryKey(entity interface{}) (uint64, error) {
+	writer, err := s.write()
+	if err != nil {
+		return 0, err
+	}
+
+	return writer.UpdateByPrimaryKey(entity)
+}
+
+func (s *clusterAccess) UpdateSelectiveByPrimaryKey(entity interface{}) (uint64, error) {
+	writer, err := s.write()
+	if err != nil {
+		return 0, err
+	}
+
+	return writer.UpdateSelectiveByPrimaryKey(entity)
+}
@@ -0,0 +1,145 @@
+package sqldb
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testClusterDatabase struct {
+	SqlDatabase
+
+	name  string
+	err   error
+	reads *[]string
+}
+
+func (s *testClusterDatabase) Test() (string, error) {
+	return s.name, s.err
+}
+
+func (s *testClusterDatabase) WithContext(ctx context.Context) SqlDatabase {
+	return s
+}
+
+func (s *testClusterDatabase) SelectOne(entity interface{}, filters ...SqlFilter) error {
+	*s.reads = append(*s.reads, s.name)
+	return nil
+}
+
+func (s *testClusterDatabase) Insert(entity interface{}) (uint64, error) {
+	*s.reads = append(*s.reads, "insert:"+s.name)
+	return 1, nil
+}
+
+func (s *testClusterDatabase) NewAccess(transactional bool) (SqlAccess, error) {
+	return &testClusterAccess{db: s, transactional: transactional}, nil
+}
+
+type testClusterAccess struct {
+	SqlAccess
+
+	db            *testClusterDatabase
+	transactional bool
+	closed        bool
+}
+
+func (s *testClusterAccess) Close() error {
+	s.closed = true
+	return nil
+}
+
+func (s *testClusterAccess) SelectOne(entity interface{}, filters ...SqlFilter) error {
+	return s.db.SelectOne(entity, filters...)
+}
+
+func (s *testClusterAccess) Insert(entity interface{}) (uint64, error) {
+	return s.db.Insert(entity)
+}
+
+func newTestCluster(options ...ClusterOption) (*Cluster, *[]string, []*testClusterDatabase) {
+	reads := make([]string, 0)
+	primary := &testClusterDatabase{name: "primary", reads: &reads}
+	replicas := []*testClusterDatabase{
+		{name: "replica1", reads: &reads},
+		{name: "replica2", reads: &reads},
+	}
+
+	return NewCluster(primary, []SqlDatabase{replicas[0], replicas[1]}, options...), &reads, replicas
+}
+
+func TestCluster_RoundRobin(t *testing.T) {
+	cluster, reads, replicas := newTestCluster()
+	for i := 0; i < 3; i++ {
+		cluster.SelectOne(nil)
+	}
+	cluster.Insert(nil)
+	if strings.Join(*reads, ",") != "replica1,replica2,replica1,insert:primary" {
+		t.Error("routing error: ", *reads)
+	}
+
+	// the failed replica is skipped, the primary is read while no replica is healthy
+	*reads = (*reads)[:0]
+	replicas[0].err = errors.New("down")
+	cluster.CheckHealth()
+	cluster.SelectOne(nil)
+	cluster.SelectOne(nil)
+	replicas[1].err = errors.New("down")
+	cluster.CheckHealth()
+	cluster.SelectOne(nil)
+	if strings.Join(*reads, ",") != "replica2,replica2,primary" {
+		t.Error("health routing error: ", *reads)
+	}
+}
+
+func TestCluster_LeastLatency(t *testing.T) {
+	cluster, reads, _ := newTestCluster(WithReplicaPolicy(LeastLatency))
+	cluster.replicas.items[0].latency = 2 * time.Millisecond
+	cluster.replicas.items[1].latency = time.Millisecond
+	cluster.SelectOne(nil)
+	cluster.SelectOne(nil)
+	if strings.Join(*reads, ",") != "replica2,replica2" {
+		t.Error("routing error: ", *reads)
+	}
+}
+
+func TestCluster_ForcePrimary(t *testing.T) {
+	cluster, reads, _ := newTestCluster()
+	if IsPrimaryForced(context.Background()) {
+		t.Error("primary should not be forced")
+	}
+	cluster.WithContext(ForcePrimary(context.Background())).SelectOne(nil)
+	cluster.SelectOne(nil)
+	if strings.Join(*reads, ",") != "primary,replica1" {
+		t.Error("routing error: ", *reads)
+	}
+}
+
+func TestCluster_NewAccess(t *testing.T) {
+	cluster, reads, _ := newTestCluster()
+	tx, err := cluster.NewAccess(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if access := tx.(*testClusterAccess); access.db.name != "primary" || !access.transactional {
+		t.Error("transactional access should be of the primary")
+	}
+
+	sqlAccess, err := cluster.NewAccess(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlAccess.SelectOne(nil)
+	sqlAccess.Insert(nil)
+	if strings.Join(*reads, ",") != "replica1,insert:primary" {
+		t.Error("access routing error: ", *reads)
+	}
+
+	access := sqlAccess.(*clusterAccess)
+	sqlAccess.Close()
+	if !access.SqlAccess.(*testClusterAccess).closed || !access.writer.(*testClusterAccess).closed {
+		t.Error("both accesses should be closed")
+	}
+}
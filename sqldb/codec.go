@@ -0,0 +1,428 @@
+package sqldb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Codec converts the value of a field tagged with `codec:"name"` to the value of the column and back
+type Codec interface {
+	// Encode returns the value written to the column, nil for NULL
+	Encode(value interface{}) (interface{}, error)
+	// Decode sets the value of the column to the field address, data is nil for NULL
+	Decode(data []byte, address interface{}) error
+}
+
+type codecRegistry struct {
+	sync.RWMutex
+
+	items map[string]Codec
+}
+
+var (
+	codecs = &codecRegistry{items: make(map[string]Codec)}
+
+	aesKeyMutex    sync.RWMutex
+	aesKeyProvider func() ([]byte, error)
+)
+
+func init() {
+	RegisterCodec("json", &jsonCodec{})
+	RegisterCodec("gzip", &gzipCodec{})
+	RegisterCodec("csv", &csvCodec{})
+	RegisterCodec("aes", NewAESCodec(defaultAESKey))
+}
+
+// RegisterCodec registers codec by name, the codec registered before with the same name is replaced
+func RegisterCodec(name string, codec Codec) {
+	if name == "" || codec == nil {
+		return
+	}
+
+	codecs.Lock()
+	defer codecs.Unlock()
+
+	codecs.items[name] = codec
+}
+
+func LookupCodec(name string) (Codec, bool) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+
+	codec, ok := codecs.items[name]
+	return codec, ok
+}
+
+// SetAESKeyProvider sets the key provider of the "aes" codec,
+// the key must be 16, 24 or 32 bytes to select AES-128, AES-192 or AES-256
+func SetAESKeyProvider(provider func() ([]byte, error)) {
+	aesKeyMutex.Lock()
+	defer aesKeyMutex.Unlock()
+
+	aesKeyProvider = provider
+}
+
+func defaultAESKey() ([]byte, error) {
+	aesKeyMutex.RLock()
+	defer aesKeyMutex.RUnlock()
+
+	if aesKeyProvider == nil {
+		return nil, fmt.Errorf("aes codec: key provider not set")
+	}
+
+	return aesKeyProvider()
+}
+
+// the value of a field is empty if it is nil or a nil pointer, map or slice
+func isNilValue(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+		return v.IsNil()
+	}
+
+	return false
+}
+
+// bytes of a string or []byte value
+func codecBytes(name string, value interface{}) ([]byte, error) {
+	v := reflect.Indirect(reflect.ValueOf(value))
+	switch {
+	case v.Kind() == reflect.String:
+		return []byte(v.String()), nil
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		return v.Bytes(), nil
+	}
+
+	return nil, fmt.Errorf("%s codec: %T is not string or []byte", name, value)
+}
+
+// set data to the address of a string or []byte field
+func setCodecBytes(name string, address interface{}, data []byte) error {
+	v := reflect.ValueOf(address)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("%s codec: invalid address %T", name, address)
+	}
+	v = v.Elem()
+	if v.Kind() == reflect.Ptr {
+		if data == nil {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(string(data))
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		v.SetBytes(data)
+	default:
+		return fmt.Errorf("%s codec: %s is not string or []byte", name, v.Type())
+	}
+
+	return nil
+}
+
+func setZero(address interface{}) {
+	v := reflect.ValueOf(address)
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		v.Elem().Set(reflect.Zero(v.Elem().Type()))
+	}
+}
+
+// struct, map or slice fields are stored as json text
+type jsonCodec struct {
+}
+
+func (s *jsonCodec) Encode(value interface{}) (interface{}, error) {
+	if isNilValue(value) {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+func (s *jsonCodec) Decode(data []byte, address interface{}) error {
+	if data == nil {
+		setZero(address)
+		return nil
+	}
+	// json merges into the existing maps and structs, which keep the values of the previous row
+	setZero(address)
+
+	return json.Unmarshal(data, address)
+}
+
+// string or []byte fields are stored compressed
+type gzipCodec struct {
+}
+
+func (s *gzipCodec) Encode(value interface{}) (interface{}, error) {
+	if isNilValue(value) {
+		return nil, nil
+	}
+	data, err := codecBytes("gzip", value)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	writer := gzip.NewWriter(buf)
+	_, err = writer.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (s *gzipCodec) Decode(data []byte, address interface{}) error {
+	if data == nil {
+		return setCodecBytes("gzip", address, nil)
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	plain, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+
+	return setCodecBytes("gzip", address, plain)
+}
+
+// []string fields are stored as a line of comma separated values
+type csvCodec struct {
+}
+
+func (s *csvCodec) Encode(value interface{}) (interface{}, error) {
+	if isNilValue(value) {
+		return nil, nil
+	}
+	v := reflect.Indirect(reflect.ValueOf(value))
+	if v.Kind() != reflect.Slice || v.Type().Elem().Kind() != reflect.String {
+		return nil, fmt.Errorf("csv codec: %T is not []string", value)
+	}
+
+	record := make([]string, v.Len())
+	for i := range record {
+		record[i] = v.Index(i).String()
+	}
+	sb := &strings.Builder{}
+	writer := csv.NewWriter(sb)
+	err := writer.Write(record)
+	if err != nil {
+		return nil, err
+	}
+	writer.Flush()
+	err = writer.Error()
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.TrimRight(sb.String(), "\r\n"), nil
+}
+
+func (s *csvCodec) Decode(data []byte, address interface{}) error {
+	v := reflect.ValueOf(address)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Slice || v.Elem().Type().Elem().Kind() != reflect.String {
+		return fmt.Errorf("csv codec: %T is not address of []string", address)
+	}
+	v = v.Elem()
+	if data == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	record := make([]string, 0)
+	if len(data) > 0 {
+		reader := csv.NewReader(bytes.NewReader(data))
+		var err error
+		record, err = reader.Read()
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+
+	items := reflect.MakeSlice(v.Type(), len(record), len(record))
+	for i, item := range record {
+		items.Index(i).SetString(item)
+	}
+	v.Set(items)
+
+	return nil
+}
+
+// string or []byte fields are stored encrypted by AES-GCM, the nonce is prepended to the cipher text
+type aesCodec struct {
+	key func() ([]byte, error)
+}
+
+// NewAESCodec returns an AES-GCM codec whose key is returned by key,
+// it can be registered with another name for a different key
+func NewAESCodec(key func() ([]byte, error)) Codec {
+	return &aesCodec{key: key}
+}
+
+func (s *aesCodec) aead() (cipher.AEAD, error) {
+	if s.key == nil {
+		return nil, fmt.Errorf("aes codec: key provider not set")
+	}
+	key, err := s.key()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func (s *aesCodec) Encode(value interface{}) (interface{}, error) {
+	if isNilValue(value) {
+		return nil, nil
+	}
+	data, err := codecBytes("aes", value)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := s.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, data, nil), nil
+}
+
+func (s *aesCodec) Decode(data []byte, address interface{}) error {
+	if data == nil {
+		return setCodecBytes("aes", address, nil)
+	}
+	aead, err := s.aead()
+	if err != nil {
+		return err
+	}
+
+	nonceSize := aead.NonceSize()
+	if len(data) < nonceSize {
+		return fmt.Errorf("aes codec: cipher text too short")
+	}
+	plain, err := aead.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+	if err != nil {
+		return err
+	}
+
+	return setCodecBytes("aes", address, plain)
+}
+
+// enum fields of integer type are stored by name
+type enumCodec struct {
+	names  map[int64]string
+	values map[string]int64
+}
+
+// NewEnumCodec returns a codec storing the integer values of an enum field as their names,
+// register it by the name used in the `codec` tag of the field
+func NewEnumCodec(names map[int64]string) Codec {
+	codec := &enumCodec{
+		names:  make(map[int64]string, len(names)),
+		values: make(map[string]int64, len(names)),
+	}
+	for value, name := range names {
+		codec.names[value] = name
+		codec.values[name] = value
+	}
+
+	return codec
+}
+
+func (s *enumCodec) Encode(value interface{}) (interface{}, error) {
+	if isNilValue(value) {
+		return nil, nil
+	}
+
+	v := reflect.Indirect(reflect.ValueOf(value))
+	var number int64
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		number = v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		number = int64(v.Uint())
+	default:
+		return nil, fmt.Errorf("enum codec: %T is not integer", value)
+	}
+
+	name, ok := s.names[number]
+	if !ok {
+		return nil, fmt.Errorf("enum codec: unknown value %d", number)
+	}
+
+	return name, nil
+}
+
+func (s *enumCodec) Decode(data []byte, address interface{}) error {
+	if data == nil {
+		setZero(address)
+		return nil
+	}
+
+	number, ok := s.values[string(data)]
+	if !ok {
+		return fmt.Errorf("enum codec: unknown name '%s'", string(data))
+	}
+
+	v := reflect.ValueOf(address)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("enum codec: invalid address %T", address)
+	}
+	v = v.Elem()
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(number)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(uint64(number))
+	default:
+		return fmt.Errorf("enum codec: %s is not integer", v.Type())
+	}
+
+	return nil
+}
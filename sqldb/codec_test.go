@@ -0,0 +1,160 @@
+package sqldb
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestCodec_Json(t *testing.T) {
+	codec, ok := LookupCodec("json")
+	if !ok {
+		t.Fatal("json codec not registered")
+	}
+
+	value := map[string]int{"a": 1, "b": 2}
+	data, err := codec.Encode(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data != `{"a":1,"b":2}` {
+		t.Error("json encode error: ", data)
+	}
+
+	decoded := make(map[string]int)
+	err = codec.Decode([]byte(data.(string)), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, value) {
+		t.Error("json decode error: ", decoded)
+	}
+
+	var nilMap map[string]int
+	data, err = codec.Encode(nilMap)
+	if err != nil || data != nil {
+		t.Error("nil map should be encoded as NULL: ", data, err)
+	}
+	err = codec.Decode(nil, &decoded)
+	if err != nil || decoded != nil {
+		t.Error("NULL should be decoded as nil map: ", decoded, err)
+	}
+
+	// the address is reused for each row
+	decoded = map[string]int{"a": 1}
+	err = codec.Decode([]byte(`{"b":2}`), &decoded)
+	if err != nil || !reflect.DeepEqual(decoded, map[string]int{"b": 2}) {
+		t.Error("keys of the previous value should be cleared: ", decoded, err)
+	}
+}
+
+func TestCodec_Gzip(t *testing.T) {
+	codec, _ := LookupCodec("gzip")
+	text := string(bytes.Repeat([]byte("compressed "), 100))
+	data, err := codec.Encode(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.([]byte)) >= len(text) {
+		t.Error("text should be compressed: ", len(data.([]byte)))
+	}
+
+	decoded := ""
+	err = codec.Decode(data.([]byte), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded != text {
+		t.Error("gzip decode error: ", decoded)
+	}
+}
+
+func TestCodec_Csv(t *testing.T) {
+	codec, _ := LookupCodec("csv")
+	value := []string{"a", "b,c", `d"e`}
+	data, err := codec.Encode(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := make([]string, 0)
+	err = codec.Decode([]byte(data.(string)), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, value) {
+		t.Error("csv decode error: ", decoded)
+	}
+
+	err = codec.Decode([]byte(""), &decoded)
+	if err != nil || len(decoded) != 0 {
+		t.Error("empty text should be decoded as empty slice: ", decoded, err)
+	}
+}
+
+func TestCodec_Aes(t *testing.T) {
+	codec, _ := LookupCodec("aes")
+	SetAESKeyProvider(nil)
+	_, err := codec.Encode("secret")
+	if err == nil {
+		t.Error("aes codec without key should be error")
+	}
+
+	SetAESKeyProvider(func() ([]byte, error) {
+		return bytes.Repeat([]byte{7}, 32), nil
+	})
+	defer SetAESKeyProvider(nil)
+
+	data, err := codec.Encode("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data.([]byte), []byte("secret")) {
+		t.Error("value should be encrypted")
+	}
+	again, _ := codec.Encode("secret")
+	if bytes.Equal(again.([]byte), data.([]byte)) {
+		t.Error("nonce should be random")
+	}
+
+	decoded := []byte(nil)
+	err = codec.Decode(data.([]byte), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != "secret" {
+		t.Error("aes decode error: ", string(decoded))
+	}
+
+	data.([]byte)[len(data.([]byte))-1] ^= 1
+	err = codec.Decode(data.([]byte), &decoded)
+	if err == nil {
+		t.Error("tampered cipher text should be error")
+	}
+}
+
+func TestCodec_Enum(t *testing.T) {
+	type status int
+	codec := NewEnumCodec(map[int64]string{0: "draft", 1: "published"})
+
+	data, err := codec.Encode(status(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data != "published" {
+		t.Error("enum encode error: ", data)
+	}
+	_, err = codec.Encode(status(5))
+	if err == nil {
+		t.Error("unknown value should be error")
+	}
+
+	var decoded status
+	err = codec.Decode([]byte("published"), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded != 1 {
+		t.Error("enum decode error: ", decoded)
+	}
+}
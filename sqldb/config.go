@@ -0,0 +1,217 @@
+package sqldb
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Config holds the settings shared by all accesses of a database
+type Config struct {
+	// returns the time of the created and updated columns, time.Now if nil
+	Clock func() time.Time
+	// the times are written in UTC, otherwise in local time which matches 'loc=Local' of the mysql connection
+	UTC bool
+	// returns the user of the createdBy and updatedBy columns, UserFromContext if nil
+	User func(ctx context.Context) interface{}
+	// NULL columns are scanned as zero values into all entity fields, like the fields tagged with `null:"zero"`,
+	// but zero values are still written as they are
+	NullZero bool
+	// names the untagged fields and the entities without TableName method, which are rejected if nil
+	Naming NamingStrategy
+
+	// the tenant of the scope set by ForTenant, nil if not scoped
+	TenantID interface{}
+	// the column holding the tenant of the rows, DefaultTenantColumn if empty,
+	// the entities without the column are rejected by ErrNoTenantColumn unless their tables are shared
+	TenantColumn string
+	// the tables shared by all tenants, which are not scoped by the tenant column or schema
+	SharedTables []string
+	// returns the schema of the tables of the tenant, the tenants are scoped by schema instead of column if set
+	TenantSchema func(id interface{}) string
+	// Exec, Query, QueryRow, Prepare and QueryInto are allowed in the tenant scope,
+	// they are rejected by default since the tenant predicate can not be added to the raw queries
+	AllowRawQuery bool
+}
+
+const DefaultTenantColumn = "tenant_id"
+
+type Option func(cfg *Config)
+
+func NewConfig(options ...Option) *Config {
+	cfg := &Config{}
+	for _, option := range options {
+		if option != nil {
+			option(cfg)
+		}
+	}
+
+	return cfg
+}
+
+// With returns a copy of the config changed by options
+func (s *Config) With(options ...Option) *Config {
+	cfg := &Config{}
+	if s != nil {
+		*cfg = *s
+	}
+	for _, option := range options {
+		if option != nil {
+			option(cfg)
+		}
+	}
+
+	return cfg
+}
+
+func WithClock(clock func() time.Time) Option {
+	return func(cfg *Config) {
+		cfg.Clock = clock
+	}
+}
+
+func WithUTC(utc bool) Option {
+	return func(cfg *Config) {
+		cfg.UTC = utc
+	}
+}
+
+func WithUserFunc(user func(ctx context.Context) interface{}) Option {
+	return func(cfg *Config) {
+		cfg.User = user
+	}
+}
+
+func WithNullZero(nullZero bool) Option {
+	return func(cfg *Config) {
+		cfg.NullZero = nullZero
+	}
+}
+
+func WithNaming(naming NamingStrategy) Option {
+	return func(cfg *Config) {
+		cfg.Naming = naming
+	}
+}
+
+func WithTenant(id interface{}) Option {
+	return func(cfg *Config) {
+		cfg.TenantID = id
+	}
+}
+
+func WithTenantColumn(column string) Option {
+	return func(cfg *Config) {
+		cfg.TenantColumn = column
+	}
+}
+
+func WithSharedTables(names ...string) Option {
+	return func(cfg *Config) {
+		cfg.SharedTables = append(append([]string{}, cfg.SharedTables...), names...)
+	}
+}
+
+func WithTenantSchema(schema func(id interface{}) string) Option {
+	return func(cfg *Config) {
+		cfg.TenantSchema = schema
+	}
+}
+
+func WithRawQuery(allow bool) Option {
+	return func(cfg *Config) {
+		cfg.AllowRawQuery = allow
+	}
+}
+
+// Now returns the current time of the clock, a nil config uses the local time
+func (s *Config) Now() time.Time {
+	if s == nil {
+		return time.Now()
+	}
+
+	now := time.Now
+	if s.Clock != nil {
+		now = s.Clock
+	}
+	if s.UTC {
+		return now().UTC()
+	}
+
+	return now().Local()
+}
+
+// CurrentUser returns the user of ctx, nil if unknown
+func (s *Config) CurrentUser(ctx context.Context) interface{} {
+	if ctx == nil {
+		return nil
+	}
+	if s == nil || s.User == nil {
+		return UserFromContext(ctx)
+	}
+
+	return s.User(ctx)
+}
+
+type userContextKey struct{}
+
+// ContextWithUser returns a copy of ctx carrying the user of the createdBy and updatedBy columns
+func ContextWithUser(ctx context.Context, user interface{}) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	return context.WithValue(ctx, userContextKey{}, user)
+}
+
+func UserFromContext(ctx context.Context) interface{} {
+	if ctx == nil {
+		return nil
+	}
+
+	return ctx.Value(userContextKey{})
+}
+
+// TenantFilterColumn returns the tenant column of the scope, empty if not scoped by column
+func (s *Config) TenantFilterColumn() string {
+	if s == nil || s.TenantID == nil || s.TenantSchema != nil {
+		return ""
+	}
+	if s.TenantColumn == "" {
+		return DefaultTenantColumn
+	}
+
+	return s.TenantColumn
+}
+
+// IsSharedTable returns true if the table is shared by all tenants, the names are case insensitive
+func (s *Config) IsSharedTable(name string) bool {
+	if s == nil {
+		return false
+	}
+	for _, table := range s.SharedTables {
+		if strings.EqualFold(table, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TenantSchemaName returns the schema of the tenant tables, empty if not scoped by schema
+func (s *Config) TenantSchemaName() string {
+	if s == nil || s.TenantID == nil || s.TenantSchema == nil {
+		return ""
+	}
+
+	return s.TenantSchema(s.TenantID)
+}
+
+// CheckRawQuery returns ErrRawQuery if the raw queries are not allowed in the tenant scope
+func (s *Config) CheckRawQuery() error {
+	if s == nil || s.TenantID == nil || s.AllowRawQuery {
+		return nil
+	}
+
+	return ErrRawQuery
+}
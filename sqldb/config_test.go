@@ -0,0 +1,74 @@
+package sqldb
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestConfig_Now(t *testing.T) {
+	fixed := time.Date(2020, 5, 1, 8, 30, 0, 0, time.FixedZone("UTC+8", 8*3600))
+	cfg := NewConfig(WithClock(func() time.Time { return fixed }), WithUTC(true))
+	now := cfg.Now()
+	if !now.Equal(fixed) || now.Location() != time.UTC {
+		t.Error("utc time error: ", now)
+	}
+
+	cfg = NewConfig(WithClock(func() time.Time { return fixed }))
+	now = cfg.Now()
+	if !now.Equal(fixed) || now.Location() != time.Local {
+		t.Error("local time error: ", now)
+	}
+
+	var nilConfig *Config
+	if nilConfig.Now().IsZero() {
+		t.Error("nil config should use the current time")
+	}
+}
+
+func TestConfig_CurrentUser(t *testing.T) {
+	ctx := ContextWithUser(context.Background(), "admin")
+	var nilConfig *Config
+	if nilConfig.CurrentUser(ctx) != "admin" {
+		t.Error("user error: ", nilConfig.CurrentUser(ctx))
+	}
+	if nilConfig.CurrentUser(nil) != nil {
+		t.Error("user of nil context should be nil")
+	}
+
+	cfg := NewConfig(WithUserFunc(func(ctx context.Context) interface{} {
+		return 1001
+	}))
+	if cfg.CurrentUser(ctx) != 1001 {
+		t.Error("user func error: ", cfg.CurrentUser(ctx))
+	}
+}
+
+func TestConfig_Tenant(t *testing.T) {
+	var nilConfig *Config
+	if nilConfig.TenantFilterColumn() != "" || nilConfig.CheckRawQuery() != nil {
+		t.Error("nil config should not be scoped")
+	}
+
+	cfg := NewConfig(WithTenantColumn("org"))
+	scoped := cfg.With(WithTenant(1))
+	if cfg.TenantID != nil {
+		t.Error("With should not change the config")
+	}
+	if scoped.TenantFilterColumn() != "org" || scoped.TenantSchemaName() != "" {
+		t.Error("column scope error: ", scoped.TenantFilterColumn())
+	}
+	if scoped.CheckRawQuery() != ErrRawQuery || scoped.With(WithRawQuery(true)).CheckRawQuery() != nil {
+		t.Error("raw query guard error")
+	}
+
+	scoped = scoped.With(WithTenantSchema(func(id interface{}) string {
+		return "t1"
+	}))
+	if scoped.TenantFilterColumn() != "" || scoped.TenantSchemaName() != "t1" {
+		t.Error("schema scope error: ", scoped.TenantSchemaName())
+	}
+	if NewConfig(WithTenant("a")).TenantFilterColumn() != DefaultTenantColumn {
+		t.Error("default tenant column error")
+	}
+}
@@ -0,0 +1,124 @@
+package sqldb
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+
+	cursorKeyMutex sync.RWMutex
+	cursorKey      = newCursorKey()
+)
+
+// SqlCursor is returned by SelectAfter, the cursors are opaque strings for the adjacent pages
+type SqlCursor struct {
+	Next     string `json:"next" note:"下一页游标, 为空表示没有下一页"`
+	Previous string `json:"previous" note:"上一页游标, 为空表示没有上一页"`
+}
+
+type cursorPayload struct {
+	Backward bool              `json:"b,omitempty"`
+	Columns  []string          `json:"c"`
+	Values   []json.RawMessage `json:"v"`
+}
+
+// SetCursorKey sets the key used to sign cursors.
+// A random key is generated at startup, so it should be set when cursors
+// are shared between processes or must survive restarts.
+func SetCursorKey(key []byte) {
+	if len(key) < 1 {
+		return
+	}
+
+	cursorKeyMutex.Lock()
+	defer cursorKeyMutex.Unlock()
+
+	cursorKey = append([]byte{}, key...)
+}
+
+// EncodeCursor signs the seek values of a row for the given columns.
+// backward means the cursor points to the page before the row.
+func EncodeCursor(backward bool, columns []string, values []interface{}) (string, error) {
+	payload := &cursorPayload{
+		Backward: backward,
+		Columns:  columns,
+		Values:   make([]json.RawMessage, len(values)),
+	}
+	for i, value := range values {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		payload.Values[i] = data
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	encoding := base64.RawURLEncoding
+	return encoding.EncodeToString(data) + "." + encoding.EncodeToString(signCursor(data)), nil
+}
+
+// DecodeCursor verifies the signature and the columns of cursor and returns the raw seek values
+func DecodeCursor(cursor string, columns []string) (backward bool, values []json.RawMessage, err error) {
+	parts := strings.Split(cursor, ".")
+	if len(parts) != 2 {
+		return false, nil, ErrInvalidCursor
+	}
+
+	encoding := base64.RawURLEncoding
+	data, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return false, nil, ErrInvalidCursor
+	}
+	signature, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return false, nil, ErrInvalidCursor
+	}
+	if !hmac.Equal(signature, signCursor(data)) {
+		return false, nil, ErrInvalidCursor
+	}
+
+	payload := &cursorPayload{}
+	err = json.Unmarshal(data, payload)
+	if err != nil {
+		return false, nil, ErrInvalidCursor
+	}
+	if len(payload.Columns) != len(columns) || len(payload.Values) != len(columns) {
+		return false, nil, ErrInvalidCursor
+	}
+	for i := range columns {
+		if payload.Columns[i] != columns[i] {
+			return false, nil, ErrInvalidCursor
+		}
+	}
+
+	return payload.Backward, payload.Values, nil
+}
+
+func signCursor(data []byte) []byte {
+	cursorKeyMutex.RLock()
+	defer cursorKeyMutex.RUnlock()
+
+	mac := hmac.New(sha256.New, cursorKey)
+	mac.Write(data)
+
+	return mac.Sum(nil)
+}
+
+func newCursorKey() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+
+	return key
+}
@@ -0,0 +1,58 @@
+package sqldb
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCursor(t *testing.T) {
+	columns := []string{"`createdAt`", "`id`"}
+	createdAt := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+	cursor, err := EncodeCursor(true, columns, []interface{}{createdAt, uint64(12)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backward, values, err := DecodeCursor(cursor, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !backward {
+		t.Error("backward should be true")
+	}
+	decodedAt := time.Time{}
+	err = json.Unmarshal(values[0], &decodedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decodedAt.Equal(createdAt) {
+		t.Error("value error: expect=", createdAt, ", actual=", decodedAt)
+	}
+	id := uint64(0)
+	err = json.Unmarshal(values[1], &id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 12 {
+		t.Error("value error: expect=12, actual=", id)
+	}
+
+	_, _, err = DecodeCursor(cursor, []string{"`id`", "`createdAt`"})
+	if err != ErrInvalidCursor {
+		t.Error("cursor of other columns should be invalid")
+	}
+
+	parts := strings.Split(cursor, ".")
+	tampered := parts[0] + "x." + parts[1]
+	_, _, err = DecodeCursor(tampered, columns)
+	if err != ErrInvalidCursor {
+		t.Error("tampered cursor should be invalid")
+	}
+
+	_, _, err = DecodeCursor("invalid", columns)
+	if err != ErrInvalidCursor {
+		t.Error("malformed cursor should be invalid")
+	}
+}
@@ -0,0 +1,25 @@
+package sqldb
+
+import (
+	"errors"
+)
+
+var (
+	ErrNotInTransaction = errors.New("not in transaction")
+
+	// returned by the database for the options it can not honor, such as the read only transaction of sql server
+	ErrNotSupported = errors.New("not supported by the database")
+
+	// returned by UpdateByPrimaryKey when the version of the entity has been changed by others
+	ErrStaleEntity = errors.New("stale entity: version changed or row deleted")
+
+	// returned by the raw queries of a tenant scope unless allowed by WithRawQuery
+	ErrRawQuery = errors.New("raw query not allowed in tenant scope")
+
+	// returned by the operations of a tenant scope on the entity without the tenant column,
+	// whose rows can not be restricted to the tenant, unless the table is shared by WithSharedTables
+	ErrNoTenantColumn = errors.New("tenant column not in entity")
+
+	// returned by a row callback to end the iteration without error
+	ErrStop = errors.New("stop iteration")
+)
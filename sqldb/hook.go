@@ -0,0 +1,32 @@
+package sqldb
+
+// optional interfaces of the entity, they are detected on each operation like TableName
+// and an error returned by a hook aborts the operation
+
+type BeforeInserter interface {
+	BeforeInsert() error
+}
+
+// AfterInsert is called with the auto increment id, 0 if the entity has no auto increment field
+type AfterInserter interface {
+	AfterInsert(id uint64)
+}
+
+type BeforeUpdater interface {
+	BeforeUpdate() error
+}
+
+// AfterSelect is called after each row is scanned into the entity, before the row callback
+type AfterSelecter interface {
+	AfterSelect() error
+}
+
+// BeforeDelete is called on the entity passed to Delete, which is usually the template of the table
+type BeforeDeleter interface {
+	BeforeDelete() error
+}
+
+// Validate is called after BeforeInsert and BeforeUpdate
+type Validator interface {
+	Validate() error
+}
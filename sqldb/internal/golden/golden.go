@@ -0,0 +1,83 @@
+package golden
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// run the tests with -update to regenerate the golden files after an intended change of the sql
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// Case is a call of the driver, whose statements are recorded under the name
+type Case struct {
+	Name string
+	Run  func() error
+}
+
+// Run records the statements of the cases, then compares them with testdata/<test name>.golden
+func Run(t *testing.T, recorder *Recorder, cases []Case) {
+	t.Helper()
+
+	sb := &strings.Builder{}
+	recorder.Statements()
+	for _, c := range cases {
+		sb.WriteString(fmt.Sprintf("-- %s\n", c.Name))
+		err := c.Run()
+		for _, statement := range recorder.Statements() {
+			sb.WriteString(statement.String())
+			sb.WriteString("\n")
+		}
+		if err != nil {
+			sb.WriteString(fmt.Sprintf("error: %v\n", err))
+		}
+		sb.WriteString("\n")
+	}
+
+	Assert(t, sb.String())
+}
+
+// Assert compares actual with testdata/<test name>.golden, which is written instead if -update
+func Assert(t *testing.T, actual string) {
+	t.Helper()
+
+	path := filepath.Join("testdata", strings.Replace(t.Name(), "/", "_", -1)+".golden")
+	if *update {
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(path, []byte(actual), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	expect, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err, " (run the test with -update to create the golden file)")
+	}
+	if string(expect) == actual {
+		return
+	}
+
+	expectLines := strings.Split(string(expect), "\n")
+	actualLines := strings.Split(actual, "\n")
+	for i := 0; i < len(expectLines) || i < len(actualLines); i++ {
+		expectLine, actualLine := "", ""
+		if i < len(expectLines) {
+			expectLine = expectLines[i]
+		}
+		if i < len(actualLines) {
+			actualLine = actualLines[i]
+		}
+		if expectLine != actualLine {
+			t.Errorf("%s:%d differs\nexpect: %s\nactual: %s", path, i+1, expectLine, actualLine)
+			return
+		}
+	}
+}
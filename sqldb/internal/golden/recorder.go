@@ -0,0 +1,298 @@
+// Package golden records the statements sent by the drivers through database/sql,
+// so that the tests of the drivers compare the generated sql with the golden files in testdata.
+package golden
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// DriverName is the name of the recording driver registered to database/sql
+const DriverName = "golden"
+
+func init() {
+	sql.Register(DriverName, &recordDriver{})
+}
+
+var recorders = &recorderRegistry{items: make(map[string]*Recorder)}
+
+type recorderRegistry struct {
+	sync.RWMutex
+
+	items map[string]*Recorder
+	count int
+}
+
+func (s *recorderRegistry) add(recorder *Recorder) string {
+	s.Lock()
+	defer s.Unlock()
+
+	s.count++
+	name := fmt.Sprintf("recorder%d", s.count)
+	s.items[name] = recorder
+
+	return name
+}
+
+func (s *recorderRegistry) get(name string) (*Recorder, bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	recorder, ok := s.items[name]
+	return recorder, ok
+}
+
+type Statement struct {
+	Query string
+	Args  []interface{}
+}
+
+func (s *Statement) String() string {
+	sb := &strings.Builder{}
+	sb.WriteString(s.Query)
+	for i, arg := range s.Args {
+		sb.WriteString(fmt.Sprintf("\n  $%d = %s", i+1, formatArg(arg)))
+	}
+
+	return sb.String()
+}
+
+// formatArg writes the arg with its type, the pointers are dereferenced to keep the golden files stable
+func formatArg(arg interface{}) string {
+	if arg == nil {
+		return "NULL"
+	}
+	v := reflect.ValueOf(arg)
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return fmt.Sprintf("(%T)(nil)", arg)
+		}
+		return "&" + formatArg(v.Elem().Interface())
+	case reflect.String:
+		return fmt.Sprintf("%q", arg)
+	}
+
+	return fmt.Sprintf("%T(%v)", arg, arg)
+}
+
+type response struct {
+	match   string
+	columns []string
+	rows    [][]driver.Value
+}
+
+// Recorder is the sqldb.SqlConnection of the recording driver, which keeps the statements executed
+// and answers the queries by the responses, or by no rows if none matches.
+// Exec reports 1 row affected and 1 as the last insert id.
+type Recorder struct {
+	sync.Mutex
+
+	name       string
+	statements []*Statement
+	responses  []*response
+}
+
+func NewRecorder() *Recorder {
+	recorder := &Recorder{}
+	recorder.name = recorders.add(recorder)
+
+	return recorder
+}
+
+func (s *Recorder) DriverName() string {
+	return DriverName
+}
+
+func (s *Recorder) SourceName() string {
+	return s.name
+}
+
+func (s *Recorder) SchemaName() string {
+	return "golden"
+}
+
+// Respond answers the queries containing match by the rows, the response of the same match is replaced
+func (s *Recorder) Respond(match string, columns []string, rows ...[]driver.Value) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, item := range s.responses {
+		if item.match == match {
+			item.columns, item.rows = columns, rows
+			return
+		}
+	}
+	s.responses = append(s.responses, &response{match: match, columns: columns, rows: rows})
+}
+
+// Statements returns the statements recorded since the last call, BEGIN, COMMIT and ROLLBACK included
+func (s *Recorder) Statements() []*Statement {
+	s.Lock()
+	defer s.Unlock()
+
+	statements := s.statements
+	s.statements = nil
+
+	return statements
+}
+
+func (s *Recorder) record(query string, args []driver.NamedValue) {
+	s.Lock()
+	defer s.Unlock()
+
+	statement := &Statement{Query: query, Args: make([]interface{}, len(args))}
+	for i, arg := range args {
+		statement.Args[i] = arg.Value
+	}
+	s.statements = append(s.statements, statement)
+}
+
+func (s *Recorder) respond(query string) *response {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, item := range s.responses {
+		if strings.Contains(query, item.match) {
+			return item
+		}
+	}
+
+	return &response{}
+}
+
+type recordDriver struct {
+}
+
+func (s *recordDriver) Open(name string) (driver.Conn, error) {
+	recorder, ok := recorders.get(name)
+	if !ok {
+		return nil, fmt.Errorf("golden: recorder %s not found", name)
+	}
+
+	return &conn{recorder: recorder}, nil
+}
+
+type conn struct {
+	recorder *Recorder
+}
+
+func (s *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{conn: s, query: query}, nil
+}
+
+func (s *conn) Close() error {
+	return nil
+}
+
+func (s *conn) Begin() (driver.Tx, error) {
+	return s.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (s *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if opts.ReadOnly {
+		s.recorder.record("BEGIN READ ONLY", nil)
+		return &tx{conn: s}, nil
+	}
+	s.recorder.record("BEGIN", nil)
+	return &tx{conn: s}, nil
+}
+
+// CheckNamedValue keeps the args as they are passed, so that the golden files show their types
+func (s *conn) CheckNamedValue(value *driver.NamedValue) error {
+	return nil
+}
+
+func (s *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	s.recorder.record(query, args)
+	return result{}, nil
+}
+
+func (s *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	s.recorder.record(query, args)
+	return &rows{response: s.recorder.respond(query)}, nil
+}
+
+type tx struct {
+	conn *conn
+}
+
+func (s *tx) Commit() error {
+	s.conn.recorder.record("COMMIT", nil)
+	return nil
+}
+
+func (s *tx) Rollback() error {
+	s.conn.recorder.record("ROLLBACK", nil)
+	return nil
+}
+
+type stmt struct {
+	conn  *conn
+	query string
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return -1
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, namedValues(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, namedValues(args))
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	values := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		values[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+
+	return values
+}
+
+type result struct {
+}
+
+func (s result) LastInsertId() (int64, error) {
+	return 1, nil
+}
+
+func (s result) RowsAffected() (int64, error) {
+	return 1, nil
+}
+
+type rows struct {
+	response *response
+	index    int
+}
+
+func (s *rows) Columns() []string {
+	return s.response.columns
+}
+
+func (s *rows) Close() error {
+	return nil
+}
+
+func (s *rows) Next(dest []driver.Value) error {
+	if s.index >= len(s.response.rows) {
+		return io.EOF
+	}
+	copy(dest, s.response.rows[s.index])
+	s.index++
+
+	return nil
+}
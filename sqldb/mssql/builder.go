@@ -93,6 +93,18 @@ func (s *builder) Set(filed string, value interface{}) sqldb.SqlBuilder {
 	return s
 }
 
+// setExpression sets the column by an expression without argument, such as "version + 1"
+func (s *builder) setExpression(filed string, expression string) sqldb.SqlBuilder {
+	if s.hasSet {
+		s.query = append(s.query, fmt.Sprint(", ", filed, " = ", expression))
+	} else {
+		s.hasSet = true
+		s.query = append(s.query, fmt.Sprint("SET ", filed, " = ", expression))
+	}
+
+	return s
+}
+
 func (s *builder) WhereFormatAnd(format string, a ...interface{}) sqldb.SqlBuilder {
 	if s.query == nil {
 		s.query = make([]string, 0)
@@ -289,6 +301,16 @@ func (s *builder) argName() string {
 	return fmt.Sprintf("@p%d", len(s.args)+1)
 }
 
+// names of the next n arguments
+func (s *builder) argNames(n int) []string {
+	names := make([]string, n)
+	for i := 0; i < n; i++ {
+		names[i] = fmt.Sprintf("@p%d", len(s.args)+i+1)
+	}
+
+	return names
+}
+
 func (s *builder) ArgName() string {
 	return s.argName()
 }
@@ -0,0 +1,47 @@
+package mssql_test
+
+import (
+	"github.com/ktpswjz/database/sqldb"
+	"github.com/ktpswjz/database/sqldb/mssql"
+	"github.com/ktpswjz/database/sqldb/sqldbtest"
+	"os"
+	"testing"
+)
+
+// TestConformance_Mssql runs against the server of the connection file in SQLDB_MSSQL_TEST, skipped if not set
+func TestConformance_Mssql(t *testing.T) {
+	cfgPath := os.Getenv("SQLDB_MSSQL_TEST")
+	if cfgPath == "" {
+		t.Skip("SQLDB_MSSQL_TEST not set")
+	}
+	conn := &mssql.Connection{}
+	err := conn.LoadFromFile(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sqldbtest.RunConformance(t, func(t *testing.T) sqldb.SqlDatabase {
+		db := mssql.NewDatabase(conn)
+		sqlAccess, err := db.NewAccess(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sqlAccess.Close()
+
+		for _, query := range []string{
+			"IF OBJECT_ID('conformance_item', 'U') IS NOT NULL DROP TABLE [conformance_item]",
+			"CREATE TABLE [conformance_item] (" +
+				"[id] BIGINT IDENTITY(1,1) NOT NULL PRIMARY KEY, " +
+				"[name] NVARCHAR(64) NOT NULL, " +
+				"[age] INT NOT NULL, " +
+				"[email] NVARCHAR(64) NULL)",
+		} {
+			_, err = sqlAccess.Exec(query)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return db
+	})
+}
@@ -1,19 +1,23 @@
 package mssql
 
 import (
+	"database/sql"
 	"fmt"
+	"github.com/ktpswjz/database/sqldb"
 	"reflect"
 )
 
 type field struct {
-	name          string
-	value         interface{}
-	address       interface{}
-	autoIncrement bool
-	primaryKey    bool
-	filter        string
-	order         string
-	index         int
+	*fieldMeta
+
+	value   interface{}
+	address interface{}
+	// the address is in a struct not set to the entity, as a pointer on the path is nil
+	detached bool
+
+	// codec and nullable fields are scanned into holder then decoded to address
+	coder  sqldb.Codec
+	holder interface{}
 }
 
 func (s *field) Name() string {
@@ -74,14 +78,139 @@ func (s *field) ValueEmpty() bool {
 	return false
 }
 
-type fieldCollection []*field
+// setValue assigns value to the struct field and refreshes the value of the field,
+// a nil pointer field is allocated
+func (s *field) setValue(value interface{}) error {
+	target := reflect.ValueOf(s.address).Elem()
+	if scanner, ok := s.address.(sql.Scanner); ok {
+		err := scanner.Scan(value)
+		if err != nil {
+			return fmt.Errorf("set field %s: %v", s.name, err)
+		}
+	} else {
+		v := reflect.ValueOf(value)
+		if target.Kind() == reflect.Ptr && !v.Type().AssignableTo(target.Type()) {
+			elem := reflect.New(target.Type().Elem())
+			err := s.assign(elem.Elem(), v)
+			if err != nil {
+				return err
+			}
+			target.Set(elem)
+		} else {
+			err := s.assign(target, v)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	s.value = target.Interface()
+
+	return nil
+}
+
+// nextVersion returns the value of the version field plus one
+func (s *field) nextVersion() (interface{}, error) {
+	v := reflect.ValueOf(s.address).Elem()
+	next := reflect.New(v.Type()).Elem()
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		next.SetInt(v.Int() + 1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		next.SetUint(v.Uint() + 1)
+	default:
+		return nil, fmt.Errorf("invalid version field %s: %s is not integer", s.name, v.Type())
+	}
+
+	return next.Interface(), nil
+}
+
+func (s *field) assign(target, v reflect.Value) error {
+	if v.Type().AssignableTo(target.Type()) {
+		target.Set(v)
+		return nil
+	}
+	// numbers are not converted to string as runes
+	if v.Type().ConvertibleTo(target.Type()) && (target.Kind() != reflect.String || v.Kind() == reflect.String) {
+		target.Set(v.Convert(target.Type()))
+		return nil
+	}
+
+	return fmt.Errorf("set field %s: %s is not assignable to %s", s.name, v.Type(), target.Type())
+}
+
+func (s *field) bindCodec() error {
+	coder, ok := sqldb.LookupCodec(s.codec)
+	if !ok {
+		return fmt.Errorf("codec '%s' of field %s not registered", s.codec, s.name)
+	}
+	s.coder = coder
+	s.holder = new([]byte)
+	if s.nullZero && isZero(s.value) {
+		s.value = nil
+	}
+
+	return nil
+}
 
-func (s fieldCollection) Len() int {
-	return len(s)
+// NULL is scanned into a nil pointer and decoded as zero value,
+// the zero value is written as NULL if the field is tagged with `null:"zero"`
+func (s *field) bindNullable() {
+	t := reflect.TypeOf(s.address).Elem()
+	if t.Kind() == reflect.Ptr || reflect.PtrTo(t).Implements(scannerType) {
+		return
+	}
+	s.holder = reflect.New(reflect.PtrTo(t)).Interface()
+	if s.nullZero && isZero(s.value) {
+		s.value = nil
+	}
+}
+
+func (s *field) decode() error {
+	if s.coder != nil {
+		err := s.coder.Decode(*s.holder.(*[]byte), s.address)
+		if err != nil {
+			return fmt.Errorf("decode field %s: %v", s.name, err)
+		}
+		return nil
+	}
+
+	target := reflect.ValueOf(s.address).Elem()
+	value := reflect.ValueOf(s.holder).Elem()
+	if value.IsNil() {
+		target.Set(reflect.Zero(target.Type()))
+	} else {
+		target.Set(value.Elem())
+	}
+
+	return nil
 }
 
-func (s fieldCollection) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s *field) encode() error {
+	value, err := s.coder.Encode(s.value)
+	if err != nil {
+		return fmt.Errorf("encode field %s: %v", s.name, err)
+	}
+	s.value = value
 
-func (s fieldCollection) Less(i, j int) bool {
-	return s[i].index < s[j].index
+	return nil
 }
+
+func (s *field) scanAddress() interface{} {
+	if s.holder != nil {
+		return s.holder
+	}
+
+	return s.address
+}
+
+var scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+
+func isZero(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+
+	return reflect.ValueOf(value).IsZero()
+}
+
+type fieldCollection []*field
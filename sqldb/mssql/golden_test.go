@@ -0,0 +1,410 @@
+package mssql
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"github.com/ktpswjz/database/sqldb"
+	"github.com/ktpswjz/database/sqldb/internal/golden"
+	"strings"
+	"testing"
+	"time"
+)
+
+type goldenUser struct {
+	ID    uint64 `sql:"id" auto:"true" primary:"true"`
+	Name  string `sql:"name"`
+	Age   int    `sql:"age"`
+	Email string `sql:"email"`
+}
+
+func (s goldenUser) TableName() string {
+	return "tabUser"
+}
+
+type goldenUserFilter struct {
+	Name   string `sql:"name" filter:"like"`
+	MinAge *int   `sql:"age" filter:">="`
+	IDs    string `sql:"id" filter:"in"`
+}
+
+type goldenUserOrder struct {
+	Age int    `sql:"age" order:"DESC"`
+	ID  uint64 `sql:"id"`
+}
+
+func (s goldenUserOrder) TableName() string {
+	return "tabUser"
+}
+
+func newGoldenDatabase() (sqldb.SqlDatabase, *golden.Recorder) {
+	recorder := golden.NewRecorder()
+	recorder.Respond("COUNT(*)", []string{"count"}, []driver.Value{int64(25)})
+
+	return NewDatabase(recorder), recorder
+}
+
+func TestGolden_Statement(t *testing.T) {
+	db, recorder := newGoldenDatabase()
+	minAge := 18
+	filter := db.NewFilter(&goldenUserFilter{Name: "A%", MinAge: &minAge}, false, false)
+	golden.Run(t, recorder, []golden.Case{
+		{Name: "insert", Run: func() error {
+			_, err := db.Insert(&goldenUser{Name: "Alice", Age: 30})
+			return err
+		}},
+		{Name: "insert selective", Run: func() error {
+			_, err := db.InsertSelective(&goldenUser{Name: "Alice"})
+			return err
+		}},
+		{Name: "update", Run: func() error {
+			_, err := db.Update(&goldenUser{Name: "Bob", Age: 20}, filter)
+			return err
+		}},
+		{Name: "update selective", Run: func() error {
+			_, err := db.UpdateSelective(&goldenUser{Age: 20}, filter)
+			return err
+		}},
+		{Name: "update by primary key", Run: func() error {
+			_, err := db.UpdateByPrimaryKey(&goldenUser{ID: 7, Name: "Carol", Age: 40})
+			return err
+		}},
+		{Name: "update selective by primary key", Run: func() error {
+			_, err := db.UpdateSelectiveByPrimaryKey(&goldenUser{ID: 7, Email: "carol@example.com"})
+			return err
+		}},
+		{Name: "delete", Run: func() error {
+			_, err := db.Delete(&goldenUser{}, filter)
+			return err
+		}},
+		// the paging of sql server before 2012 numbers the rows instead of OFFSET FETCH
+		{Name: "select page 2008", Run: func() error {
+			recorder.Respond("@@VERSION", []string{"version"}, []driver.Value{"Microsoft SQL Server 2008 R2 (SP2)"})
+			return db.SelectPage(&goldenUser{}, nil, nil, 10, 2, &goldenUserOrder{}, filter)
+		}},
+		{Name: "select page 2019", Run: func() error {
+			recorder.Respond("@@VERSION", []string{"version"}, []driver.Value{"Microsoft SQL Server 2019 (RTM)"})
+			return db.SelectPage(&goldenUser{}, nil, nil, 10, 2, &goldenUserOrder{}, filter)
+		}},
+		{Name: "select page without order", Run: func() error {
+			return db.SelectPage(&goldenUser{}, nil, nil, 10, 2, nil)
+		}},
+		{Name: "transaction", Run: func() error {
+			return db.RunInTx(nil, sqldb.TxOptions{}, func(tx sqldb.SqlAccess) error {
+				_, err := tx.UpdateSelectiveByPrimaryKey(&goldenUser{ID: 7, Age: 41})
+				return err
+			})
+		}},
+		// sql server has no read only transaction, nothing is executed
+		{Name: "read only transaction", Run: func() error {
+			err := db.RunInTx(nil, sqldb.TxOptions{ReadOnly: true}, func(tx sqldb.SqlAccess) error {
+				_, err := tx.SelectCount(&goldenUser{})
+				return err
+			})
+			if err != sqldb.ErrNotSupported {
+				return fmt.Errorf("read only transaction should not be supported: %v", err)
+			}
+			return nil
+		}},
+	})
+}
+
+func TestGolden_Filter(t *testing.T) {
+	db, recorder := newGoldenDatabase()
+	minAge := 18
+	list := func(filters ...sqldb.SqlFilter) func() error {
+		return func() error {
+			return db.SelectList(&goldenUser{}, nil, &goldenUserOrder{}, filters...)
+		}
+	}
+	golden.Run(t, recorder, []golden.Case{
+		{Name: "no filter", Run: list()},
+		{Name: "field and", Run: list(db.NewFilter(&goldenUserFilter{Name: "A%", MinAge: &minAge}, false, false))},
+		{Name: "field or", Run: list(db.NewFilter(&goldenUserFilter{Name: "A%", MinAge: &minAge}, true, false))},
+		{Name: "in", Run: list(db.NewFilter(&goldenUserFilter{IDs: "(1, 2, 3)"}, false, false))},
+		{Name: "group and", Run: list(
+			db.NewFilter(&goldenUserFilter{Name: "A%"}, false, false),
+			db.NewFilter(&goldenUserFilter{MinAge: &minAge}, false, false),
+		)},
+		{Name: "group or", Run: list(
+			db.NewFilter(&goldenUserFilter{Name: "A%"}, false, false),
+			db.NewFilter(&goldenUserFilter{Name: "B%", MinAge: &minAge}, true, true),
+		)},
+		{Name: "empty filter", Run: list(db.NewFilter(&goldenUserFilter{}, false, false))},
+	})
+}
+
+type goldenNamedUser struct {
+	ID       uint64 `primary:"true" auto:"true"`
+	UserName string
+}
+
+type goldenNamedUserOrder struct {
+	UserName string `order:"DESC"`
+}
+
+func TestGolden_Naming(t *testing.T) {
+	recorder := golden.NewRecorder()
+	db := NewDatabase(recorder, sqldb.WithNaming(&sqldb.Naming{Column: sqldb.SnakeCase, Prefix: "tab_"}))
+	golden.Run(t, recorder, []golden.Case{
+		{Name: "select after", Run: func() error {
+			_, err := db.SelectAfter(&goldenNamedUser{}, nil, nil, "", 10, &goldenNamedUserOrder{})
+			return err
+		}},
+	})
+}
+
+type goldenSoftUser struct {
+	ID        uint64     `sql:"id" auto:"true" primary:"true"`
+	Name      string     `sql:"name"`
+	UpdatedAt *time.Time `sql:"updatedAt" updated:"true"`
+	UpdatedBy string     `sql:"updatedBy" updatedBy:"true"`
+	DeletedAt *time.Time `sql:"deletedAt" softdelete:"true"`
+}
+
+func (s goldenSoftUser) TableName() string {
+	return "tabSoftUser"
+}
+
+func TestGolden_SoftDelete(t *testing.T) {
+	recorder := golden.NewRecorder()
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	db := NewDatabase(recorder, sqldb.WithClock(func() time.Time {
+		return now
+	}), sqldb.WithUserFunc(func(ctx context.Context) interface{} {
+		return "admin"
+	})).WithContext(context.Background())
+	golden.Run(t, recorder, []golden.Case{
+		{Name: "soft delete", Run: func() error {
+			_, err := db.Delete(&goldenSoftUser{}, db.NewFilter(&goldenUserFilter{Name: "A%"}, false, false))
+			return err
+		}},
+		{Name: "hard delete", Run: func() error {
+			_, err := db.Delete(&goldenSoftUser{}, sqldb.HardDelete(), db.NewFilter(&goldenUserFilter{Name: "A%"}, false, false))
+			return err
+		}},
+	})
+}
+
+type goldenProfile struct {
+	ID    uint64            `sql:"id" primary:"true"`
+	Extra map[string]string `sql:"extra" codec:"json"`
+}
+
+func (s goldenProfile) TableName() string {
+	return "tabProfile"
+}
+
+func TestSelectList_Codec(t *testing.T) {
+	recorder := golden.NewRecorder()
+	recorder.Respond("tabProfile", []string{"id", "extra"},
+		[]driver.Value{int64(1), []byte(`{"a":"1"}`)},
+		[]driver.Value{int64(2), []byte(`{"b":"2"}`)},
+		[]driver.Value{int64(3), nil},
+	)
+	db := NewDatabase(recorder)
+
+	dbEntity := &goldenProfile{}
+	extras := make([]string, 0)
+	err := db.SelectList(dbEntity, func() {
+		extras = append(extras, fmt.Sprint(dbEntity.Extra))
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the entity is reused for each row, the keys of the previous rows are not kept
+	if strings.Join(extras, ";") != "map[a:1];map[b:2];map[]" {
+		t.Error("decoded maps error: ", extras)
+	}
+}
+
+func TestSelectEach_WrappedStop(t *testing.T) {
+	recorder := golden.NewRecorder()
+	recorder.Respond("tabUser", []string{"id", "name", "age", "email"},
+		[]driver.Value{int64(1), "Alice", int64(30), ""},
+		[]driver.Value{int64(2), "Bob", int64(20), ""},
+		[]driver.Value{int64(3), "Carol", int64(25), ""},
+	)
+	db := NewDatabase(recorder)
+
+	dbEntity := &goldenUser{}
+	names := make([]string, 0)
+	err := db.SelectEach(dbEntity, func() error {
+		names = append(names, dbEntity.Name)
+		if len(names) == 2 {
+			return fmt.Errorf("two names: %w", sqldb.ErrStop)
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, ",") != "Alice,Bob" {
+		t.Error("names error: ", names)
+	}
+}
+
+type goldenProfileFilter struct {
+	Extra map[string]string `sql:"extra" codec:"json"`
+}
+
+func TestSelectList_CodecFilter(t *testing.T) {
+	db, recorder := newGoldenDatabase()
+	filter := db.NewFilter(&goldenProfileFilter{Extra: map[string]string{"a": "1"}}, false, false)
+	err := db.SelectList(&goldenProfile{}, func() {}, nil, filter)
+	if err == nil {
+		t.Error("filter by codec field should be error")
+	}
+	if statements := recorder.Statements(); len(statements) > 0 {
+		t.Error("statements should not be executed: ", statements[0])
+	}
+}
+
+type goldenTypoFilter struct {
+	Name string `db:"name,fitler=like"`
+}
+
+func TestDelete_InvalidFilter(t *testing.T) {
+	db, recorder := newGoldenDatabase()
+	filter := db.NewFilter(&goldenTypoFilter{Name: "A%"}, false, false)
+	if _, err := db.Delete(&goldenUser{}, filter); err == nil {
+		t.Error("delete by invalid filter should be error")
+	}
+	if _, err := db.UpdateSelective(&goldenUser{Age: 20}, filter); err == nil {
+		t.Error("update by invalid filter should be error")
+	}
+	// nothing is executed without the conditions
+	if statements := recorder.Statements(); len(statements) > 0 {
+		t.Error("statements should not be executed: ", statements[0])
+	}
+}
+
+type goldenInvalidOrder struct {
+	Age int `sql:"age" order:"down"`
+}
+
+func (s goldenInvalidOrder) TableName() string {
+	return "tabUser"
+}
+
+type goldenInvalidOperatorFilter struct {
+	Name string `db:"name,filter=lik"`
+}
+
+func TestSelectList_InvalidTag(t *testing.T) {
+	db, recorder := newGoldenDatabase()
+	if err := db.SelectList(&goldenUser{}, func() {}, &goldenInvalidOrder{}); err == nil {
+		t.Error("select list by invalid order should be error")
+	}
+	if err := db.SelectPage(&goldenUser{}, nil, func() {}, 10, 1, &goldenInvalidOrder{}); err == nil {
+		t.Error("select page by invalid order should be error")
+	}
+	filter := db.NewFilter(&goldenInvalidOperatorFilter{Name: "A%"}, false, false)
+	if err := db.SelectList(&goldenUser{}, func() {}, nil, filter); err == nil {
+		t.Error("select list by invalid filter operator should be error")
+	}
+	// the count of the page is executed before the order is parsed
+	for _, statement := range recorder.Statements() {
+		if !strings.Contains(statement.String(), "COUNT(*)") {
+			t.Error("statements should not be executed: ", statement)
+		}
+	}
+}
+
+type goldenTenantUser struct {
+	ID       uint64 `sql:"id" auto:"true" primary:"true"`
+	TenantID uint64 `sql:"tenant_id"`
+	Age      int    `sql:"age"`
+}
+
+func (s goldenTenantUser) TableName() string {
+	return "tabTenantUser"
+}
+
+// goldenTenantAge is a partial entity of tabTenantUser without the tenant column
+type goldenTenantAge struct {
+	Age int `sql:"age"`
+}
+
+func (s goldenTenantAge) TableName() string {
+	return "tabTenantUser"
+}
+
+func TestGolden_TenantSchema(t *testing.T) {
+	recorder := golden.NewRecorder()
+	recorder.Respond("COUNT(*)", []string{"count"}, []driver.Value{int64(25)})
+	schema := func(id interface{}) string {
+		return fmt.Sprintf("tenant_%v", id)
+	}
+	db := NewDatabase(recorder, sqldb.WithSharedTables("tabUser"), sqldb.WithTenantSchema(schema)).ForTenant(uint64(7))
+	golden.Run(t, recorder, []golden.Case{
+		{Name: "tenant table", Run: func() error {
+			_, err := db.SelectCount(&goldenTenantUser{})
+			return err
+		}},
+		{Name: "shared table", Run: func() error {
+			_, err := db.SelectCount(&goldenUser{})
+			return err
+		}},
+	})
+}
+
+type goldenTenantAgeFilter struct {
+	Age *int `sql:"age"`
+}
+
+func TestGolden_Tenant(t *testing.T) {
+	recorder := golden.NewRecorder()
+	recorder.Respond("COUNT(*)", []string{"count"}, []driver.Value{int64(25)})
+	db := NewDatabase(recorder, sqldb.WithSharedTables("tabUser")).ForTenant(uint64(7))
+	age := 20
+	filter := db.NewFilter(&goldenTenantAgeFilter{Age: &age}, false, false)
+	golden.Run(t, recorder, []golden.Case{
+		{Name: "select distinct projection", Run: func() error {
+			return db.SelectDistinct(&goldenTenantUser{}, nil, nil, sqldb.Columns("age"))
+		}},
+		{Name: "update", Run: func() error {
+			_, err := db.UpdateSelective(&goldenTenantUser{Age: 21}, filter)
+			return err
+		}},
+		{Name: "delete", Run: func() error {
+			_, err := db.Delete(&goldenTenantUser{}, filter)
+			return err
+		}},
+		{Name: "shared table", Run: func() error {
+			_, err := db.SelectCount(&goldenUser{})
+			return err
+		}},
+	})
+
+	// the partial entities would read and write the rows of all tenants
+	partials := map[string]func() error{
+		"select distinct": func() error {
+			return db.SelectDistinct(&goldenTenantAge{}, nil, nil)
+		},
+		"select sum": func() error {
+			_, err := db.SelectSum(&goldenTenantAge{}, "age")
+			return err
+		},
+		"update": func() error {
+			_, err := db.UpdateSelective(&goldenTenantAge{Age: 21}, filter)
+			return err
+		},
+		"delete": func() error {
+			_, err := db.Delete(&goldenTenantAge{}, filter)
+			return err
+		},
+		"insert": func() error {
+			_, err := db.Insert(&goldenTenantAge{Age: 21})
+			return err
+		},
+	}
+	for name, run := range partials {
+		if err := run(); err != sqldb.ErrNoTenantColumn {
+			t.Error(name+" of partial entity should be error: ", err)
+		}
+	}
+	if statements := recorder.Statements(); len(statements) > 0 {
+		t.Error("statements of partial entities should not be executed: ", statements[0])
+	}
+}
@@ -0,0 +1,364 @@
+package mssql
+
+import (
+	"fmt"
+	"github.com/ktpswjz/database/sqldb"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	softDeleteNone = iota
+	// set to 1 when deleted, 0 for the rows in use
+	softDeleteFlag
+	// set to the deleting time, NULL for the rows in use
+	softDeleteTime
+)
+
+const (
+	auditNone = iota
+	auditCreated
+	auditUpdated
+	auditCreatedBy
+	auditUpdatedBy
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// metadata of a struct field, shared by all entities of the same type
+type fieldMeta struct {
+	name          string
+	path          []int
+	autoIncrement bool
+	primaryKey    bool
+	filter        string
+	order         string
+	index         int
+	lazy          bool
+	softDelete    int
+	audit         int
+	version       bool
+	codec         string
+	nullZero      bool
+}
+
+// the created columns are written by insert only
+func (s *fieldMeta) insertOnly() bool {
+	return s.audit == auditCreated || s.audit == auditCreatedBy
+}
+
+type entityMeta struct {
+	fields    []*fieldMeta
+	relations []*relationMeta
+	naming    sqldb.NamingStrategy
+	// the first error of the tags, returned on each parse
+	err error
+}
+
+// the metadata depends on the naming strategy of the database,
+// which is keyed by sqldb.NamingKey as the strategy itself may not be comparable
+type metaKey struct {
+	t      reflect.Type
+	naming interface{}
+}
+
+// metaRegistry caches the parsed metadata per struct type,
+// so that only values and addresses are bound on each call
+type metaRegistry struct {
+	sync.RWMutex
+
+	entities map[metaKey]*entityMeta
+	filters  map[metaKey]*entityMeta
+}
+
+var metas = &metaRegistry{
+	entities: make(map[metaKey]*entityMeta),
+	filters:  make(map[metaKey]*entityMeta),
+}
+
+func (s *metaRegistry) entity(t reflect.Type, naming sqldb.NamingStrategy) *entityMeta {
+	return s.load(s.entities, t, naming, false)
+}
+
+func (s *metaRegistry) filter(t reflect.Type, naming sqldb.NamingStrategy) *entityMeta {
+	return s.load(s.filters, t, naming, true)
+}
+
+func (s *metaRegistry) load(items map[metaKey]*entityMeta, t reflect.Type, naming sqldb.NamingStrategy, filter bool) *entityMeta {
+	namingKey, ok := sqldb.NamingKey(naming)
+	if !ok {
+		return newEntityMeta(t, filter, naming)
+	}
+	key := metaKey{t: t, naming: namingKey}
+
+	s.RLock()
+	meta, ok := items[key]
+	s.RUnlock()
+	if ok {
+		return meta
+	}
+
+	meta = newEntityMeta(t, filter, naming)
+
+	s.Lock()
+	defer s.Unlock()
+	items[key] = meta
+
+	return meta
+}
+
+func newEntityMeta(t reflect.Type, filter bool, naming sqldb.NamingStrategy) *entityMeta {
+	meta := &entityMeta{fields: make([]*fieldMeta, 0), naming: naming}
+	if filter {
+		meta.parseFilterFields(t)
+	} else {
+		meta.parseFields(t)
+		sort.SliceStable(meta.fields, func(i, j int) bool {
+			return meta.fields[i].index < meta.fields[j].index
+		})
+		meta.checkFields()
+	}
+
+	return meta
+}
+
+func (s *entityMeta) fail(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// the soft delete and version columns are unique in an entity
+func (s *entityMeta) checkFields() {
+	softDeleteCount := 0
+	versionCount := 0
+	for _, f := range s.fields {
+		if f.softDelete != softDeleteNone {
+			softDeleteCount++
+		}
+		if f.version {
+			versionCount++
+		}
+	}
+	if softDeleteCount > 1 {
+		s.fail(fmt.Errorf("more than one soft delete field"))
+	}
+	if versionCount > 1 {
+		s.fail(fmt.Errorf("more than one version field"))
+	}
+}
+
+// a column defined by a field at depth of embedding or flattening
+type fieldCandidate struct {
+	info  *fieldMeta
+	depth int
+}
+
+// the shallowest field of a column wins like the promoted fields of go,
+// fields of the same depth defining the same column conflict
+func (s *entityMeta) parseFields(t reflect.Type) {
+	candidates := s.collectFields(t, nil, "", 0, nil)
+
+	depths := make(map[string]int)
+	counts := make(map[string]int)
+	for _, candidate := range candidates {
+		depth, ok := depths[candidate.info.name]
+		if !ok || candidate.depth < depth {
+			depths[candidate.info.name] = candidate.depth
+			counts[candidate.info.name] = 1
+		} else if candidate.depth == depth {
+			counts[candidate.info.name]++
+		}
+	}
+
+	for _, candidate := range candidates {
+		name := candidate.info.name
+		if candidate.depth != depths[name] {
+			continue
+		}
+		if counts[name] > 1 {
+			s.fail(fmt.Errorf("column %s is defined by more than one field", name))
+			counts[name] = 0
+			continue
+		}
+		if counts[name] == 1 {
+			s.fields = append(s.fields, candidate.info)
+		}
+	}
+}
+
+// each field of the filter is a condition
+func (s *entityMeta) parseFilterFields(t reflect.Type) {
+	for _, candidate := range s.collectFields(t, nil, "", 0, nil) {
+		s.fields = append(s.fields, candidate.info)
+	}
+}
+
+// collectFields walks the struct in order of declaration, the embedded structs and pointers
+// and the struct fields tagged with `prefix` are flattened one level deeper
+func (s *entityMeta) collectFields(t reflect.Type, parent []int, prefix string, depth int, visiting []reflect.Type) []*fieldCandidate {
+	candidates := make([]*fieldCandidate, 0)
+	if t.Kind() != reflect.Struct {
+		return candidates
+	}
+	// recursive embedding
+	for _, visited := range visiting {
+		if visited == t {
+			return candidates
+		}
+	}
+	visiting = append(visiting, t)
+
+	n := t.NumField()
+	for i := 0; i < n; i++ {
+		typeField := t.Field(i)
+		// ignore private field
+		if typeField.PkgPath != "" {
+			continue
+		}
+
+		path := fieldPath(parent, i)
+		// related entities are not columns
+		if tag, ok := typeField.Tag.Lookup(sqlFieldRelationTagName); ok {
+			relation, err := newRelationMeta(typeField, path, tag)
+			if err != nil {
+				s.fail(err)
+			} else {
+				s.relations = append(s.relations, relation)
+			}
+			continue
+		}
+		fieldType := typeField.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		// parent struct fields
+		if typeField.Anonymous {
+			if fieldType.Kind() == reflect.Struct {
+				candidates = append(candidates, s.collectFields(fieldType, path, prefix, depth+1, visiting)...)
+			}
+			continue
+		}
+		// flatten struct field
+		if fieldPrefix, ok := typeField.Tag.Lookup(sqlFieldPrefixTagName); ok && fieldType.Kind() == reflect.Struct {
+			candidates = append(candidates, s.collectFields(fieldType, path, prefix+fieldPrefix, depth+1, visiting)...)
+			continue
+		}
+
+		info, err := s.newFieldMeta(typeField, path, prefix)
+		if err != nil {
+			s.fail(err)
+			continue
+		}
+		if info == nil {
+			continue
+		}
+		candidates = append(candidates, &fieldCandidate{info: info, depth: depth})
+	}
+
+	return candidates
+}
+
+func (s *entityMeta) newFieldMeta(typeField reflect.StructField, path []int, prefix string) (*fieldMeta, error) {
+	options, err := parseFieldOptions(typeField)
+	if err != nil {
+		return nil, err
+	}
+
+	// filed define, the untagged field is named by the naming strategy if any
+	fieldName := options[fieldOptionName]
+	if fieldName == "-" {
+		return nil, nil
+	}
+	if fieldName == "" {
+		if s.naming == nil {
+			if _, ok := typeField.Tag.Lookup(sqlFieldCombinedTagName); ok {
+				return nil, fmt.Errorf("field %s: column name is empty", typeField.Name)
+			}
+			return nil, nil
+		}
+		fieldName = s.naming.ColumnName(typeField.Name)
+	}
+	fieldName = prefix + fieldName
+
+	info := &fieldMeta{name: fmt.Sprintf("[%s]", fieldName), path: path, filter: "=", order: "ASC"}
+	for key, value := range options {
+		switch key {
+		case fieldOptionPrimaryKey:
+			info.primaryKey = true
+		case fieldOptionAutoIncrement:
+			info.autoIncrement = true
+		case fieldOptionFilter:
+			if !filterOperators[strings.ToLower(value)] {
+				return nil, fmt.Errorf("field %s: invalid filter '%s'", typeField.Name, value)
+			}
+			info.filter = value
+		case fieldOptionOrder:
+			switch strings.ToUpper(value) {
+			case "ASC", "DESC":
+				info.order = strings.ToUpper(value)
+			default:
+				return nil, fmt.Errorf("field %s: invalid order '%s'", typeField.Name, value)
+			}
+		case fieldOptionIndex:
+			info.index, err = strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: invalid index '%s'", typeField.Name, value)
+			}
+		case fieldOptionLazy:
+			info.lazy = true
+		case fieldOptionSoftDelete:
+			info.softDelete = softDeleteFlag
+			if isTimeType(typeField.Type) {
+				info.softDelete = softDeleteTime
+			}
+		case fieldOptionVersion:
+			info.version = true
+		case fieldOptionCodec:
+			info.codec = value
+		case fieldOptionNull:
+			if strings.ToLower(value) != "zero" {
+				return nil, fmt.Errorf("field %s: invalid null '%s'", typeField.Name, value)
+			}
+			info.nullZero = true
+		case fieldOptionCreated, fieldOptionUpdated, fieldOptionCreatedBy, fieldOptionUpdatedBy:
+			if info.audit != auditNone {
+				return nil, fmt.Errorf("field %s: more than one audit option", typeField.Name)
+			}
+			info.audit = auditOptions[key]
+		}
+	}
+
+	return info, nil
+}
+
+func fieldPath(parent []int, i int) []int {
+	path := make([]int, len(parent)+1)
+	copy(path, parent)
+	path[len(parent)] = i
+
+	return path
+}
+
+// time.Time, *time.Time or nullable time such as sql.NullTime
+func isTimeType(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return true
+	}
+	if t.Kind() == reflect.Struct {
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).Type == timeType {
+				return true
+			}
+		}
+	}
+
+	return false
+}
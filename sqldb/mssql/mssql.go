@@ -1,6 +1,7 @@
 package mssql
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"github.com/ktpswjz/database/sqldb"
@@ -12,10 +13,33 @@ import (
 
 type mssql struct {
 	connection sqldb.SqlConnection
+	config     *sqldb.Config
+	ctx        context.Context
 }
 
-func NewDatabase(conn sqldb.SqlConnection) sqldb.SqlDatabase {
-	return &mssql{connection: conn}
+func NewDatabase(conn sqldb.SqlConnection, options ...sqldb.Option) sqldb.SqlDatabase {
+	return &mssql{connection: conn, config: sqldb.NewConfig(options...)}
+}
+
+// WithContext returns a copy of the database whose accesses carry ctx, such as the user set by sqldb.ContextWithUser
+func (s *mssql) WithContext(ctx context.Context) sqldb.SqlDatabase {
+	return &mssql{connection: s.connection, config: s.config, ctx: ctx}
+}
+
+// ForTenant returns a copy of the database scoped to the tenant id,
+// options such as sqldb.WithRawQuery apply to the copy only
+func (s *mssql) ForTenant(id interface{}, options ...sqldb.Option) sqldb.SqlDatabase {
+	config := s.config.With(sqldb.WithTenant(id)).With(options...)
+
+	return &mssql{connection: s.connection, config: config, ctx: s.ctx}
+}
+
+func (s *mssql) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+
+	return s.ctx
 }
 
 func (s *mssql) Open() (*sql.DB, error) {
@@ -419,26 +443,51 @@ func (s *mssql) columnDateDefault(value string) string {
 }
 
 func (s *mssql) NewAccess(transactional bool) (sqldb.SqlAccess, error) {
+	if transactional {
+		return s.NewAccessTx(s.context(), sqldb.TxOptions{})
+	}
+
 	db, err := sql.Open(s.connection.DriverName(), s.connection.SourceName())
 	if err != nil {
 		return nil, err
 	}
 
-	if transactional {
-		tx, err := db.Begin()
-		if err != nil {
-			db.Close()
-			return nil, err
-		}
+	return &normal{access: access{config: s.config, ctx: s.ctx}, db: db}, nil
+}
+
+func (s *mssql) NewAccessTx(ctx context.Context, opts sqldb.TxOptions) (sqldb.SqlAccess, error) {
+	if ctx == nil {
+		ctx = s.context()
+	}
+	// sql server has no read only transaction, the caller is told rather than given a writable one
+	if opts.ReadOnly {
+		return nil, sqldb.ErrNotSupported
+	}
+
+	db, err := sql.Open(s.connection.DriverName(), s.connection.SourceName())
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.BeginTx(ctx, opts.SqlOptions())
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
 
-		return &transaction{db: db, tx: tx}, nil
+	return &transaction{access: access{config: s.config, ctx: ctx}, db: db, tx: tx}, nil
+}
+
+func (s *mssql) RunInTx(ctx context.Context, opts sqldb.TxOptions, fn func(tx sqldb.SqlAccess) error) error {
+	begin := func() (sqldb.SqlAccess, error) {
+		return s.NewAccessTx(ctx, opts)
 	}
 
-	return &normal{db: db}, nil
+	return sqldb.RunInTx(ctx, opts, begin, s.IsRetryable, fn)
 }
 
 func (s *mssql) NewEntity() sqldb.SqlEntity {
-	return &entity{}
+	return &entity{config: s.config}
 }
 
 func (s *mssql) NewBuilder() sqldb.SqlBuilder {
@@ -464,6 +513,22 @@ func (s *mssql) IsNoRows(err error) bool {
 	return false
 }
 
+func (s *mssql) IsRetryable(err error) bool {
+	sqlAccess := &access{}
+
+	return sqlAccess.isRetryable(err)
+}
+
+func (s *mssql) QueryInto(dest interface{}, query string, args ...interface{}) error {
+	sqlAccess, err := s.NewAccess(false)
+	if err != nil {
+		return err
+	}
+	defer sqlAccess.Close()
+
+	return sqlAccess.QueryInto(dest, query, args...)
+}
+
 func (s *mssql) Insert(entity interface{}) (uint64, error) {
 	sqlAccess, err := s.NewAccess(false)
 	if err != nil {
@@ -564,6 +629,33 @@ func (s *mssql) SelectList(entity interface{}, row func(), order interface{}, fi
 	return sqlAccess.SelectList(entity, row, order, filters...)
 }
 
+func (s *mssql) SelectEach(entity interface{}, row func() error, order interface{}, filters ...sqldb.SqlFilter) error {
+	sqlAccess, err := s.NewAccess(false)
+	if err != nil {
+		return err
+	}
+	defer sqlAccess.Close()
+
+	return sqlAccess.SelectEach(entity, row, order, filters...)
+}
+
+// the connection is released when the iterator is closed
+func (s *mssql) Iterate(entity interface{}, order interface{}, filters ...sqldb.SqlFilter) (sqldb.SqlIterator, error) {
+	sqlAccess, err := s.NewAccess(false)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := sqlAccess.Iterate(entity, order, filters...)
+	if err != nil {
+		sqlAccess.Close()
+		return nil, err
+	}
+	rows.(*iterator).release = sqlAccess.Close
+
+	return rows, nil
+}
+
 func (s *mssql) SelectPage(entity interface{}, page func(total, page, size, index uint64), row func(), size, index uint64, order interface{}, filters ...sqldb.SqlFilter) error {
 	sqlAccess, err := s.NewAccess(false)
 	if err != nil {
@@ -574,6 +666,16 @@ func (s *mssql) SelectPage(entity interface{}, page func(total, page, size, inde
 	return sqlAccess.SelectPage(entity, page, row, size, index, order, filters...)
 }
 
+func (s *mssql) SelectAfter(entity interface{}, page func(total uint64), row func(), cursor string, size uint64, order interface{}, filters ...sqldb.SqlFilter) (*sqldb.SqlCursor, error) {
+	sqlAccess, err := s.NewAccess(false)
+	if err != nil {
+		return nil, err
+	}
+	defer sqlAccess.Close()
+
+	return sqlAccess.SelectAfter(entity, page, row, cursor, size, order, filters...)
+}
+
 func (s *mssql) SelectCount(entity interface{}, filters ...sqldb.SqlFilter) (uint64, error) {
 	sqlAccess, err := s.NewAccess(false)
 	if err != nil {
@@ -583,3 +685,63 @@ func (s *mssql) SelectCount(entity interface{}, filters ...sqldb.SqlFilter) (uin
 
 	return sqlAccess.SelectCount(entity, filters...)
 }
+
+func (s *mssql) SelectSum(entity interface{}, column string, filters ...sqldb.SqlFilter) (float64, error) {
+	sqlAccess, err := s.NewAccess(false)
+	if err != nil {
+		return 0, err
+	}
+	defer sqlAccess.Close()
+
+	return sqlAccess.SelectSum(entity, column, filters...)
+}
+
+func (s *mssql) SelectAvg(entity interface{}, column string, filters ...sqldb.SqlFilter) (float64, error) {
+	sqlAccess, err := s.NewAccess(false)
+	if err != nil {
+		return 0, err
+	}
+	defer sqlAccess.Close()
+
+	return sqlAccess.SelectAvg(entity, column, filters...)
+}
+
+func (s *mssql) SelectMax(entity interface{}, column string, filters ...sqldb.SqlFilter) (interface{}, error) {
+	sqlAccess, err := s.NewAccess(false)
+	if err != nil {
+		return nil, err
+	}
+	defer sqlAccess.Close()
+
+	return sqlAccess.SelectMax(entity, column, filters...)
+}
+
+func (s *mssql) SelectMin(entity interface{}, column string, filters ...sqldb.SqlFilter) (interface{}, error) {
+	sqlAccess, err := s.NewAccess(false)
+	if err != nil {
+		return nil, err
+	}
+	defer sqlAccess.Close()
+
+	return sqlAccess.SelectMin(entity, column, filters...)
+}
+
+func (s *mssql) SelectGroupCount(entity interface{}, groupColumns []string, filters ...sqldb.SqlFilter) ([]*sqldb.SqlGroupCount, error) {
+	sqlAccess, err := s.NewAccess(false)
+	if err != nil {
+		return nil, err
+	}
+	defer sqlAccess.Close()
+
+	return sqlAccess.SelectGroupCount(entity, groupColumns, filters...)
+}
+
+func (s *mssql) Exists(entity interface{}, filters ...sqldb.SqlFilter) (bool, error) {
+	sqlAccess, err := s.NewAccess(false)
+	if err != nil {
+		return false, err
+	}
+	defer sqlAccess.Close()
+
+	return sqlAccess.Exists(entity, filters...)
+}
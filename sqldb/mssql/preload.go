@@ -0,0 +1,340 @@
+package mssql
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"github.com/ktpswjz/database/sqldb"
+	"reflect"
+	"strings"
+)
+
+const (
+	relationBelongsTo = "belongsTo"
+	relationHasOne    = "hasOne"
+	relationHasMany   = "hasMany"
+
+	// keys per IN query, below the parameter limit of the databases
+	preloadBatchSize = 500
+)
+
+// relationMeta is a field holding the related entities, tagged with `rel:"kind,fk=column"`:
+// belongsTo: the fk column of this entity refers to the primary key of the related entity, e.g. Customer *Customer
+// hasMany: the fk column of the related entities refers to the primary key of this entity, e.g. Items []*Item
+// hasOne: like hasMany with one related entity.
+// The referred column is given by "ref=column" if it is not the primary key
+type relationMeta struct {
+	name string
+	kind string
+	path []int
+	fk   string
+	ref  string
+
+	// struct type of the related entity, the field holds pointers if ptr
+	elem reflect.Type
+	ptr  bool
+}
+
+func newRelationMeta(typeField reflect.StructField, path []int, tag string) (*relationMeta, error) {
+	parts := strings.Split(tag, ",")
+	info := &relationMeta{name: typeField.Name, kind: strings.TrimSpace(parts[0]), path: path}
+	for _, part := range parts[1:] {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		i := strings.Index(part, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("relation %s: invalid option '%s'", info.name, part)
+		}
+		key, value := strings.TrimSpace(part[:i]), strings.TrimSpace(part[i+1:])
+		switch key {
+		case "fk":
+			info.fk = value
+		case "ref":
+			info.ref = value
+		default:
+			return nil, fmt.Errorf("relation %s: unknown option '%s'", info.name, key)
+		}
+	}
+	if info.fk == "" {
+		return nil, fmt.Errorf("relation %s: fk is required", info.name)
+	}
+
+	t := typeField.Type
+	switch info.kind {
+	case relationBelongsTo, relationHasOne:
+	case relationHasMany:
+		if t.Kind() != reflect.Slice {
+			return nil, fmt.Errorf("relation %s: %s is not slice", info.name, t)
+		}
+		t = t.Elem()
+	default:
+		return nil, fmt.Errorf("relation %s: unknown kind '%s'", info.name, info.kind)
+	}
+	if t.Kind() == reflect.Ptr {
+		info.ptr = true
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("relation %s: %s is not struct", info.name, t)
+	}
+	info.elem = t
+
+	return info, nil
+}
+
+// names listed by sqldb.Preload in the filters
+func (s *access) getPreloads(sqlFilters []sqldb.SqlFilter) []string {
+	var names []string
+	for _, sqlFilter := range sqlFilters {
+		if option, ok := sqlFilter.(*sqldb.PreloadOption); ok {
+			names = append(names, option.Names...)
+		}
+	}
+
+	return names
+}
+
+func (s *access) getRelation(sqlEntity *entity, name string) (*relationMeta, error) {
+	for _, relation := range sqlEntity.relations {
+		if relation.name == name {
+			return relation, nil
+		}
+	}
+
+	return nil, fmt.Errorf("relation %s not in entity %s", name, sqlEntity.name)
+}
+
+// the columns of the entity referred by the preloaded relations
+func (s *access) getPreloadColumns(sqlEntity *entity, sqlFilters []sqldb.SqlFilter) ([]string, error) {
+	columns := make([]string, 0)
+	for _, name := range s.getPreloads(sqlFilters) {
+		relation, err := s.getRelation(sqlEntity, name)
+		if err != nil {
+			return nil, err
+		}
+		key, err := s.getOwnerKey(sqlEntity, relation)
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, key.name)
+	}
+
+	return columns, nil
+}
+
+// the column of the entity whose values are looked up in the related table
+func (s *access) getOwnerKey(sqlEntity *entity, relation *relationMeta) (*field, error) {
+	if relation.kind == relationBelongsTo {
+		return sqlEntity.fieldByColumn(relation.fk)
+	}
+
+	return s.getReferredKey(sqlEntity, relation.ref)
+}
+
+// the column named by ref, or the only primary key
+func (s *access) getReferredKey(sqlEntity *entity, ref string) (*field, error) {
+	if ref != "" {
+		return sqlEntity.fieldByColumn(ref)
+	}
+
+	var key *field
+	for _, f := range sqlEntity.fields {
+		if !f.primaryKey {
+			continue
+		}
+		if key != nil {
+			return nil, fmt.Errorf("entity %s has more than one primary key, ref is required", sqlEntity.name)
+		}
+		key = f
+	}
+	if key == nil {
+		return nil, fmt.Errorf("entity %s has no primary key, ref is required", sqlEntity.name)
+	}
+
+	return key, nil
+}
+
+// preload loads the relations listed in the filters into items, which are the addressable structs of the entity
+func (s *access) preload(sqlAccess sqldb.SqlAccess, sqlEntity *entity, items []reflect.Value, sqlFilters []sqldb.SqlFilter) error {
+	for _, name := range s.getPreloads(sqlFilters) {
+		relation, err := s.getRelation(sqlEntity, name)
+		if err != nil {
+			return err
+		}
+		err = s.preloadRelation(sqlAccess, sqlEntity, relation, items)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// preloadRows preloads the relations of the buffered rows, then sets each of them to dbEntity and calls row
+func (s *access) preloadRows(sqlAccess sqldb.SqlAccess, sqlEntity *entity, dbEntity interface{}, items []reflect.Value, row func() error, sqlFilters []sqldb.SqlFilter) error {
+	err := s.preload(sqlAccess, sqlEntity, items, sqlFilters)
+	if err != nil {
+		return err
+	}
+	if row == nil {
+		return nil
+	}
+
+	target := reflect.ValueOf(dbEntity).Elem()
+	for _, item := range items {
+		target.Set(item)
+		err = row()
+		if errors.Is(err, sqldb.ErrStop) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *access) preloadRelation(sqlAccess sqldb.SqlAccess, sqlEntity *entity, relation *relationMeta, items []reflect.Value) error {
+	ownerKey, err := s.getOwnerKey(sqlEntity, relation)
+	if err != nil {
+		return err
+	}
+	target := reflect.New(relation.elem)
+	related := s.newEntity()
+	err = related.Parse(target.Interface())
+	if err != nil {
+		return err
+	}
+	var relatedKey *field
+	if relation.kind == relationBelongsTo {
+		relatedKey, err = s.getReferredKey(related, relation.ref)
+	} else {
+		relatedKey, err = related.fieldByColumn(relation.fk)
+	}
+	if err != nil {
+		return err
+	}
+
+	keys := make([]interface{}, 0, len(items))
+	added := make(map[string]bool)
+	for _, item := range items {
+		value, _ := fieldByPath(item, ownerKey.path, true)
+		key, ok := relationKey(value)
+		if !ok || added[key] {
+			continue
+		}
+		added[key] = true
+		keys = append(keys, value.Interface())
+	}
+
+	groups := make(map[string][]reflect.Value)
+	for start := 0; start < len(keys); start += preloadBatchSize {
+		end := start + preloadBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		err = s.selectRelated(sqlAccess, related, target, relatedKey, keys[start:end], groups)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, item := range items {
+		target, _ := fieldByPath(item, relation.path, true)
+		owner, _ := fieldByPath(item, ownerKey.path, true)
+		key, _ := relationKey(owner)
+		values := groups[key]
+		if relation.kind == relationHasMany {
+			slice := reflect.MakeSlice(target.Type(), 0, len(values))
+			for _, value := range values {
+				slice = reflect.Append(slice, relatedValue(value, relation.ptr))
+			}
+			target.Set(slice)
+			continue
+		}
+
+		if len(values) > 0 {
+			target.Set(relatedValue(values[0], relation.ptr))
+		} else {
+			target.Set(reflect.Zero(target.Type()))
+		}
+	}
+
+	return nil
+}
+
+// selectRelated selects the related entities whose key is in keys, grouped by the key,
+// related is parsed from the pointer target which receives each row
+func (s *access) selectRelated(sqlAccess sqldb.SqlAccess, related *entity, target reflect.Value, relatedKey *field, keys []interface{}, groups map[string][]reflect.Value) error {
+	sqlBuilder := &builder{}
+	sqlBuilder.Reset()
+	sqlBuilder.Select(related.ScanFields(), false).From(related.Name())
+	_, err := s.fillTenantWhere(sqlBuilder, related)
+	if err != nil {
+		return err
+	}
+	scope := related.scope()
+	if scope != "" {
+		sqlBuilder.WhereAnd(scope)
+	}
+	names := sqlBuilder.argNames(len(keys))
+	sqlBuilder.WhereAnd(fmt.Sprintf("%s IN (%s)", relatedKey.name, strings.Join(names, ", ")), keys...)
+
+	rows, err := sqlAccess.Query(sqlBuilder.Query(), sqlBuilder.Args()...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	scanArgs := related.ScanArgs()
+	for rows.Next() {
+		err = rows.Scan(scanArgs...)
+		if err != nil {
+			return err
+		}
+		err = s.afterScan(related, target.Interface())
+		if err != nil {
+			return err
+		}
+
+		item := related.clone(target.Elem())
+		value, _ := fieldByPath(item, relatedKey.path, true)
+		key, ok := relationKey(value)
+		if !ok {
+			continue
+		}
+		groups[key] = append(groups[key], item)
+	}
+
+	return rows.Err()
+}
+
+func relatedValue(value reflect.Value, ptr bool) reflect.Value {
+	if !ptr {
+		return value
+	}
+
+	return value.Addr()
+}
+
+// relationKey is the text of the key value to match the related rows, false for NULL
+func relationKey(value reflect.Value) (string, bool) {
+	if valuer, ok := value.Interface().(driver.Valuer); ok {
+		v, err := valuer.Value()
+		if err != nil || v == nil {
+			return "", false
+		}
+		return fmt.Sprint(v), true
+	}
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return "", false
+		}
+		value = value.Elem()
+	}
+
+	return fmt.Sprint(value.Interface()), true
+}
@@ -0,0 +1,180 @@
+package mssql
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// the combined tag, e.g. `db:"name,pk,auto,filter=like,order=desc,index=2"`
+const sqlFieldCombinedTagName = "db"
+
+// options of a field, the legacy tags are mapped to the same options
+const (
+	fieldOptionName          = "name"
+	fieldOptionPrimaryKey    = "pk"
+	fieldOptionAutoIncrement = "auto"
+	fieldOptionFilter        = "filter"
+	fieldOptionOrder         = "order"
+	fieldOptionIndex         = "index"
+	fieldOptionLazy          = "lazy"
+	fieldOptionSoftDelete    = "softdelete"
+	fieldOptionCreated       = "created"
+	fieldOptionUpdated       = "updated"
+	fieldOptionCreatedBy     = "createdBy"
+	fieldOptionUpdatedBy     = "updatedBy"
+	fieldOptionVersion       = "version"
+	fieldOptionCodec         = "codec"
+	fieldOptionNull          = "null"
+)
+
+var (
+	// options without value in the combined tag, set by "true" in the legacy tags
+	flagOptions = map[string]bool{
+		fieldOptionPrimaryKey:    true,
+		fieldOptionAutoIncrement: true,
+		fieldOptionLazy:          true,
+		fieldOptionSoftDelete:    true,
+		fieldOptionCreated:       true,
+		fieldOptionUpdated:       true,
+		fieldOptionCreatedBy:     true,
+		fieldOptionUpdatedBy:     true,
+		fieldOptionVersion:       true,
+	}
+
+	valueOptions = map[string]bool{
+		fieldOptionFilter: true,
+		fieldOptionOrder:  true,
+		fieldOptionIndex:  true,
+		fieldOptionCodec:  true,
+		fieldOptionNull:   true,
+	}
+
+	// legacy tag name to option
+	legacyTags = map[string]string{
+		sqlFieldPrimaryKeyTagName:    fieldOptionPrimaryKey,
+		sqlFieldAutoIncrementTagName: fieldOptionAutoIncrement,
+		sqlFieldFilterTagName:        fieldOptionFilter,
+		sqlFieldOrderTagName:         fieldOptionOrder,
+		sqlFieldIndexTagName:         fieldOptionIndex,
+		sqlFieldSoftDeleteTagName:    fieldOptionSoftDelete,
+		sqlFieldCreatedTagName:       fieldOptionCreated,
+		sqlFieldUpdatedTagName:       fieldOptionUpdated,
+		sqlFieldCreatedByTagName:     fieldOptionCreatedBy,
+		sqlFieldUpdatedByTagName:     fieldOptionUpdatedBy,
+		sqlFieldVersionTagName:       fieldOptionVersion,
+		sqlFieldCodecTagName:         fieldOptionCodec,
+		sqlFieldNullTagName:          fieldOptionNull,
+	}
+
+	optionAliases = map[string]string{
+		"primary": fieldOptionPrimaryKey,
+	}
+
+	auditOptions = map[string]int{
+		fieldOptionCreated:   auditCreated,
+		fieldOptionUpdated:   auditUpdated,
+		fieldOptionCreatedBy: auditCreatedBy,
+		fieldOptionUpdatedBy: auditUpdatedBy,
+	}
+	// operators of the filter option, the value of "in" is written into the query as it is
+	filterOperators = map[string]bool{
+		"=":        true,
+		"!=":       true,
+		"<>":       true,
+		">":        true,
+		">=":       true,
+		"<":        true,
+		"<=":       true,
+		"like":     true,
+		"not like": true,
+		"in":       true,
+	}
+)
+
+// parseFieldOptions merges the legacy tags and the combined tag of the field,
+// an option defined twice with different values is a conflict
+func parseFieldOptions(typeField reflect.StructField) (map[string]string, error) {
+	options := make(map[string]string)
+	set := func(key, value string) error {
+		if old, ok := options[key]; ok && !strings.EqualFold(old, value) {
+			return fmt.Errorf("field %s: conflicting %s '%s' and '%s'", typeField.Name, key, old, value)
+		}
+		options[key] = value
+		return nil
+	}
+
+	name, ok := typeField.Tag.Lookup(sqlFieldTagName)
+	if ok && name != "" {
+		options[fieldOptionName] = name
+	}
+	for tagName, key := range legacyTags {
+		value, ok := typeField.Tag.Lookup(tagName)
+		if !ok || value == "" {
+			continue
+		}
+		// the flags are "true" or "false", other values are typos never taken as off silently
+		if flagOptions[key] {
+			switch strings.ToLower(value) {
+			case "true":
+				value = "true"
+			case "false":
+				continue
+			default:
+				return nil, fmt.Errorf("field %s: invalid %s tag '%s', expect true or false", typeField.Name, tagName, value)
+			}
+		}
+		options[key] = value
+	}
+	if typeField.Tag.Get(sqlFieldSelectTagName) == "-" {
+		options[fieldOptionLazy] = "true"
+	}
+
+	tag, ok := typeField.Tag.Lookup(sqlFieldCombinedTagName)
+	if !ok {
+		return options, nil
+	}
+	parts := strings.Split(tag, ",")
+	name = strings.TrimSpace(parts[0])
+	if name != "" {
+		err := set(fieldOptionName, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, part := range parts[1:] {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		key, value := part, ""
+		hasValue := false
+		if i := strings.Index(part, "="); i >= 0 {
+			key, value, hasValue = strings.TrimSpace(part[:i]), strings.TrimSpace(part[i+1:]), true
+		}
+		if alias, ok := optionAliases[key]; ok {
+			key = alias
+		}
+
+		if flagOptions[key] {
+			if hasValue {
+				return nil, fmt.Errorf("field %s: option %s has no value", typeField.Name, key)
+			}
+			value = "true"
+		} else if valueOptions[key] {
+			if value == "" {
+				return nil, fmt.Errorf("field %s: option %s requires a value", typeField.Name, key)
+			}
+		} else {
+			return nil, fmt.Errorf("field %s: unknown option '%s'", typeField.Name, key)
+		}
+
+		err := set(key, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return options, nil
+}
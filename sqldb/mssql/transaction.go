@@ -3,30 +3,139 @@ package mssql
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"github.com/ktpswjz/database/sqldb"
 	"strconv"
 	"strings"
 )
 
+const (
+	txActive = iota
+	txCommitted
+	txRolledBack
+)
+
 type transaction struct {
 	access
 
 	db *sql.DB
 	tx *sql.Tx
+
+	state      int
+	onCommit   []func()
+	onRollback []func()
 }
 
+// rollback the transaction if it is still active, then release the connection
 func (s *transaction) Close() error {
 	defer s.db.Close()
 
-	return s.tx.Rollback()
+	if s.state != txActive {
+		return nil
+	}
+
+	return s.Rollback()
 }
 
 func (s *transaction) Commit() error {
-	return s.tx.Commit()
+	if s.state != txActive {
+		return sql.ErrTxDone
+	}
+
+	err := s.tx.Commit()
+	if err != nil {
+		// the transaction can not be used any more after a failed commit
+		s.finish(txRolledBack)
+		return err
+	}
+	s.finish(txCommitted)
+
+	return nil
 }
 
 func (s *transaction) Rollback() error {
-	return s.tx.Rollback()
+	if s.state != txActive {
+		return sql.ErrTxDone
+	}
+
+	err := s.tx.Rollback()
+	s.finish(txRolledBack)
+
+	return err
+}
+
+func (s *transaction) InTransaction() bool {
+	return s.state == txActive
+}
+
+// fn is called after the transaction committed successfully
+func (s *transaction) OnCommit(fn func()) {
+	if fn == nil {
+		return
+	}
+
+	switch s.state {
+	case txActive:
+		s.onCommit = append(s.onCommit, fn)
+	case txCommitted:
+		fn()
+	}
+}
+
+// fn is called after the transaction rolled back
+func (s *transaction) OnRollback(fn func()) {
+	if fn == nil {
+		return
+	}
+
+	switch s.state {
+	case txActive:
+		s.onRollback = append(s.onRollback, fn)
+	case txRolledBack:
+		fn()
+	}
+}
+
+func (s *transaction) finish(state int) {
+	s.state = state
+
+	callbacks := s.onRollback
+	if state == txCommitted {
+		callbacks = s.onCommit
+	}
+	s.onCommit = nil
+	s.onRollback = nil
+
+	for _, callback := range callbacks {
+		callback()
+	}
+}
+
+func (s *transaction) Savepoint(name string) error {
+	err := s.checkSavepointName(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.tx.Exec(fmt.Sprintf("SAVE TRANSACTION [%s]", name))
+
+	return err
+}
+
+func (s *transaction) RollbackTo(name string) error {
+	err := s.checkSavepointName(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.tx.Exec(fmt.Sprintf("ROLLBACK TRANSACTION [%s]", name))
+
+	return err
+}
+
+func (s *transaction) Release(name string) error {
+	// savepoints are released along with the transaction in sql server
+	return s.checkSavepointName(name)
 }
 
 func (s *transaction) Version() int {
@@ -48,35 +157,75 @@ func (s *transaction) Version() int {
 }
 
 func (s *transaction) Exec(query string, args ...interface{}) (sql.Result, error) {
+	err := s.config.CheckRawQuery()
+	if err != nil {
+		return nil, err
+	}
+
 	return s.tx.Exec(query, args...)
 }
 
 func (s *transaction) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	err := s.config.CheckRawQuery()
+	if err != nil {
+		return nil, err
+	}
+
 	return s.tx.ExecContext(ctx, query, args...)
 }
 
 func (s *transaction) Prepare(query string) (*sql.Stmt, error) {
+	err := s.config.CheckRawQuery()
+	if err != nil {
+		return nil, err
+	}
+
 	return s.tx.Prepare(query)
 }
 
 func (s *transaction) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	err := s.config.CheckRawQuery()
+	if err != nil {
+		return nil, err
+	}
+
 	return s.tx.PrepareContext(ctx, query)
 }
 
 func (s *transaction) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	err := s.config.CheckRawQuery()
+	if err != nil {
+		return nil, err
+	}
+
 	return s.tx.Query(query, args...)
 }
 
 func (s *transaction) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	err := s.config.CheckRawQuery()
+	if err != nil {
+		return nil, err
+	}
+
 	return s.tx.QueryContext(ctx, query, args...)
 }
 
 func (s *transaction) QueryRow(query string, args ...interface{}) *sql.Row {
+	err := s.config.CheckRawQuery()
+	if err != nil {
+		return s.tx.QueryRowContext(&rejectedContext{Context: context.Background(), err: err}, query, args...)
+	}
+
 	return s.tx.QueryRow(query, args...)
 }
 
 func (s *transaction) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
-	return s.tx.QueryRowContext(ctx, query, args)
+	err := s.config.CheckRawQuery()
+	if err != nil {
+		ctx = &rejectedContext{Context: ctx, err: err}
+	}
+
+	return s.tx.QueryRowContext(ctx, query, args...)
 }
 
 func (s *transaction) Stmt(stmt *sql.Stmt) *sql.Stmt {
@@ -87,60 +236,109 @@ func (s *transaction) StmtContext(ctx context.Context, stmt *sql.Stmt) *sql.Stmt
 	return s.tx.StmtContext(ctx, stmt)
 }
 
+func (s *transaction) QueryInto(dest interface{}, query string, args ...interface{}) error {
+	return s.queryInto(s, dest, query, args...)
+}
+
 func (s *transaction) IsNoRows(err error) bool {
 	return s.isNoRows(err)
 }
 
+func (s *transaction) IsRetryable(err error) bool {
+	return s.isRetryable(err)
+}
+
 func (s *transaction) Insert(entity interface{}) (uint64, error) {
-	return s.insert(s, false, entity)
+	return s.insert(s.trusted(s, s.tx), false, entity)
 }
 
 func (s *transaction) InsertSelective(entity interface{}) (uint64, error) {
-	return s.insert(s, true, entity)
+	return s.insert(s.trusted(s, s.tx), true, entity)
 }
 
 func (s *transaction) Delete(entity interface{}, filters ...sqldb.SqlFilter) (uint64, error) {
-	return s.delete(s, entity, filters...)
+	return s.delete(s.trusted(s, s.tx), entity, filters...)
 }
 
 func (s *transaction) Update(entity interface{}, filters ...sqldb.SqlFilter) (uint64, error) {
-	return s.update(s, false, entity, filters...)
+	return s.update(s.trusted(s, s.tx), false, entity, filters...)
 }
 
 func (s *transaction) UpdateSelective(entity interface{}, filters ...sqldb.SqlFilter) (uint64, error) {
-	return s.update(s, true, entity, filters...)
+	return s.update(s.trusted(s, s.tx), true, entity, filters...)
 }
 
 func (s *transaction) UpdateByPrimaryKey(entity interface{}) (uint64, error) {
-	return s.updateByPrimaryKey(s, false, entity)
+	return s.updateByPrimaryKey(s.trusted(s, s.tx), false, entity)
 }
 
 func (s *transaction) UpdateSelectiveByPrimaryKey(entity interface{}) (uint64, error) {
-	return s.updateByPrimaryKey(s, true, entity)
+	return s.updateByPrimaryKey(s.trusted(s, s.tx), true, entity)
 }
 
 func (s *transaction) SelectOne(entity interface{}, filters ...sqldb.SqlFilter) error {
-	return s.selectOne(s, entity, filters...)
+	return s.selectOne(s.trusted(s, s.tx), entity, filters...)
 }
 
 func (s *transaction) SelectDistinct(entity interface{}, row func(), order interface{}, filters ...sqldb.SqlFilter) error {
-	return s.selectList(s, true, entity, row, order, filters...)
+	return s.selectList(s.trusted(s, s.tx), true, entity, row, order, filters...)
 }
 
 func (s *transaction) SelectList(entity interface{}, row func(), order interface{}, filters ...sqldb.SqlFilter) error {
-	return s.selectList(s, false, entity, row, order, filters...)
+	return s.selectList(s.trusted(s, s.tx), false, entity, row, order, filters...)
+}
+
+func (s *transaction) SelectEach(entity interface{}, row func() error, order interface{}, filters ...sqldb.SqlFilter) error {
+	return s.selectEach(s.trusted(s, s.tx), false, entity, row, order, filters...)
+}
+
+func (s *transaction) Iterate(entity interface{}, order interface{}, filters ...sqldb.SqlFilter) (sqldb.SqlIterator, error) {
+	rows, err := s.iterate(s.trusted(s, s.tx), false, entity, order, filters...)
+	if err != nil {
+		return nil, err
+	}
+
+	return rows, nil
 }
 
 func (s *transaction) SelectPage(entity interface{}, page func(total, page, size, index uint64), row func(), size, index uint64, order interface{}, filters ...sqldb.SqlFilter) error {
-	return s.selectPage(s, entity, page, row, size, index, order, filters...)
+	return s.selectPage(s.trusted(s, s.tx), entity, page, row, size, index, order, filters...)
+}
+
+func (s *transaction) SelectAfter(entity interface{}, page func(total uint64), row func(), cursor string, size uint64, order interface{}, filters ...sqldb.SqlFilter) (*sqldb.SqlCursor, error) {
+	return s.selectAfter(s.trusted(s, s.tx), entity, page, row, cursor, size, order, filters...)
 }
 
 func (s *transaction) SelectCount(dbEntity interface{}, filters ...sqldb.SqlFilter) (uint64, error) {
-	sqlEntity := &entity{}
+	sqlEntity := s.newEntity()
 	err := sqlEntity.Parse(dbEntity)
 	if err != nil {
 		return 0, err
 	}
 
-	return s.selectCount(s, sqlEntity.Name(), filters...)
+	return s.selectCount(s.trusted(s, s.tx), sqlEntity, filters...)
+}
+
+func (s *transaction) SelectSum(entity interface{}, column string, filters ...sqldb.SqlFilter) (float64, error) {
+	return s.selectNumber(s.trusted(s, s.tx), "SUM", entity, column, filters...)
+}
+
+func (s *transaction) SelectAvg(entity interface{}, column string, filters ...sqldb.SqlFilter) (float64, error) {
+	return s.selectNumber(s.trusted(s, s.tx), "AVG", entity, column, filters...)
+}
+
+func (s *transaction) SelectMax(entity interface{}, column string, filters ...sqldb.SqlFilter) (interface{}, error) {
+	return s.selectValue(s.trusted(s, s.tx), "MAX", entity, column, filters...)
+}
+
+func (s *transaction) SelectMin(entity interface{}, column string, filters ...sqldb.SqlFilter) (interface{}, error) {
+	return s.selectValue(s.trusted(s, s.tx), "MIN", entity, column, filters...)
+}
+
+func (s *transaction) SelectGroupCount(entity interface{}, groupColumns []string, filters ...sqldb.SqlFilter) ([]*sqldb.SqlGroupCount, error) {
+	return s.selectGroupCount(s.trusted(s, s.tx), entity, groupColumns, filters...)
+}
+
+func (s *transaction) Exists(entity interface{}, filters ...sqldb.SqlFilter) (bool, error) {
+	return s.exists(s.trusted(s, s.tx), entity, filters...)
 }
@@ -1,13 +1,24 @@
 package mysql
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/ktpswjz/database/sqldb"
+	"net"
+	"reflect"
 	"strings"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
 )
 
 type access struct {
+	config *sqldb.Config
+	// the context of the current user
+	ctx context.Context
 }
 
 func (s *access) isNoRows(err error) bool {
@@ -22,27 +33,100 @@ func (s *access) isNoRows(err error) bool {
 	return false
 }
 
-func (s *access) getFilterFields(dbFilter interface{}) []sqldb.SqlField {
+func (s *access) isRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysqldriver.ErrInvalidConn) {
+		return true
+	}
+
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case 1205, // ER_LOCK_WAIT_TIMEOUT
+			1213: // ER_LOCK_DEADLOCK
+			return true
+		}
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return false
+}
+
+func (s *access) checkSavepointName(name string) error {
+	if name == "" {
+		return fmt.Errorf("savepoint name is empty")
+	}
+
+	for i, c := range name {
+		if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+			continue
+		}
+		if i > 0 && c >= '0' && c <= '9' {
+			continue
+		}
+		return fmt.Errorf("invalid savepoint name '%s'", name)
+	}
+
+	return nil
+}
+
+// getFilterFields returns the error of the filter tags, so that a typo never drops the conditions of a delete or update
+func (s *access) getFilterFields(dbFilter interface{}) ([]sqldb.SqlField, error) {
 	fields := make([]sqldb.SqlField, 0)
 	if dbFilter == nil {
-		return fields
+		return fields, nil
 	}
 
-	filterEntity := &entity{}
+	filterEntity := s.newEntity()
 	err := filterEntity.ParseFilter(dbFilter)
 	if err != nil {
-		return fields
+		return nil, err
 	}
-	fieldCount := filterEntity.FieldCount()
-	for fieldIndex := 0; fieldIndex < fieldCount; fieldIndex++ {
-		field := filterEntity.Field(fieldIndex)
+	for _, field := range filterEntity.fields {
 		if field.ValueEmpty() {
 			continue
 		}
+		// the encoded value may differ from the stored one, such as the random nonce of aes
+		if field.coder != nil {
+			return nil, fmt.Errorf("filter field %s: column encoded by codec '%s' can not be filtered", field.name, field.codec)
+		}
 		fields = append(fields, field)
 	}
 
-	return fields
+	return fields, nil
+}
+
+// columns listed by sqldb.Columns in the filters, nil if not restricted
+func (s *access) getColumns(sqlFilters []sqldb.SqlFilter) []string {
+	for _, sqlFilter := range sqlFilters {
+		if option, ok := sqlFilter.(*sqldb.ColumnsOption); ok {
+			return option.Names
+		}
+	}
+
+	return nil
+}
+
+// the entity restricted to the columns listed in filters
+func (s *access) getScanEntity(sqlEntity *entity, sqlFilters []sqldb.SqlFilter, required ...string) (*entity, error) {
+	columns := s.getColumns(sqlFilters)
+	if columns == nil {
+		return sqlEntity, nil
+	}
+	preloadColumns, err := s.getPreloadColumns(sqlEntity, sqlFilters)
+	if err != nil {
+		return nil, err
+	}
+
+	return sqlEntity.project(append(append(append([]string{}, columns...), required...), preloadColumns...))
 }
 
 func (s *access) fillWhereField(sqlBuilder sqldb.SqlBuilder, fields []sqldb.SqlField, or bool) {
@@ -79,20 +163,31 @@ func (s *access) fillWhereField(sqlBuilder sqldb.SqlBuilder, fields []sqldb.SqlF
 	}
 }
 
-func (s *access) fillWhereFilter(sqlBuilder sqldb.SqlBuilder, filters []sqldb.SqlFilter) {
+func (s *access) fillWhereFilter(sqlBuilder sqldb.SqlBuilder, filters []sqldb.SqlFilter, nested bool) error {
 	filterCount := len(filters)
 	if filterCount < 1 {
-		return
+		return nil
 	}
 
+	first := true
 	for filterIndex := 0; filterIndex < filterCount; filterIndex++ {
 		filter := filters[filterIndex]
-		fields := s.getFilterFields(filter.Fields())
+		fields, err := s.getFilterFields(filter.Fields())
+		if err != nil {
+			return err
+		}
 		if len(fields) < 1 {
 			continue
 		}
 
-		if filter.GroupOr() {
+		if first {
+			first = false
+			// the filters are bracketed to keep the 'OR' groups inside when appended to other conditions
+			if nested {
+				sqlBuilder.WhereAnd("(")
+			}
+			sqlBuilder.Where("")
+		} else if filter.GroupOr() {
 			sqlBuilder.WhereOr("")
 		} else {
 			sqlBuilder.WhereAnd("")
@@ -100,36 +195,251 @@ func (s *access) fillWhereFilter(sqlBuilder sqldb.SqlBuilder, filters []sqldb.Sq
 
 		s.fillWhereField(sqlBuilder, fields, filter.FieldOr())
 	}
+
+	if nested && !first {
+		sqlBuilder.Append(")")
+	}
+
+	return nil
+}
+
+func (s *access) fillWhere(sqlBuilder sqldb.SqlBuilder, filters ...sqldb.SqlFilter) error {
+	return s.fillWhereFilter(sqlBuilder, filters, false)
+}
+
+// the soft deleted rows are excluded ahead of the filters unless unscoped,
+// nested means some condition is filled already
+func (s *access) fillEntityWhere(sqlBuilder sqldb.SqlBuilder, sqlEntity *entity, sqlFilters []sqldb.SqlFilter, nested bool) error {
+	scoped, err := s.fillTenantWhere(sqlBuilder, sqlEntity)
+	if err != nil {
+		return err
+	}
+	if scoped {
+		nested = true
+	}
+	scope := sqlEntity.scope()
+	if scope != "" && !s.isUnscoped(sqlFilters) {
+		sqlBuilder.WhereAnd(scope)
+		nested = true
+	}
+
+	return s.fillWhereFilter(sqlBuilder, sqlFilters, nested)
+}
+
+// fillTenantWhere restricts the rows to the tenant of the scope, even if unscoped, returns false if the entity is not scoped,
+// or sqldb.ErrNoTenantColumn if the rows of the entity can not be restricted
+func (s *access) fillTenantWhere(sqlBuilder sqldb.SqlBuilder, sqlEntity *entity) (bool, error) {
+	if sqlEntity.tenantErr != nil {
+		return false, sqlEntity.tenantErr
+	}
+	if sqlEntity.tenantField == nil {
+		return false, nil
+	}
+	sqlBuilder.WhereAnd(fmt.Sprintf("%s = %s", sqlEntity.tenantField.name, sqlBuilder.ArgName()), s.config.TenantID)
+
+	return true, nil
+}
+
+func (s *access) isUnscoped(sqlFilters []sqldb.SqlFilter) bool {
+	for _, sqlFilter := range sqlFilters {
+		if _, ok := sqlFilter.(*sqldb.UnscopedOption); ok {
+			return true
+		}
+	}
+
+	return false
 }
 
-func (s *access) fillWhere(sqlBuilder sqldb.SqlBuilder, filters ...sqldb.SqlFilter) {
-	s.fillWhereFilter(sqlBuilder, filters)
+func (s *access) isHardDelete(sqlFilters []sqldb.SqlFilter) bool {
+	for _, sqlFilter := range sqlFilters {
+		if _, ok := sqlFilter.(*sqldb.HardDeleteOption); ok {
+			return true
+		}
+	}
+
+	return false
 }
 
-func (s *access) fillOrder(sqlBuilder sqldb.SqlBuilder, order interface{}) {
+func (s *access) fillOrder(sqlBuilder sqldb.SqlBuilder, order interface{}) error {
 	if order == nil {
-		return
+		return nil
 	}
-	sqlEntity := &entity{}
+	sqlEntity := s.newEntity()
 	err := sqlEntity.Parse(order)
 	if err != nil {
-		return
+		return err
 	}
 
 	count := len(sqlEntity.fields)
 	if count < 1 {
-		return
+		return nil
 	}
 	sqlBuilder.Append(fmt.Sprintf("order by %s %s", sqlEntity.fields[0].name, sqlEntity.fields[0].order))
 
 	for i := 1; i < count; i++ {
 		sqlBuilder.Append(fmt.Sprintf(", %s %s", sqlEntity.fields[i].name, sqlEntity.fields[i].order))
 	}
+
+	return nil
+}
+
+func (s *access) beforeInsert(dbEntity interface{}) error {
+	if hook, ok := dbEntity.(sqldb.BeforeInserter); ok {
+		err := hook.BeforeInsert()
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.validate(dbEntity)
+}
+
+func (s *access) afterInsert(dbEntity interface{}, id uint64) {
+	if hook, ok := dbEntity.(sqldb.AfterInserter); ok {
+		hook.AfterInsert(id)
+	}
+}
+
+func (s *access) beforeUpdate(dbEntity interface{}) error {
+	if hook, ok := dbEntity.(sqldb.BeforeUpdater); ok {
+		err := hook.BeforeUpdate()
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.validate(dbEntity)
+}
+
+func (s *access) beforeDelete(dbEntity interface{}) error {
+	if hook, ok := dbEntity.(sqldb.BeforeDeleter); ok {
+		return hook.BeforeDelete()
+	}
+
+	return nil
+}
+
+func (s *access) afterSelect(dbEntity interface{}) error {
+	if hook, ok := dbEntity.(sqldb.AfterSelecter); ok {
+		return hook.AfterSelect()
+	}
+
+	return nil
+}
+
+// afterScan decodes the codec fields then calls the AfterSelect hook
+func (s *access) afterScan(sqlEntity *entity, dbEntity interface{}) error {
+	err := sqlEntity.decode()
+	if err != nil {
+		return err
+	}
+
+	return s.afterSelect(dbEntity)
+}
+
+func (s *access) validate(dbEntity interface{}) error {
+	if validator, ok := dbEntity.(sqldb.Validator); ok {
+		return validator.Validate()
+	}
+
+	return nil
+}
+
+// entities are parsed with the config of the database
+func (s *access) newEntity() *entity {
+	return &entity{config: s.config}
+}
+
+// stamp fills the audit fields with the time of the clock and the user of the context,
+// and the tenant field with the tenant of the scope, so that rows are not written to other tenants
+func (s *access) stamp(sqlEntity *entity, inserting bool) ([]string, error) {
+	err := sqlEntity.assignTenant()
+	if err != nil {
+		return nil, err
+	}
+
+	return sqlEntity.stamp(s.config.Now(), s.config.CurrentUser(s.ctx), inserting)
+}
+
+// statements is the database or transaction executing the statements of the entity operations
+type statements interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Prepare(query string) (*sql.Stmt, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// entityAccess is passed to the entity operations of a tenant scope,
+// whose statements are scoped by the tenant and executed without the raw query guard
+type entityAccess struct {
+	sqldb.SqlAccess
+
+	statements statements
+}
+
+func (s *entityAccess) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return s.statements.Exec(query, args...)
+}
+
+func (s *entityAccess) Prepare(query string) (*sql.Stmt, error) {
+	return s.statements.Prepare(query)
+}
+
+func (s *entityAccess) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return s.statements.Query(query, args...)
+}
+
+func (s *entityAccess) QueryRow(query string, args ...interface{}) *sql.Row {
+	return s.statements.QueryRow(query, args...)
+}
+
+// trusted returns sqlAccess itself unless the raw queries of sqlAccess are guarded
+func (s *access) trusted(sqlAccess sqldb.SqlAccess, stmts statements) sqldb.SqlAccess {
+	if s.config.CheckRawQuery() == nil {
+		return sqlAccess
+	}
+
+	return &entityAccess{SqlAccess: sqlAccess, statements: stmts}
+}
+
+// rejectedContext is done with err, so that the row returned by QueryRowContext reports err on Scan
+type rejectedContext struct {
+	context.Context
+
+	err error
+}
+
+var rejectedDone = make(chan struct{})
+
+func init() {
+	close(rejectedDone)
+}
+
+func (s *rejectedContext) Done() <-chan struct{} {
+	return rejectedDone
+}
+
+func (s *rejectedContext) Err() error {
+	return s.err
 }
 
 func (s *access) insert(sqlAccess sqldb.SqlAccess, selective bool, dbEntity interface{}) (uint64, error) {
-	sqlEntity := &entity{}
-	err := sqlEntity.Parse(dbEntity)
+	// hooks are called before parsing, so that the values they set are bound
+	err := s.beforeInsert(dbEntity)
+	if err != nil {
+		return 0, err
+	}
+
+	sqlEntity := s.newEntity()
+	err = sqlEntity.Parse(dbEntity)
+	if err != nil {
+		return 0, err
+	}
+	_, err = s.stamp(sqlEntity, true)
+	if err != nil {
+		return 0, err
+	}
+	err = sqlEntity.encode()
 	if err != nil {
 		return 0, err
 	}
@@ -170,23 +480,61 @@ func (s *access) insert(sqlAccess sqldb.SqlAccess, selective bool, dbEntity inte
 		if err != nil {
 			return 0, err
 		}
+		s.afterInsert(dbEntity, uint64(id))
 		return uint64(id), nil
 	}
+	s.afterInsert(dbEntity, 0)
 
 	return 0, nil
 }
 
 func (s *access) delete(sqlAccess sqldb.SqlAccess, dbEntity interface{}, sqlFilters ...sqldb.SqlFilter) (uint64, error) {
-	sqlEntity := &entity{}
-	err := sqlEntity.Parse(dbEntity)
+	err := s.beforeDelete(dbEntity)
+	if err != nil {
+		return 0, err
+	}
+
+	sqlEntity := s.newEntity()
+	err = sqlEntity.Parse(dbEntity)
 	if err != nil {
 		return 0, err
 	}
 
 	sqlBuilder := &builder{}
 	sqlBuilder.Reset()
-	sqlBuilder.Delete(sqlEntity.Name())
-	s.fillWhere(sqlBuilder, sqlFilters...)
+	if sqlEntity.softDeleteField != nil && !s.isHardDelete(sqlFilters) {
+		// the soft deleted rows are updated, so are their updated audit columns
+		now := s.config.Now()
+		stamped, err := sqlEntity.stamp(now, s.config.CurrentUser(s.ctx), false)
+		if err != nil {
+			return 0, err
+		}
+		err = sqlEntity.encode()
+		if err != nil {
+			return 0, err
+		}
+		sqlBuilder.Update(sqlEntity.Name())
+		sqlBuilder.Set(sqlEntity.softDeleteField.name, sqlEntity.deletedValue(now))
+		for _, name := range stamped {
+			if name != sqlEntity.softDeleteField.name {
+				sqlBuilder.Set(name, sqlEntity.fieldByName(name).value)
+			}
+		}
+		err = s.fillEntityWhere(sqlBuilder, sqlEntity, sqlFilters, false)
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		sqlBuilder.Delete(sqlEntity.Name())
+		nested, err := s.fillTenantWhere(sqlBuilder, sqlEntity)
+		if err != nil {
+			return 0, err
+		}
+		err = s.fillWhereFilter(sqlBuilder, sqlFilters, nested)
+		if err != nil {
+			return 0, err
+		}
+	}
 
 	stmt, err := sqlAccess.Prepare(sqlBuilder.Query())
 	if err != nil {
@@ -208,12 +556,36 @@ func (s *access) delete(sqlAccess sqldb.SqlAccess, dbEntity interface{}, sqlFilt
 }
 
 func (s *access) update(sqlAccess sqldb.SqlAccess, selective bool, dbEntity interface{}, sqlFilters ...sqldb.SqlFilter) (uint64, error) {
-	sqlEntity := &entity{}
-	err := sqlEntity.Parse(dbEntity)
+	err := s.beforeUpdate(dbEntity)
+	if err != nil {
+		return 0, err
+	}
+
+	sqlEntity := s.newEntity()
+	err = sqlEntity.Parse(dbEntity)
+	if err != nil {
+		return 0, err
+	}
+
+	stamped, err := s.stamp(sqlEntity, false)
+	if err != nil {
+		return 0, err
+	}
+	err = sqlEntity.encode()
 	if err != nil {
 		return 0, err
 	}
 
+	// the listed columns are updated even if empty, so are the stamped audit columns
+	columns := s.getColumns(sqlFilters)
+	if columns != nil {
+		sqlEntity, err = sqlEntity.project(append(append([]string{}, columns...), stamped...))
+		if err != nil {
+			return 0, err
+		}
+		selective = false
+	}
+
 	sqlBuilder := &builder{}
 	sqlBuilder.Reset()
 	sqlBuilder.Update(sqlEntity.Name())
@@ -223,6 +595,17 @@ func (s *access) update(sqlAccess sqldb.SqlAccess, selective bool, dbEntity inte
 		if field.AutoIncrement() {
 			continue
 		}
+		// the soft delete column is written only when listed
+		if columns == nil && sqlEntity.fields[fieldIndex].softDelete != softDeleteNone {
+			continue
+		}
+		if sqlEntity.fields[fieldIndex].insertOnly() {
+			continue
+		}
+		if sqlEntity.fields[fieldIndex].version {
+			sqlBuilder.setExpression(field.Name(), fmt.Sprintf("%s + 1", field.Name()))
+			continue
+		}
 		if selective {
 			if field.ValueEmpty() {
 				continue
@@ -231,7 +614,10 @@ func (s *access) update(sqlAccess sqldb.SqlAccess, selective bool, dbEntity inte
 
 		sqlBuilder.Set(field.Name(), field.Value())
 	}
-	s.fillWhere(sqlBuilder, sqlFilters...)
+	err = s.fillEntityWhere(sqlBuilder, sqlEntity, sqlFilters, false)
+	if err != nil {
+		return 0, err
+	}
 
 	stmt, err := sqlAccess.Prepare(sqlBuilder.Query())
 	if err != nil {
@@ -253,12 +639,34 @@ func (s *access) update(sqlAccess sqldb.SqlAccess, selective bool, dbEntity inte
 }
 
 func (s *access) updateByPrimaryKey(sqlAccess sqldb.SqlAccess, selective bool, dbEntity interface{}) (uint64, error) {
-	sqlEntity := &entity{}
-	err := sqlEntity.Parse(dbEntity)
+	err := s.beforeUpdate(dbEntity)
+	if err != nil {
+		return 0, err
+	}
+
+	sqlEntity := s.newEntity()
+	err = sqlEntity.Parse(dbEntity)
+	if err != nil {
+		return 0, err
+	}
+	_, err = s.stamp(sqlEntity, false)
+	if err != nil {
+		return 0, err
+	}
+	err = sqlEntity.encode()
 	if err != nil {
 		return 0, err
 	}
 
+	var nextVersion interface{}
+	versionField := sqlEntity.versionField
+	if versionField != nil {
+		nextVersion, err = versionField.nextVersion()
+		if err != nil {
+			return 0, err
+		}
+	}
+
 	sqlBuilder := &builder{}
 	sqlBuilder.Reset()
 	sqlBuilder.Update(sqlEntity.Name())
@@ -273,6 +681,13 @@ func (s *access) updateByPrimaryKey(sqlAccess sqldb.SqlAccess, selective bool, d
 		if field.AutoIncrement() {
 			continue
 		}
+		if sqlEntity.fields[fieldIndex].softDelete != softDeleteNone || sqlEntity.fields[fieldIndex].insertOnly() {
+			continue
+		}
+		if sqlEntity.fields[fieldIndex] == versionField {
+			sqlBuilder.setExpression(field.Name(), fmt.Sprintf("%s + 1", field.Name()))
+			continue
+		}
 		if selective {
 			if field.ValueEmpty() {
 				continue
@@ -286,9 +701,17 @@ func (s *access) updateByPrimaryKey(sqlAccess sqldb.SqlAccess, selective bool, d
 	if primaryCount < 1 {
 		return 0, fmt.Errorf("no primary key")
 	}
-	for fieldIndex := 0; fieldIndex < primaryCount; fieldIndex++ {
-		field := primaryFields[fieldIndex]
-		sqlBuilder.Where(fmt.Sprintf(" %s=?", field.Name()), field.Value())
+	s.fillPrimaryWhere(sqlBuilder, primaryFields)
+	_, err = s.fillTenantWhere(sqlBuilder, sqlEntity)
+	if err != nil {
+		return 0, err
+	}
+	if versionField != nil {
+		sqlBuilder.WhereAnd(fmt.Sprintf("%s = %s", versionField.name, sqlBuilder.ArgName()), versionField.value)
+	}
+	scope := sqlEntity.scope()
+	if scope != "" {
+		sqlBuilder.WhereAnd(scope)
 	}
 
 	query := sqlBuilder.Query()
@@ -309,12 +732,30 @@ func (s *access) updateByPrimaryKey(sqlAccess sqldb.SqlAccess, selective bool, d
 		return 0, err
 	}
 
+	if versionField != nil {
+		// the row has been updated or deleted by others since it was read
+		if rowsAffected == 0 {
+			return 0, sqldb.ErrStaleEntity
+		}
+		err = versionField.setValue(nextVersion)
+		if err != nil {
+			return 0, err
+		}
+
+		return uint64(rowsAffected), nil
+	}
+
+	// the matched row is counted as affected even if nothing changed
 	if rowsAffected == 0 {
 		sqlBuilder.Reset()
 		sqlBuilder.Select("COUNT(*)", false).From(sqlEntity.Name())
-		for fieldIndex := 0; fieldIndex < primaryCount; fieldIndex++ {
-			field := primaryFields[fieldIndex]
-			sqlBuilder.Where(fmt.Sprintf(" %s=?", field.Name()), field.Value())
+		s.fillPrimaryWhere(sqlBuilder, primaryFields)
+		_, err = s.fillTenantWhere(sqlBuilder, sqlEntity)
+		if err != nil {
+			return 0, err
+		}
+		if scope != "" {
+			sqlBuilder.WhereAnd(scope)
 		}
 
 		query := sqlBuilder.Query()
@@ -328,16 +769,26 @@ func (s *access) updateByPrimaryKey(sqlAccess sqldb.SqlAccess, selective bool, d
 	return uint64(rowsAffected), nil
 }
 
-func (s *access) selectCount(sqlAccess sqldb.SqlAccess, tableName string, sqlFilters ...sqldb.SqlFilter) (uint64, error) {
+// primary keys are joined by AND
+func (s *access) fillPrimaryWhere(sqlBuilder sqldb.SqlBuilder, primaryFields []sqldb.SqlField) {
+	for _, field := range primaryFields {
+		sqlBuilder.WhereAnd(fmt.Sprintf("%s = %s", field.Name(), sqlBuilder.ArgName()), field.Value())
+	}
+}
+
+func (s *access) selectCount(sqlAccess sqldb.SqlAccess, sqlEntity *entity, sqlFilters ...sqldb.SqlFilter) (uint64, error) {
 	sqlBuilder := &builder{}
 	sqlBuilder.Reset()
-	sqlBuilder.Select("COUNT(*)", false).From(tableName)
-	s.fillWhere(sqlBuilder, sqlFilters...)
-
+	sqlBuilder.Select("COUNT(*)", false).From(sqlEntity.Name())
+	err := s.fillEntityWhere(sqlBuilder, sqlEntity, sqlFilters, false)
+	if err != nil {
+		return 0, err
+	}
+
 	count := uint64(0)
 	query := sqlBuilder.Query()
 	row := sqlAccess.QueryRow(query, sqlBuilder.Args()...)
-	err := row.Scan(&count)
+	err = row.Scan(&count)
 	if err != nil {
 		return 0, err
 	}
@@ -346,16 +797,23 @@ func (s *access) selectCount(sqlAccess sqldb.SqlAccess, tableName string, sqlFil
 }
 
 func (s *access) selectOne(sqlAccess sqldb.SqlAccess, dbEntity interface{}, sqlFilters ...sqldb.SqlFilter) error {
-	sqlEntity := &entity{}
+	sqlEntity := s.newEntity()
 	err := sqlEntity.Parse(dbEntity)
 	if err != nil {
 		return err
 	}
+	sqlEntity, err = s.getScanEntity(sqlEntity, sqlFilters)
+	if err != nil {
+		return err
+	}
 
 	sqlBuilder := &builder{}
 	sqlBuilder.Reset()
 	sqlBuilder.Select(sqlEntity.ScanFields(), false).From(sqlEntity.Name())
-	s.fillWhere(sqlBuilder, sqlFilters...)
+	err = s.fillEntityWhere(sqlBuilder, sqlEntity, sqlFilters, false)
+	if err != nil {
+		return err
+	}
 
 	query := sqlBuilder.Query()
 	row := sqlAccess.QueryRow(query, sqlBuilder.Args()...)
@@ -363,52 +821,106 @@ func (s *access) selectOne(sqlAccess sqldb.SqlAccess, dbEntity interface{}, sqlF
 	if err != nil {
 		return err
 	}
+	err = s.afterScan(sqlEntity, dbEntity)
+	if err != nil {
+		return err
+	}
 
-	return nil
+	return s.preload(sqlAccess, sqlEntity, []reflect.Value{reflect.ValueOf(dbEntity).Elem()}, sqlFilters)
 }
 
 func (s *access) selectList(sqlAccess sqldb.SqlAccess, distinct bool, dbEntity interface{}, row func(), dbOrder interface{}, sqlFilters ...sqldb.SqlFilter) error {
-	sqlEntity := &entity{}
-	err := sqlEntity.Parse(dbEntity)
+	return s.selectEach(sqlAccess, distinct, dbEntity, func() error {
+		if row != nil {
+			row()
+		}
+		return nil
+	}, dbOrder, sqlFilters...)
+}
+
+func (s *access) selectEach(sqlAccess sqldb.SqlAccess, distinct bool, dbEntity interface{}, row func() error, dbOrder interface{}, sqlFilters ...sqldb.SqlFilter) error {
+	rows, err := s.iterate(sqlAccess, distinct, dbEntity, dbOrder, sqlFilters...)
 	if err != nil {
 		return err
 	}
+	defer rows.Close()
+
+	// the rows are buffered to preload their relations at once
+	if len(s.getPreloads(sqlFilters)) > 0 {
+		target := reflect.ValueOf(dbEntity).Elem()
+		items := make([]reflect.Value, 0)
+		for rows.Next() {
+			items = append(items, rows.entity.clone(target))
+		}
+		err = rows.Err()
+		if err != nil {
+			return err
+		}
+
+		return s.preloadRows(sqlAccess, rows.entity, dbEntity, items, row, sqlFilters)
+	}
+
+	for rows.Next() {
+		if row == nil {
+			continue
+		}
+
+		err = row()
+		if errors.Is(err, sqldb.ErrStop) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (s *access) iterate(sqlAccess sqldb.SqlAccess, distinct bool, dbEntity interface{}, dbOrder interface{}, sqlFilters ...sqldb.SqlFilter) (*iterator, error) {
+	sqlEntity := s.newEntity()
+	err := sqlEntity.Parse(dbEntity)
+	if err != nil {
+		return nil, err
+	}
+	sqlEntity, err = s.getScanEntity(sqlEntity, sqlFilters)
+	if err != nil {
+		return nil, err
+	}
 
 	sqlBuilder := &builder{}
 	sqlBuilder.Reset()
 	sqlBuilder.Select(sqlEntity.ScanFields(), distinct).From(sqlEntity.Name())
-	s.fillWhere(sqlBuilder, sqlFilters...)
-	s.fillOrder(sqlBuilder, dbOrder)
+	err = s.fillEntityWhere(sqlBuilder, sqlEntity, sqlFilters, false)
+	if err != nil {
+		return nil, err
+	}
+	err = s.fillOrder(sqlBuilder, dbOrder)
+	if err != nil {
+		return nil, err
+	}
 
 	query := sqlBuilder.Query()
 	args := sqlBuilder.Args()
 	rows, err := sqlAccess.Query(query, args...)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		err = rows.Scan(sqlEntity.ScanArgs()...)
-		if err != nil {
-			return err
-		}
-
-		if row != nil {
-			row()
-		}
+	afterScan := func() error {
+		return s.afterScan(sqlEntity, dbEntity)
 	}
 
-	return nil
+	return &iterator{rows: rows, entity: sqlEntity, scanArgs: sqlEntity.ScanArgs(), afterScan: afterScan}, nil
 }
 
 func (s *access) selectPage(sqlAccess sqldb.SqlAccess, dbEntity interface{}, page func(total, page, size, index uint64), row func(), size, index uint64, dbOrder interface{}, sqlFilters ...sqldb.SqlFilter) error {
-	sqlEntity := &entity{}
+	sqlEntity := s.newEntity()
 	err := sqlEntity.Parse(dbEntity)
 	if err != nil {
 		return err
 	}
-	total, err := s.selectCount(sqlAccess, sqlEntity.Name(), sqlFilters...)
+	total, err := s.selectCount(sqlAccess, sqlEntity, sqlFilters...)
 	if err != nil {
 		return err
 	}
@@ -431,12 +943,22 @@ func (s *access) selectPage(sqlAccess sqldb.SqlAccess, dbEntity interface{}, pag
 	if total < 1 {
 		return nil
 	}
+	sqlEntity, err = s.getScanEntity(sqlEntity, sqlFilters)
+	if err != nil {
+		return err
+	}
 
 	sqlBuilder := &builder{}
 	sqlBuilder.Reset()
 	sqlBuilder.Select(sqlEntity.ScanFields(), false).From(sqlEntity.Name())
-	s.fillWhere(sqlBuilder, sqlFilters...)
-	s.fillOrder(sqlBuilder, dbOrder)
+	err = s.fillEntityWhere(sqlBuilder, sqlEntity, sqlFilters, false)
+	if err != nil {
+		return err
+	}
+	err = s.fillOrder(sqlBuilder, dbOrder)
+	if err != nil {
+		return err
+	}
 
 	startIndex := (pageIndex - 1) * size
 	sqlBuilder.Append("LIMIT ?, ?", startIndex, size)
@@ -449,16 +971,444 @@ func (s *access) selectPage(sqlAccess sqldb.SqlAccess, dbEntity interface{}, pag
 	}
 	defer rows.Close()
 
+	preloaded := len(s.getPreloads(sqlFilters)) > 0
+	target := reflect.ValueOf(dbEntity).Elem()
+	items := make([]reflect.Value, 0)
+	scanArgs := sqlEntity.ScanArgs()
 	for rows.Next() {
-		err = rows.Scan(sqlEntity.ScanArgs()...)
+		err = rows.Scan(scanArgs...)
 		if err != nil {
 			return err
 		}
+		err = s.afterScan(sqlEntity, dbEntity)
+		if err != nil {
+			return err
+		}
+
+		// the rows of the page are buffered to preload their relations at once
+		if preloaded {
+			items = append(items, sqlEntity.clone(target))
+			continue
+		}
 
 		if row != nil {
 			row()
 		}
 	}
 
+	if preloaded {
+		return s.preloadRows(sqlAccess, sqlEntity, dbEntity, items, func() error {
+			if row != nil {
+				row()
+			}
+			return nil
+		}, sqlFilters)
+	}
+
 	return nil
 }
+
+func (s *access) selectAfter(sqlAccess sqldb.SqlAccess, dbEntity interface{}, page func(total uint64), row func(), cursor string, size uint64, dbOrder interface{}, sqlFilters ...sqldb.SqlFilter) (*sqldb.SqlCursor, error) {
+	sqlEntity := s.newEntity()
+	err := sqlEntity.Parse(dbEntity)
+	if err != nil {
+		return nil, err
+	}
+	if size < 1 {
+		size = 1
+	}
+
+	keys, err := s.getKeysetFields(sqlEntity, dbOrder)
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]string, len(keys))
+	for i, key := range keys {
+		columns[i] = key.name
+	}
+	sqlEntity, err = s.getScanEntity(sqlEntity, sqlFilters, columns...)
+	if err != nil {
+		return nil, err
+	}
+
+	backward := false
+	var seekValues []interface{}
+	if cursor != "" {
+		var rawValues []json.RawMessage
+		backward, rawValues, err = sqldb.DecodeCursor(cursor, columns)
+		if err != nil {
+			return nil, err
+		}
+		seekValues = make([]interface{}, len(keys))
+		for i, key := range keys {
+			value := reflect.New(reflect.TypeOf(key.address).Elem())
+			err = json.Unmarshal(rawValues[i], value.Interface())
+			if err != nil {
+				return nil, sqldb.ErrInvalidCursor
+			}
+			seekValues[i] = value.Elem().Interface()
+		}
+	}
+
+	if page != nil {
+		total, err := s.selectCount(sqlAccess, sqlEntity, sqlFilters...)
+		if err != nil {
+			return nil, err
+		}
+		page(total)
+	}
+
+	sqlBuilder := &builder{}
+	sqlBuilder.Reset()
+	sqlBuilder.Select(sqlEntity.ScanFields(), false).From(sqlEntity.Name())
+	if seekValues != nil {
+		s.fillSeek(sqlBuilder, keys, seekValues, backward)
+	}
+	err = s.fillEntityWhere(sqlBuilder, sqlEntity, sqlFilters, seekValues != nil)
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range keys {
+		direction := key.direction
+		if backward {
+			direction = !direction
+		}
+		order := "ASC"
+		if !direction {
+			order = "DESC"
+		}
+		if i == 0 {
+			sqlBuilder.Append(fmt.Sprintf("order by %s %s", key.name, order))
+		} else {
+			sqlBuilder.Append(fmt.Sprintf(", %s %s", key.name, order))
+		}
+	}
+	sqlBuilder.Append("LIMIT ?", size+1)
+
+	rows, err := sqlAccess.Query(sqlBuilder.Query(), sqlBuilder.Args()...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// rows are buffered to detect the adjacent page and to restore the order of a backward page
+	target := reflect.ValueOf(dbEntity).Elem()
+	items := make([]reflect.Value, 0)
+	scanArgs := sqlEntity.ScanArgs()
+	for rows.Next() {
+		err = rows.Scan(scanArgs...)
+		if err != nil {
+			return nil, err
+		}
+		err = sqlEntity.decode()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, sqlEntity.clone(target))
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	more := uint64(len(items)) > size
+	if more {
+		items = items[:size]
+	}
+	if backward {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+	err = s.preload(sqlAccess, sqlEntity, items, sqlFilters)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &sqldb.SqlCursor{}
+	count := len(items)
+	for i := 0; i < count; i++ {
+		target.Set(items[i])
+
+		if i == 0 && ((backward && more) || (!backward && cursor != "")) {
+			result.Previous, err = sqldb.EncodeCursor(true, columns, s.getKeysetValues(keys))
+			if err != nil {
+				return nil, err
+			}
+		}
+		if i == count-1 && ((!backward && more) || backward) {
+			result.Next, err = sqldb.EncodeCursor(false, columns, s.getKeysetValues(keys))
+			if err != nil {
+				return nil, err
+			}
+		}
+		// the cursors are encoded from the scanned values, the hook may change them
+		err = s.afterSelect(dbEntity)
+		if err != nil {
+			return nil, err
+		}
+
+		if row != nil {
+			row()
+		}
+	}
+
+	return result, nil
+}
+
+type keysetField struct {
+	name      string
+	address   interface{}
+	direction bool // true: ASC
+}
+
+// the order fields followed by the primary keys, so that the position of each row is unique
+func (s *access) getKeysetFields(sqlEntity *entity, dbOrder interface{}) ([]*keysetField, error) {
+	keys := make([]*keysetField, 0)
+	names := make(map[string]bool)
+
+	if dbOrder != nil {
+		orderEntity := s.newEntity()
+		err := orderEntity.Parse(dbOrder)
+		if err != nil {
+			return nil, err
+		}
+		for _, orderField := range orderEntity.fields {
+			entityField := sqlEntity.fieldByName(orderField.name)
+			if entityField.name == "" {
+				return nil, fmt.Errorf("order field %s not in entity", orderField.name)
+			}
+			keys = append(keys, &keysetField{
+				name:      orderField.name,
+				address:   entityField.address,
+				direction: strings.ToUpper(strings.TrimSpace(orderField.order)) != "DESC",
+			})
+			names[orderField.name] = true
+		}
+	}
+
+	for _, entityField := range sqlEntity.fields {
+		if !entityField.primaryKey || names[entityField.name] {
+			continue
+		}
+		keys = append(keys, &keysetField{
+			name:      entityField.name,
+			address:   entityField.address,
+			direction: true,
+		})
+	}
+
+	if len(keys) < 1 {
+		return nil, fmt.Errorf("keyset pagination requires order fields or primary key")
+	}
+
+	return keys, nil
+}
+
+func (s *access) getKeysetValues(keys []*keysetField) []interface{} {
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		values[i] = reflect.ValueOf(key.address).Elem().Interface()
+	}
+
+	return values
+}
+
+// (k1 > ?) OR (k1 = ? AND k2 > ?) OR ...
+func (s *access) fillSeek(sqlBuilder *builder, keys []*keysetField, values []interface{}, backward bool) {
+	count := len(keys)
+	names := sqlBuilder.argNames(count * (count + 1) / 2)
+	args := make([]interface{}, 0, len(names))
+	conditions := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		parts := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			parts = append(parts, fmt.Sprintf("%s = %s", keys[j].name, names[len(args)]))
+			args = append(args, values[j])
+		}
+
+		symbol := ">"
+		if keys[i].direction == backward {
+			symbol = "<"
+		}
+		parts = append(parts, fmt.Sprintf("%s %s %s", keys[i].name, symbol, names[len(args)]))
+		args = append(args, values[i])
+
+		conditions = append(conditions, fmt.Sprint("(", strings.Join(parts, " AND "), ")"))
+	}
+
+	sqlBuilder.Where(fmt.Sprint("(", strings.Join(conditions, " OR "), ")"), args...)
+}
+
+func (s *access) selectNumber(sqlAccess sqldb.SqlAccess, function string, dbEntity interface{}, column string, sqlFilters ...sqldb.SqlFilter) (float64, error) {
+	sqlEntity := s.newEntity()
+	err := sqlEntity.Parse(dbEntity)
+	if err != nil {
+		return 0, err
+	}
+	field, err := sqlEntity.fieldByColumn(column)
+	if err != nil {
+		return 0, err
+	}
+
+	sqlBuilder := &builder{}
+	sqlBuilder.Reset()
+	sqlBuilder.Select(fmt.Sprintf("%s(%s)", function, field.name), false).From(sqlEntity.Name())
+	err = s.fillEntityWhere(sqlBuilder, sqlEntity, sqlFilters, false)
+	if err != nil {
+		return 0, err
+	}
+
+	value := sql.NullFloat64{}
+	row := sqlAccess.QueryRow(sqlBuilder.Query(), sqlBuilder.Args()...)
+	err = row.Scan(&value)
+	if err != nil {
+		return 0, err
+	}
+
+	return value.Float64, nil
+}
+
+// the result has the type of the entity field, nil if there is no row
+func (s *access) selectValue(sqlAccess sqldb.SqlAccess, function string, dbEntity interface{}, column string, sqlFilters ...sqldb.SqlFilter) (interface{}, error) {
+	sqlEntity := s.newEntity()
+	err := sqlEntity.Parse(dbEntity)
+	if err != nil {
+		return nil, err
+	}
+	field, err := sqlEntity.fieldByColumn(column)
+	if err != nil {
+		return nil, err
+	}
+
+	sqlBuilder := &builder{}
+	sqlBuilder.Reset()
+	sqlBuilder.Select(fmt.Sprintf("%s(%s)", function, field.name), false).From(sqlEntity.Name())
+	err = s.fillEntityWhere(sqlBuilder, sqlEntity, sqlFilters, false)
+	if err != nil {
+		return nil, err
+	}
+
+	value := s.newNullable(field)
+	row := sqlAccess.QueryRow(sqlBuilder.Query(), sqlBuilder.Args()...)
+	err = row.Scan(value.Interface())
+	if err != nil {
+		return nil, err
+	}
+
+	return s.nullableValue(value), nil
+}
+
+func (s *access) selectGroupCount(sqlAccess sqldb.SqlAccess, dbEntity interface{}, groupColumns []string, sqlFilters ...sqldb.SqlFilter) ([]*sqldb.SqlGroupCount, error) {
+	sqlEntity := s.newEntity()
+	err := sqlEntity.Parse(dbEntity)
+	if err != nil {
+		return nil, err
+	}
+	columnCount := len(groupColumns)
+	if columnCount < 1 {
+		return nil, fmt.Errorf("group column is empty")
+	}
+	fields := make([]*field, columnCount)
+	names := make([]string, columnCount)
+	for i := 0; i < columnCount; i++ {
+		fields[i], err = sqlEntity.fieldByColumn(groupColumns[i])
+		if err != nil {
+			return nil, err
+		}
+		names[i] = fields[i].name
+	}
+	groupFields := strings.Join(names, ", ")
+
+	sqlBuilder := &builder{}
+	sqlBuilder.Reset()
+	sqlBuilder.Select(fmt.Sprint(groupFields, ", COUNT(*)"), false).From(sqlEntity.Name())
+	err = s.fillEntityWhere(sqlBuilder, sqlEntity, sqlFilters, false)
+	if err != nil {
+		return nil, err
+	}
+	sqlBuilder.Append(fmt.Sprint("GROUP BY ", groupFields))
+	sqlBuilder.Append(fmt.Sprint("ORDER BY ", groupFields))
+
+	rows, err := sqlAccess.Query(sqlBuilder.Query(), sqlBuilder.Args()...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make([]*sqldb.SqlGroupCount, 0)
+	for rows.Next() {
+		values := make([]reflect.Value, columnCount)
+		scanArgs := make([]interface{}, columnCount+1)
+		for i := 0; i < columnCount; i++ {
+			values[i] = s.newNullable(fields[i])
+			scanArgs[i] = values[i].Interface()
+		}
+		result := &sqldb.SqlGroupCount{Values: make([]interface{}, columnCount)}
+		scanArgs[columnCount] = &result.Count
+
+		err = rows.Scan(scanArgs...)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < columnCount; i++ {
+			result.Values[i] = s.nullableValue(values[i])
+		}
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
+func (s *access) exists(sqlAccess sqldb.SqlAccess, dbEntity interface{}, sqlFilters ...sqldb.SqlFilter) (bool, error) {
+	sqlEntity := s.newEntity()
+	err := sqlEntity.Parse(dbEntity)
+	if err != nil {
+		return false, err
+	}
+
+	sqlBuilder := &builder{}
+	sqlBuilder.Reset()
+	sqlBuilder.Select("1", false).From(sqlEntity.Name())
+	err = s.fillEntityWhere(sqlBuilder, sqlEntity, sqlFilters, false)
+	if err != nil {
+		return false, err
+	}
+	sqlBuilder.Append("LIMIT 1")
+
+	value := 0
+	row := sqlAccess.QueryRow(sqlBuilder.Query(), sqlBuilder.Args()...)
+	err = row.Scan(&value)
+	if err != nil {
+		if s.isNoRows(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// pointer to a nil pointer of the field type, so that NULL can be scanned
+func (s *access) newNullable(field *field) reflect.Value {
+	return reflect.New(reflect.PtrTo(reflect.TypeOf(field.address).Elem()))
+}
+
+func (s *access) nullableValue(value reflect.Value) interface{} {
+	v := value.Elem()
+	if v.IsNil() {
+		return nil
+	}
+
+	return v.Elem().Interface()
+}
+
+func (s *access) queryInto(sqlAccess sqldb.SqlAccess, dest interface{}, query string, args ...interface{}) error {
+	rows, err := sqlAccess.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	return sqldb.ScanRows(rows, dest)
+}
@@ -0,0 +1,47 @@
+package mysql_test
+
+import (
+	"github.com/ktpswjz/database/sqldb"
+	"github.com/ktpswjz/database/sqldb/mysql"
+	"github.com/ktpswjz/database/sqldb/sqldbtest"
+	"os"
+	"testing"
+)
+
+// TestConformance_Mysql runs against the server of the connection file in SQLDB_MYSQL_TEST, skipped if not set
+func TestConformance_Mysql(t *testing.T) {
+	cfgPath := os.Getenv("SQLDB_MYSQL_TEST")
+	if cfgPath == "" {
+		t.Skip("SQLDB_MYSQL_TEST not set")
+	}
+	conn := &mysql.Connection{}
+	err := conn.LoadFromFile(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sqldbtest.RunConformance(t, func(t *testing.T) sqldb.SqlDatabase {
+		db := mysql.NewDatabase(conn)
+		sqlAccess, err := db.NewAccess(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sqlAccess.Close()
+
+		for _, query := range []string{
+			"DROP TABLE IF EXISTS `conformance_item`",
+			"CREATE TABLE `conformance_item` (" +
+				"`id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY, " +
+				"`name` VARCHAR(64) NOT NULL, " +
+				"`age` INT NOT NULL, " +
+				"`email` VARCHAR(64) NULL)",
+		} {
+			_, err = sqlAccess.Exec(query)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return db
+	})
+}
@@ -5,9 +5,8 @@ import (
 	"fmt"
 	"github.com/ktpswjz/database/sqldb"
 	"reflect"
-	"sort"
-	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -17,13 +16,46 @@ const (
 	sqlFieldAutoIncrementTagName = "auto"
 	sqlFieldPrimaryKeyTagName    = "primary"
 	sqlFieldIndexTagName         = "index"
+	sqlFieldSelectTagName        = "select"
+	sqlFieldSoftDeleteTagName    = "softdelete"
+	sqlFieldCreatedTagName       = "created"
+	sqlFieldUpdatedTagName       = "updated"
+	sqlFieldCreatedByTagName     = "createdBy"
+	sqlFieldUpdatedByTagName     = "updatedBy"
+	sqlFieldVersionTagName       = "version"
+	sqlFieldCodecTagName         = "codec"
+	sqlFieldNullTagName          = "null"
+	sqlFieldPrefixTagName        = "prefix"
+	sqlFieldRelationTagName      = "rel"
 
 	sqlFunTableTagName = "TableName"
 )
 
 type entity struct {
-	name   string
-	fields fieldCollection
+	config    *sqldb.Config
+	name      string
+	fields    fieldCollection
+	relations []*relationMeta
+	// the struct bound, whose nil pointers on the field paths are allocated by ScanArgs only
+	target reflect.Value
+
+	// fields are restricted to the listed columns, lazy fields included
+	projected bool
+
+	// rows are marked as deleted by the column instead of being removed
+	softDeleteField *field
+	// optimistic locking column, increased by each update
+	versionField *field
+	// column of the tenant of the scope, nil if not scoped by column
+	tenantField *field
+	// the table is shared by all tenants
+	shared bool
+	// sqldb.ErrNoTenantColumn if scoped by column but the tenant column is not in the entity
+	tenantErr error
+}
+
+type tableNamer interface {
+	TableName() string
 }
 
 // parse the name and fields of database table
@@ -31,6 +63,13 @@ type entity struct {
 func (s *entity) Parse(entity interface{}) error {
 	s.name = ""
 	s.fields = make([]*field, 0)
+	s.projected = false
+	s.softDeleteField = nil
+	s.versionField = nil
+	s.relations = nil
+	s.tenantField = nil
+	s.shared = false
+	s.tenantErr = nil
 
 	// check kind of entity
 	if entity == nil {
@@ -44,22 +83,31 @@ func (s *entity) Parse(entity interface{}) error {
 		return newError("invalid entity (", v.Type().Name(), "): not struct")
 	}
 
-	err := s.parseName(v)
+	err := s.parseName(entity, v)
 	if err != nil {
 		return err
 	}
 
-	fields := make(map[string]*field)
-	s.parseFields(v, fields)
-	if len(fields) < 1 {
+	meta := metas.entity(v.Type(), s.naming())
+	if meta.err != nil {
+		return newError("invalid entity (", v.Type().Name(), "): ", meta.err)
+	}
+	if len(meta.fields) < 1 {
 		return newError("invalid entity (", v.Type().Name(), "): field empty")
 	}
-
-	for _, field := range fields {
-		s.fields = append(s.fields, field)
+	err = s.bind(v, meta)
+	if err != nil {
+		return err
 	}
 
-	sort.Stable(s.fields)
+	// the entity is parsed for the order columns as well, so the missing tenant column fails the operations on the rows only
+	if column := s.config.TenantFilterColumn(); column != "" && !s.shared {
+		s.tenantField, err = s.fieldByColumn(column)
+		if err != nil {
+			s.tenantField = nil
+			s.tenantErr = sqldb.ErrNoTenantColumn
+		}
+	}
 
 	return nil
 }
@@ -67,6 +115,13 @@ func (s *entity) Parse(entity interface{}) error {
 func (s *entity) ParseFilter(entity interface{}) error {
 	s.name = ""
 	s.fields = make([]*field, 0)
+	s.projected = false
+	s.softDeleteField = nil
+	s.versionField = nil
+	s.relations = nil
+	s.tenantField = nil
+	s.shared = false
+	s.tenantErr = nil
 
 	// check kind of entity
 	if entity == nil {
@@ -80,184 +135,169 @@ func (s *entity) ParseFilter(entity interface{}) error {
 		return newError("invalid entity (", v.Type().Name(), "): not struct")
 	}
 
-	s.parseFilterFields(v)
-	if len(s.fields) < 1 {
+	meta := metas.filter(v.Type(), s.naming())
+	if meta.err != nil {
+		return newError("invalid entity (", v.Type().Name(), "): ", meta.err)
+	}
+	if len(meta.fields) < 1 {
 		return newError("invalid entity (", v.Type().Name(), "): field empty")
 	}
 
-	return nil
+	return s.bind(v, meta)
 }
 
-func (s *entity) parseName(v reflect.Value) error {
-	msgNotDefine := fmt.Sprintf("'func (s %s) %s() string' not define in struct", v.Type().Name(), sqlFunTableTagName)
-	method := v.MethodByName(sqlFunTableTagName)
-	if !method.IsValid() {
-		return errors.New(msgNotDefine)
-	}
-
-	methodType := method.Type()
-	if methodType.NumIn() != 0 {
-		return errors.New(msgNotDefine)
-	}
-	if methodType.NumOut() != 1 {
-		return errors.New(msgNotDefine)
-	}
-	if methodType.Out(0).Kind() != reflect.String {
-		return errors.New(msgNotDefine)
+// the table name is resolved on each call, so it may depend on the value of entity
+func (s *entity) parseName(entity interface{}, v reflect.Value) error {
+	namer, ok := entity.(tableNamer)
+	if !ok {
+		if naming := s.naming(); naming != nil {
+			s.setName(naming.TableName(v.Type().Name()))
+			return nil
+		}
+		return fmt.Errorf("'func (s %s) %s() string' not define in struct", v.Type().Name(), sqlFunTableTagName)
 	}
 
-	result := method.Call([]reflect.Value{})
-	if len(result) != 1 {
-		return newError("get table name of '", v.Type().Name(), "' fail")
-	}
-	s.name = fmt.Sprintf("`%s`", result[0].String())
-	if s.name == "``" {
+	name := namer.TableName()
+	if name == "" {
 		return newError("invalid entity (", v.Type().Name(), "): table name is empty")
 	}
+	s.setName(name)
 
 	return nil
 }
 
-func (s *entity) parseFields(v reflect.Value, fields map[string]*field) {
-	if v.Kind() != reflect.Struct {
-		return
-	}
-	n := v.NumField()
-	if n < 1 {
-		return
-	}
-	t := v.Type()
-	if t.Kind() != reflect.Struct {
+// setName quotes the table name, which is prefixed by the schema of the tenant if scoped by schema and not shared
+func (s *entity) setName(name string) {
+	s.shared = s.config.IsSharedTable(name)
+	s.name = fmt.Sprintf("`%s`", name)
+	if s.shared {
 		return
 	}
-	if t.NumField() != n {
-		return
+	if schema := s.config.TenantSchemaName(); schema != "" {
+		s.name = fmt.Sprintf("`%s`.%s", schema, s.name)
 	}
+}
 
-	for i := 0; i < n; i++ {
-		valueField := v.Field(i)
-		// ignore private field
-		if !valueField.CanInterface() {
-			continue
-		}
-		if !valueField.CanAddr() {
-			continue
-		}
-
-		typeField := t.Field(i)
-		// parent struct fields
-		if typeField.Anonymous {
-			if valueField.Kind() == reflect.Struct {
-				s.parseFields(valueField.Addr().Elem(), fields)
-			}
-			continue
-		}
+func (s *entity) naming() sqldb.NamingStrategy {
+	if s.config == nil {
+		return nil
+	}
 
-		// filed define
-		fieldName := typeField.Tag.Get(sqlFieldTagName)
-		if fieldName == "" {
-			continue
-		}
+	return s.config.Naming
+}
 
-		info := field{name: fmt.Sprintf("`%s`", fieldName), filter: "=", order: "ASC"}
-		info.value = valueField.Interface()
-		info.address = valueField.Addr().Interface()
-		if strings.ToLower(typeField.Tag.Get(sqlFieldAutoIncrementTagName)) == "true" {
-			info.autoIncrement = true
+// bind leaves the struct as it is, the field under a nil pointer is bound to a detached struct with nil value,
+// which is written as NULL and skipped by the filters
+func (s *entity) bind(v reflect.Value, meta *entityMeta) error {
+	count := len(meta.fields)
+	s.fields = make([]*field, count)
+	s.relations = meta.relations
+	s.target = v
+	for i := 0; i < count; i++ {
+		valueField, detached := fieldByPath(v, meta.fields[i].path, false)
+		s.fields[i] = &field{
+			fieldMeta: meta.fields[i],
+			value:     valueField.Interface(),
+			address:   valueField.Addr().Interface(),
+			detached:  detached,
 		}
-		if strings.ToLower(typeField.Tag.Get(sqlFieldPrimaryKeyTagName)) == "true" {
-			info.primaryKey = true
+		if detached {
+			s.fields[i].value = nil
 		}
-		filter := typeField.Tag.Get(sqlFieldFilterTagName)
-		if len(filter) > 0 {
-			info.filter = filter
+		if s.fields[i].softDelete != softDeleteNone && s.softDeleteField == nil {
+			s.softDeleteField = s.fields[i]
 		}
-		order := typeField.Tag.Get(sqlFieldOrderTagName)
-		if len(order) > 0 {
-			info.order = order
+		if s.fields[i].version && s.versionField == nil {
+			s.versionField = s.fields[i]
 		}
-		index := typeField.Tag.Get(sqlFieldIndexTagName)
-		if len(index) > 0 {
-			indexVal, err := strconv.Atoi(index)
-			if err == nil {
-				info.index = indexVal
+		if s.fields[i].codec != "" {
+			err := s.fields[i].bindCodec()
+			if err != nil {
+				return err
 			}
+		} else if s.fields[i].nullZero || (s.config != nil && s.config.NullZero) {
+			s.fields[i].bindNullable()
 		}
-		fields[fieldName] = &info
-
-		//fmt.Println("field name:", info.name,
-		//	", address:", info.address,
-		//	", value:", info.value)
 	}
+
+	return nil
 }
 
-func (s *entity) parseFilterFields(v reflect.Value) {
-	if v.Kind() != reflect.Struct {
-		return
-	}
-	n := v.NumField()
-	if n < 1 {
-		return
-	}
-	t := v.Type()
-	if t.Kind() != reflect.Struct {
-		return
-	}
-	if t.NumField() != n {
-		return
+// fieldByPath allocates the nil pointers of embedded or flattened structs on the path if alloc,
+// or else the field is taken from a new struct not set to v, and detached is true
+func fieldByPath(v reflect.Value, path []int, alloc bool) (reflect.Value, bool) {
+	detached := false
+	for i, index := range path {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				ptr := reflect.New(v.Type().Elem())
+				if alloc {
+					v.Set(ptr)
+				} else {
+					detached = true
+				}
+				v = ptr
+			}
+			v = v.Elem()
+		}
+		v = v.Field(index)
 	}
 
-	for i := 0; i < n; i++ {
-		valueField := v.Field(i)
-		// ignore private field
-		if !valueField.CanInterface() {
-			continue
-		}
-		if !valueField.CanAddr() {
-			continue
-		}
+	return v, detached
+}
 
-		typeField := t.Field(i)
-		// parent struct fields
-		if typeField.Anonymous {
-			if valueField.Kind() == reflect.Struct {
-				s.parseFilterFields(valueField.Addr().Elem())
-			}
+// attach allocates the nil pointers on the paths of the detached fields, so that the fields are scanned into the struct
+func (s *entity) attach() {
+	for _, f := range s.fields {
+		if !f.detached {
 			continue
 		}
+		valueField, _ := fieldByPath(s.target, f.path, true)
+		f.address = valueField.Addr().Interface()
+		f.detached = false
+	}
+}
 
-		// filed define
-		fieldName := typeField.Tag.Get(sqlFieldTagName)
-		if fieldName == "" {
-			continue
-		}
+// assignTenant sets the tenant of the scope to the tenant field
+func (s *entity) assignTenant() error {
+	if s.tenantErr != nil {
+		return s.tenantErr
+	}
+	if s.tenantField == nil {
+		return nil
+	}
 
-		info := field{name: fmt.Sprintf("`%s`", fieldName), filter: "=", order: "ASC"}
-		info.value = valueField.Interface()
-		info.address = valueField.Addr().Interface()
-		if strings.ToLower(typeField.Tag.Get(sqlFieldAutoIncrementTagName)) == "true" {
-			info.autoIncrement = true
-		}
-		if strings.ToLower(typeField.Tag.Get(sqlFieldPrimaryKeyTagName)) == "true" {
-			info.primaryKey = true
-		}
-		filter := typeField.Tag.Get(sqlFieldFilterTagName)
-		if len(filter) > 0 {
-			info.filter = filter
-		}
-		order := typeField.Tag.Get(sqlFieldOrderTagName)
-		if len(order) > 0 {
-			info.order = order
-		}
-		index := typeField.Tag.Get(sqlFieldIndexTagName)
-		if len(index) > 0 {
-			indexVal, err := strconv.Atoi(index)
-			if err == nil {
-				info.index = indexVal
+	return s.tenantField.setValue(s.config.TenantID)
+}
+
+// clone returns a copy of the scanned struct target, the embedded pointers on the field paths are copied as well,
+// since they are allocated once by ScanArgs and reused by each scan
+func (s *entity) clone(target reflect.Value) reflect.Value {
+	item := reflect.New(target.Type()).Elem()
+	item.Set(target)
+
+	cloned := make(map[string]bool)
+	for _, f := range s.fields {
+		v := item
+		for i, index := range f.path {
+			if i > 0 && v.Kind() == reflect.Ptr {
+				key := fmt.Sprint(f.path[:i])
+				if !cloned[key] && !v.IsNil() {
+					clone := reflect.New(v.Type().Elem())
+					clone.Elem().Set(v.Elem())
+					v.Set(clone)
+					cloned[key] = true
+				}
+				if v.IsNil() {
+					break
+				}
+				v = v.Elem()
 			}
+			v = v.Field(index)
 		}
-		s.fields = append(s.fields, &info)
 	}
+
+	return item
 }
 
 func newError(v ...interface{}) error {
@@ -273,7 +313,18 @@ func (s *entity) fieldByName(name string) *field {
 		}
 	}
 
-	return &field{}
+	return &field{fieldMeta: &fieldMeta{}}
+}
+
+// field of the column, the column name may be quoted or not
+func (s *entity) fieldByColumn(column string) (*field, error) {
+	name := fmt.Sprintf("`%s`", strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(column), "`"), "`"))
+	f := s.fieldByName(name)
+	if f.name == "" {
+		return nil, fmt.Errorf("column %s not in entity %s", name, s.name)
+	}
+
+	return f, nil
 }
 
 func (s *entity) Name() string {
@@ -291,30 +342,173 @@ func (s *entity) Field(i int) sqldb.SqlField {
 func (s *entity) ScanFields() string {
 	sb := &strings.Builder{}
 
-	count := len(s.fields)
+	fields := s.scanFields()
+	count := len(fields)
 	if count > 0 {
-		sb.WriteString(s.fields[0].name)
+		sb.WriteString(fields[0].name)
 
 		for i := 1; i < count; i++ {
 			sb.WriteString(", ")
-			sb.WriteString(s.fields[i].name)
+			sb.WriteString(fields[i].name)
 		}
 	}
 
 	return sb.String()
 }
 
+// ScanArgs allocates the nil pointers on the field paths before returning the addresses
 func (s *entity) ScanArgs() []interface{} {
+	s.attach()
 	args := make([]interface{}, 0)
 
-	count := len(s.fields)
+	fields := s.scanFields()
+	count := len(fields)
 	for i := 0; i < count; i++ {
-		args = append(args, s.fields[i].address)
+		args = append(args, fields[i].scanAddress())
 	}
 
 	return args
 }
 
+// fields tagged with `select:"-"` are not selected unless projected
+func (s *entity) scanFields() []*field {
+	if s.projected {
+		return s.fields
+	}
+
+	fields := make([]*field, 0, len(s.fields))
+	for _, f := range s.fields {
+		if f.lazy {
+			continue
+		}
+		fields = append(fields, f)
+	}
+
+	return fields
+}
+
+// an entity of the same table with the fields of columns only
+func (s *entity) project(columns []string) (*entity, error) {
+	projection := &entity{
+		name:            s.name,
+		fields:          make([]*field, 0, len(columns)),
+		config:          s.config,
+		relations:       s.relations,
+		target:          s.target,
+		projected:       true,
+		softDeleteField: s.softDeleteField,
+		versionField:    s.versionField,
+		tenantField:     s.tenantField,
+		shared:          s.shared,
+		tenantErr:       s.tenantErr,
+	}
+	for _, column := range columns {
+		f, err := s.fieldByColumn(column)
+		if err != nil {
+			return nil, err
+		}
+		if projection.fieldByName(f.name).name != "" {
+			continue
+		}
+		projection.fields = append(projection.fields, f)
+	}
+	if len(projection.fields) < 1 {
+		return nil, newError("invalid entity (", s.name, "): no column selected")
+	}
+
+	return projection, nil
+}
+
+// encode converts the values of the codec fields to the values of the columns,
+// it is called before writing since encoding may be expensive or need a key
+func (s *entity) encode() error {
+	for _, f := range s.fields {
+		if f.coder == nil {
+			continue
+		}
+		err := f.encode()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decode sets the columns scanned into holders to the field addresses
+func (s *entity) decode() error {
+	for _, f := range s.scanFields() {
+		if f.holder == nil {
+			continue
+		}
+		err := f.decode()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// condition of the rows not deleted, empty if the entity is not soft deleted
+func (s *entity) scope() string {
+	if s.softDeleteField == nil {
+		return ""
+	}
+	if s.softDeleteField.softDelete == softDeleteTime {
+		return fmt.Sprintf("%s IS NULL", s.softDeleteField.name)
+	}
+
+	return fmt.Sprintf("%s = 0", s.softDeleteField.name)
+}
+
+// value of the soft delete column for the deleted rows, now is the time of the clock of the config
+func (s *entity) deletedValue(now time.Time) interface{} {
+	if s.softDeleteField.softDelete == softDeleteTime {
+		return now
+	}
+
+	return 1
+}
+
+// stamp fills the audit fields of the entity and returns the names of the filled fields,
+// the created fields are filled only when inserting and the user fields only when user is known
+func (s *entity) stamp(now time.Time, user interface{}, inserting bool) ([]string, error) {
+	names := make([]string, 0)
+	for _, f := range s.fields {
+		var value interface{}
+		switch f.audit {
+		case auditCreated:
+			if !inserting {
+				continue
+			}
+			value = now
+		case auditUpdated:
+			value = now
+		case auditCreatedBy:
+			if !inserting || user == nil {
+				continue
+			}
+			value = user
+		case auditUpdatedBy:
+			if user == nil {
+				continue
+			}
+			value = user
+		default:
+			continue
+		}
+
+		err := f.setValue(value)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, f.name)
+	}
+
+	return names, nil
+}
+
 func (s *entity) Values() []interface{} {
 	values := make([]interface{}, 0)
 
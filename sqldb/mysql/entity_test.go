@@ -1,6 +1,12 @@
 package mysql
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/ktpswjz/database/sqldb"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -67,6 +73,689 @@ func TestParse(t *testing.T) {
 
 }
 
+func TestParse_Cache(t *testing.T) {
+	entity1 := &tabEntity21{UserID2: 1}
+	entity2 := &tabEntity21{UserID2: 2}
+
+	sqlEntity1 := &entity{}
+	err := sqlEntity1.Parse(entity1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlEntity2 := &entity{}
+	err = sqlEntity2.Parse(entity2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sqlEntity1.FieldCount() != sqlEntity2.FieldCount() {
+		t.Fatal("field count should be same")
+	}
+	for i := 0; i < sqlEntity1.FieldCount(); i++ {
+		if sqlEntity1.fields[i].fieldMeta != sqlEntity2.fields[i].fieldMeta {
+			t.Error("field metadata should be shared: ", sqlEntity1.fields[i].name)
+		}
+	}
+	checkField(t, sqlEntity1.fieldByName("`userId2`"), "`userId2`", "=", entity1.UserID2, &entity1.UserID2)
+	checkField(t, sqlEntity2.fieldByName("`userId2`"), "`userId2`", "=", entity2.UserID2, &entity2.UserID2)
+}
+
+func TestEntity_FieldByColumn(t *testing.T) {
+	entity2 := &TabEntity2{UserName: "Name 2"}
+	sqlEntity := &entity{}
+	err := sqlEntity.Parse(entity2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, column := range []string{"userName", "`userName`", " userName "} {
+		f, err := sqlEntity.fieldByColumn(column)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkField(t, f, "`userName`", "like", entity2.UserName, &entity2.UserName)
+	}
+
+	_, err = sqlEntity.fieldByColumn("userName`; drop table x; --")
+	if err == nil {
+		t.Error("unknown column should be error")
+	}
+}
+
+func TestEntity_Project(t *testing.T) {
+	dbEntity := &tabLazyEntity{}
+	sqlEntity := &entity{}
+	err := sqlEntity.Parse(dbEntity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sqlEntity.ScanFields() != "`id`, `name`" {
+		t.Error("lazy field should not be selected: ", sqlEntity.ScanFields())
+	}
+	if len(sqlEntity.ScanArgs()) != 2 {
+		t.Error("scan args count error: expect=2, actual=", len(sqlEntity.ScanArgs()))
+	}
+
+	projection, err := sqlEntity.project([]string{"id", "content"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if projection.Name() != sqlEntity.Name() {
+		t.Error("table name error: ", projection.Name())
+	}
+	if projection.ScanFields() != "`id`, `content`" {
+		t.Error("projected fields error: ", projection.ScanFields())
+	}
+	args := projection.ScanArgs()
+	if len(args) != 2 || args[1] != &dbEntity.Content {
+		t.Error("projected args error: ", args)
+	}
+
+	_, err = sqlEntity.project([]string{"unknown"})
+	if err == nil {
+		t.Error("unknown column should be error")
+	}
+}
+
+func TestEntity_SoftDelete(t *testing.T) {
+	sqlEntity := &entity{}
+	err := sqlEntity.Parse(&tabSoftDeleteEntity{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sqlEntity.scope() != "`deletedAt` IS NULL" {
+		t.Error("time scope error: ", sqlEntity.scope())
+	}
+	if _, ok := sqlEntity.deletedValue(time.Now()).(time.Time); !ok {
+		t.Error("deleted value should be time: ", sqlEntity.deletedValue(time.Now()))
+	}
+
+	flagEntity := &entity{}
+	err = flagEntity.Parse(&tabSoftDeleteFlagEntity{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flagEntity.scope() != "`deleted` = 0" {
+		t.Error("flag scope error: ", flagEntity.scope())
+	}
+	if flagEntity.deletedValue(time.Now()) != 1 {
+		t.Error("deleted value should be 1: ", flagEntity.deletedValue(time.Now()))
+	}
+
+	lazyEntity := &entity{}
+	err = lazyEntity.Parse(&tabLazyEntity{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lazyEntity.scope() != "" {
+		t.Error("entity without soft delete column should not be scoped: ", lazyEntity.scope())
+	}
+
+	filters := []sqldb.SqlFilter{
+		newFilter(&tabSoftDeleteFilter{Name: "a"}, false, false),
+		newFilter(&tabSoftDeleteFilter{Name: "b"}, false, true),
+	}
+	a := &access{}
+	sqlBuilder := &builder{}
+	sqlBuilder.Reset()
+	a.fillEntityWhere(sqlBuilder, sqlEntity, filters, false)
+	expect := "WHERE`deletedAt`ISNULLAND((`name`=?)OR(`name`=?))"
+	if strings.Join(strings.Fields(sqlBuilder.Query()), "") != expect {
+		t.Error("scoped where error: ", sqlBuilder.Query())
+	}
+
+	sqlBuilder.Reset()
+	a.fillEntityWhere(sqlBuilder, sqlEntity, append(filters, sqldb.Unscoped()), false)
+	expect = "WHERE(`name`=?)OR(`name`=?)"
+	if strings.Join(strings.Fields(sqlBuilder.Query()), "") != expect {
+		t.Error("unscoped where error: ", sqlBuilder.Query())
+	}
+}
+
+func TestEntity_Stamp(t *testing.T) {
+	now := time.Date(2020, 5, 1, 8, 30, 0, 0, time.Local)
+	dbEntity := &tabAuditEntity{}
+	sqlEntity := &entity{}
+	err := sqlEntity.Parse(dbEntity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := sqlEntity.stamp(now, "admin", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 4 {
+		t.Error("stamped fields error: ", names)
+	}
+	if !dbEntity.CreatedAt.Equal(now) || dbEntity.UpdatedAt == nil || !dbEntity.UpdatedAt.Equal(now) {
+		t.Error("time fields should be stamped: ", dbEntity.CreatedAt, dbEntity.UpdatedAt)
+	}
+	if dbEntity.CreatedBy != "admin" || !dbEntity.UpdatedBy.Valid || dbEntity.UpdatedBy.String != "admin" {
+		t.Error("user fields should be stamped: ", dbEntity.CreatedBy, dbEntity.UpdatedBy)
+	}
+	if sqlEntity.fieldByName("`createdBy`").value != "admin" {
+		t.Error("field value should be refreshed")
+	}
+
+	later := now.Add(time.Hour)
+	names, err = sqlEntity.stamp(later, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "`updatedAt`" {
+		t.Error("only updated time should be stamped: ", names)
+	}
+	if !dbEntity.CreatedAt.Equal(now) || !dbEntity.UpdatedAt.Equal(later) {
+		t.Error("update stamp error: ", dbEntity.CreatedAt, dbEntity.UpdatedAt)
+	}
+
+	_, err = sqlEntity.stamp(now, 1001, true)
+	if err == nil {
+		t.Error("number user should not be assigned to string field")
+	}
+}
+
+func TestEntity_Version(t *testing.T) {
+	dbEntity := &tabVersionEntity{ID: 1, Version: 7}
+	sqlEntity := &entity{}
+	err := sqlEntity.Parse(dbEntity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sqlEntity.versionField == nil || sqlEntity.versionField.name != "`version`" {
+		t.Fatal("version field not found")
+	}
+
+	next, err := sqlEntity.versionField.nextVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != uint32(8) {
+		t.Error("next version error: expect=8, actual=", next)
+	}
+	err = sqlEntity.versionField.setValue(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dbEntity.Version != 8 {
+		t.Error("version should be updated in memory: ", dbEntity.Version)
+	}
+
+	sqlBuilder := &builder{}
+	sqlBuilder.Reset()
+	sqlBuilder.Update(sqlEntity.Name())
+	sqlBuilder.Set("`name`", "a")
+	sqlBuilder.setExpression("`version`", "`version` + 1")
+	a := &access{}
+	a.fillPrimaryWhere(sqlBuilder, []sqldb.SqlField{sqlEntity.fieldByName("`id`"), sqlEntity.fieldByName("`version`")})
+	expect := "UPDATE `tabVersion` SET `name` = ? , `version` = `version` + 1 WHERE `id` = ? AND `version` = ?"
+	if strings.Join(strings.Fields(sqlBuilder.Query()), " ") != expect {
+		t.Error("update query error: ", sqlBuilder.Query())
+	}
+	if len(sqlBuilder.Args()) != 3 {
+		t.Error("update args error: ", sqlBuilder.Args())
+	}
+
+	text := "v1"
+	invalid := &field{fieldMeta: &fieldMeta{name: "`version`"}, value: text, address: &text}
+	_, err = invalid.nextVersion()
+	if err == nil {
+		t.Error("string version should be error")
+	}
+}
+
+func TestEntity_Hooks(t *testing.T) {
+	a := &access{}
+	dbEntity := &tabHookEntity{Name: "  Name "}
+	err := a.beforeInsert(dbEntity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dbEntity.Name != "name" || dbEntity.calls != "insert,validate" {
+		t.Error("before insert hooks error: ", dbEntity.Name, dbEntity.calls)
+	}
+
+	dbEntity = &tabHookEntity{Name: " "}
+	err = a.beforeUpdate(dbEntity)
+	if err == nil {
+		t.Error("validate error should abort the update")
+	}
+	if dbEntity.calls != "update,validate" {
+		t.Error("before update hooks error: ", dbEntity.calls)
+	}
+
+	a.afterInsert(dbEntity, 12)
+	if dbEntity.ID != 12 {
+		t.Error("after insert should receive the id: ", dbEntity.ID)
+	}
+	err = a.afterSelect(dbEntity)
+	if err != nil || dbEntity.calls != "update,validate,select" {
+		t.Error("after select hook error: ", err, dbEntity.calls)
+	}
+
+	// entities without hooks are passed through
+	err = a.beforeInsert(&tabLazyEntity{})
+	if err != nil {
+		t.Error(err)
+	}
+	err = a.beforeDelete(&tabLazyEntity{})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEntity_Codec(t *testing.T) {
+	dbEntity := &tabCodecEntity{ID: 1, Tags: []string{"a", "b"}, Extra: map[string]string{"k": "v"}}
+	sqlEntity := &entity{}
+	err := sqlEntity.Parse(dbEntity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sqlEntity.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sqlEntity.fieldByName("`tags`").value != "a,b" {
+		t.Error("csv value error: ", sqlEntity.fieldByName("`tags`").value)
+	}
+	if sqlEntity.fieldByName("`extra`").value != `{"k":"v"}` {
+		t.Error("json value error: ", sqlEntity.fieldByName("`extra`").value)
+	}
+
+	args := sqlEntity.ScanArgs()
+	if args[0] != &dbEntity.ID {
+		t.Error("plain field should be scanned into the field")
+	}
+	*(args[1].(*[]byte)) = []byte("x,y")
+	*(args[2].(*[]byte)) = []byte(`{"n":"m"}`)
+	err = sqlEntity.decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dbEntity.Tags, []string{"x", "y"}) || dbEntity.Extra["n"] != "m" {
+		t.Error("decode error: ", dbEntity.Tags, dbEntity.Extra)
+	}
+
+	err = sqlEntity.Parse(&tabUnknownCodecEntity{})
+	if err == nil {
+		t.Error("unregistered codec should be error")
+	}
+}
+
+func TestEntity_NullZero(t *testing.T) {
+	dbEntity := &tabNullEntity{ID: 1, Name: "stale", Amount: 0}
+	sqlEntity := &entity{}
+	err := sqlEntity.Parse(dbEntity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sqlEntity.fieldByName("`amount`").value != nil {
+		t.Error("zero value should be written as NULL: ", sqlEntity.fieldByName("`amount`").value)
+	}
+	if sqlEntity.fieldByName("`name`").value != "stale" {
+		t.Error("non zero value should be written as it is: ", sqlEntity.fieldByName("`name`").value)
+	}
+
+	args := sqlEntity.ScanArgs()
+	if args[0] != &dbEntity.ID || args[3] != &dbEntity.Remark {
+		t.Error("untagged fields should be scanned directly")
+	}
+	name, ok := args[1].(**string)
+	if !ok {
+		t.Fatal("tagged field should be scanned into a nullable holder: ", reflect.TypeOf(args[1]))
+	}
+	*name = nil
+	amount := 12.5
+	*(args[2].(**float64)) = &amount
+	err = sqlEntity.decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dbEntity.Name != "" || dbEntity.Amount != 12.5 {
+		t.Error("decode error: ", dbEntity.Name, dbEntity.Amount)
+	}
+
+	configEntity := &entity{config: sqldb.NewConfig(sqldb.WithNullZero(true))}
+	err = configEntity.Parse(dbEntity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := configEntity.ScanArgs()[3].(**string); !ok {
+		t.Error("all fields should be scanned nullable by config")
+	}
+	if configEntity.fieldByName("`remark`").value != "" {
+		t.Error("untagged zero value should still be written: ", configEntity.fieldByName("`remark`").value)
+	}
+}
+
+func TestEntity_Naming(t *testing.T) {
+	sqlEntity := &entity{}
+	err := sqlEntity.Parse(&tabNamingEntity{})
+	if err == nil {
+		t.Error("entity without TableName should be error without naming strategy")
+	}
+
+	cfg := sqldb.NewConfig(sqldb.WithNaming(&sqldb.Naming{Column: sqldb.SnakeCase, Prefix: "tab_", Plural: true}))
+	sqlEntity = &entity{config: cfg}
+	err = sqlEntity.Parse(&tabNamingEntity{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sqlEntity.Name() != "`tab_tab_naming_entities`" {
+		t.Error("table name error: ", sqlEntity.Name())
+	}
+	if sqlEntity.ScanFields() != "`id`, `user_name`, `display`" {
+		t.Error("field names error: ", sqlEntity.ScanFields())
+	}
+	if !sqlEntity.fieldByName("`id`").primaryKey {
+		t.Error("tags should be applied to the named field")
+	}
+
+	// the tagged table name is kept
+	err = sqlEntity.Parse(&tabLazyEntity{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sqlEntity.Name() != "`tabLazy`" {
+		t.Error("table name error: ", sqlEntity.Name())
+	}
+}
+
+type tabMappedNaming map[string]string
+
+func (s tabMappedNaming) ColumnName(fieldName string) string {
+	if name, ok := s[fieldName]; ok {
+		return name
+	}
+	return fieldName
+}
+
+func (s tabMappedNaming) TableName(typeName string) string {
+	return typeName
+}
+
+func TestEntity_NamingCache(t *testing.T) {
+	parse := func(naming sqldb.NamingStrategy) *entity {
+		sqlEntity := &entity{config: sqldb.NewConfig(sqldb.WithNaming(naming))}
+		err := sqlEntity.Parse(&tabNamingEntity{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sqlEntity
+	}
+
+	// the metadata is shared by the databases of the same strategy
+	naming := &sqldb.Naming{Column: sqldb.SnakeCase, Prefix: "tab_"}
+	first := parse(naming)
+	if parse(naming).fields[0].fieldMeta != first.fields[0].fieldMeta {
+		t.Error("metadata of the same strategy should be shared")
+	}
+	third := parse(&sqldb.Naming{Column: sqldb.CamelCase, Prefix: "tab_"})
+	if first.fields[0].fieldMeta == third.fields[0].fieldMeta || third.ScanFields() != "`id`, `userName`, `display`" {
+		t.Error("metadata of the different strategies should not be shared: ", third.ScanFields())
+	}
+
+	// the closures of the same code capture different names
+	renamed := func(name string) *sqldb.Naming {
+		return &sqldb.Naming{Column: func(fieldName string) string {
+			if fieldName == "UserName" {
+				return name
+			}
+			return fieldName
+		}}
+	}
+	if parse(renamed("login")).ScanFields() != "`ID`, `login`, `display`" {
+		t.Error("field names error")
+	}
+	if fields := parse(renamed("account")).ScanFields(); fields != "`ID`, `account`, `display`" {
+		t.Error("metadata of the different closures should not be shared: ", fields)
+	}
+
+	// the strategy of a map type is not comparable, whose metadata is not cached
+	mapped := parse(tabMappedNaming{"UserName": "login"})
+	if mapped.ScanFields() != "`ID`, `login`, `display`" {
+		t.Error("field names error: ", mapped.ScanFields())
+	}
+	if fields := parse(tabMappedNaming{"UserName": "nick"}).ScanFields(); fields != "`ID`, `nick`, `display`" {
+		t.Error("metadata of the different maps should not be shared: ", fields)
+	}
+}
+
+func TestEntity_CombinedTag(t *testing.T) {
+	sqlEntity := &entity{}
+	err := sqlEntity.Parse(&tabCombinedEntity{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sqlEntity.ScanFields() != "`id`, `name`, `createdAt`" {
+		t.Error("fields error: ", sqlEntity.ScanFields())
+	}
+	id := sqlEntity.fieldByName("`id`")
+	if !id.primaryKey || !id.autoIncrement {
+		t.Error("id should be primary key and auto increment")
+	}
+	name := sqlEntity.fieldByName("`name`")
+	if name.filter != "like" || name.order != "DESC" || name.index != 1 {
+		t.Error("name options error: ", name.filter, name.order, name.index)
+	}
+	if sqlEntity.fieldByName("`createdAt`").audit != auditCreated {
+		t.Error("legacy tag should be applied with the combined tag")
+	}
+	if !sqlEntity.fieldByName("`content`").lazy {
+		t.Error("content should be lazy")
+	}
+
+	invalids := []interface{}{
+		&tabUnknownOptionEntity{},
+		&tabConflictEntity{},
+		&tabInvalidIndexEntity{},
+		&tabTwoVersionEntity{},
+		&tabInvalidFlagEntity{},
+		&tabInvalidFilterEntity{},
+	}
+	for _, invalid := range invalids {
+		err = sqlEntity.Parse(invalid)
+		if err == nil {
+			t.Errorf("%T should be error", invalid)
+		}
+		// the error is cached and returned on each parse
+		err2 := sqlEntity.Parse(invalid)
+		if err2 == nil || err2.Error() != fmt.Sprint(err) {
+			t.Errorf("%T should be error on each parse: %v", invalid, err2)
+		}
+	}
+}
+
+func TestEntity_Embedded(t *testing.T) {
+	dbEntity := &tabEmbeddedEntity{Name: "outer"}
+	sqlEntity := &entity{}
+	err := sqlEntity.Parse(dbEntity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sqlEntity.ScanFields() != "`name`, `id`, `createdAt`, `addr_city`, `addr_street`, `nick`" {
+		t.Error("fields error: ", sqlEntity.ScanFields())
+	}
+	// the struct is not changed by parse, the fields under nil pointers are NULL
+	if dbEntity.TabEmbeddedBase != nil || dbEntity.Address != nil {
+		t.Fatal("embedded and flattened pointers should not be allocated by parse")
+	}
+	if sqlEntity.fieldByName("`id`").value != nil || sqlEntity.fieldByName("`addr_city`").value != nil {
+		t.Error("fields under nil pointers should be nil")
+	}
+	filter := &tabEmbeddedEntity{}
+	err = (&entity{}).ParseFilter(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.TabEmbeddedBase != nil || filter.Address != nil {
+		t.Error("embedded and flattened pointers should not be allocated by parse filter")
+	}
+
+	sqlEntity.ScanArgs()
+	if dbEntity.TabEmbeddedBase == nil || dbEntity.Address == nil {
+		t.Fatal("embedded and flattened pointers should be allocated for scan")
+	}
+	// the outer field shadows the embedded one declared before or after it
+	checkField(t, sqlEntity.fieldByName("`name`"), "`name`", "=", "outer", &dbEntity.Name)
+	checkField(t, sqlEntity.fieldByName("`id`"), "`id`", "=", nil, &dbEntity.TabEmbeddedBase.ID)
+	checkField(t, sqlEntity.fieldByName("`addr_city`"), "`addr_city`", "=", nil, &dbEntity.Address.City)
+
+	err = sqlEntity.Parse(&tabEmbeddedConflictEntity{})
+	if err == nil {
+		t.Error("same column of the same depth should be error")
+	}
+}
+
+func TestEntity_Relation(t *testing.T) {
+	dbEntity := &tabRelationOrder{}
+	sqlEntity := &entity{}
+	err := sqlEntity.Parse(dbEntity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sqlEntity.ScanFields() != "`id`, `customerId`" {
+		t.Error("relation fields should not be columns: ", sqlEntity.ScanFields())
+	}
+	if len(sqlEntity.relations) != 2 {
+		t.Fatal("relation count error: ", len(sqlEntity.relations))
+	}
+	customer := sqlEntity.relations[0]
+	if customer.name != "Customer" || customer.kind != relationBelongsTo || customer.fk != "customerId" || !customer.ptr ||
+		customer.elem != reflect.TypeOf(tabRelationCustomer{}) {
+		t.Error("belongsTo relation error: ", customer)
+	}
+	items := sqlEntity.relations[1]
+	if items.name != "Items" || items.kind != relationHasMany || items.fk != "orderId" || items.ptr ||
+		items.elem != reflect.TypeOf(tabRelationItem{}) {
+		t.Error("hasMany relation error: ", items)
+	}
+
+	sqlAccess := &access{}
+	filters := []sqldb.SqlFilter{sqldb.Columns("id"), sqldb.Preload("Customer", "Items")}
+	scanEntity, err := sqlAccess.getScanEntity(sqlEntity, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the keys of the preloaded relations are selected even if not listed
+	if scanEntity.ScanFields() != "`id`, `customerId`" {
+		t.Error("preload columns error: ", scanEntity.ScanFields())
+	}
+	_, err = sqlAccess.getScanEntity(sqlEntity, []sqldb.SqlFilter{sqldb.Columns("id"), sqldb.Preload("Unknown")})
+	if err == nil {
+		t.Error("unknown relation should be error")
+	}
+
+	err = sqlEntity.Parse(&tabRelationInvalid{})
+	if err == nil {
+		t.Error("hasMany relation of non-slice field should be error")
+	}
+
+	key, ok := relationKey(reflect.ValueOf(sql.NullInt64{Int64: 3, Valid: true}))
+	if !ok || key != "3" {
+		t.Error("valuer key error: ", key, ok)
+	}
+	if _, ok = relationKey(reflect.ValueOf((*uint64)(nil))); ok {
+		t.Error("nil key should not match")
+	}
+}
+
+func TestEntity_Clone(t *testing.T) {
+	dbEntity := &tabEmbeddedEntity{}
+	sqlEntity := &entity{}
+	err := sqlEntity.Parse(dbEntity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlEntity.ScanArgs()
+	dbEntity.TabEmbeddedBase.ID = 1
+	dbEntity.Address.City = "a"
+
+	item := sqlEntity.clone(reflect.ValueOf(dbEntity).Elem()).Interface().(tabEmbeddedEntity)
+	dbEntity.TabEmbeddedBase.ID = 2
+	dbEntity.Address.City = "b"
+	// the embedded pointers are reused by the next scan
+	if item.TabEmbeddedBase.ID != 1 || item.Address.City != "a" {
+		t.Error("clone should copy the embedded pointers: ", item.TabEmbeddedBase.ID, item.Address.City)
+	}
+}
+
+func TestEntity_Tenant(t *testing.T) {
+	config := sqldb.NewConfig().With(sqldb.WithTenant(uint64(7)))
+	dbEntity := &tabTenantEntity{TenantID: 3}
+	sqlEntity := &entity{config: config}
+	err := sqlEntity.Parse(dbEntity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sqlEntity.tenantField == nil || sqlEntity.tenantField.name != "`tenant_id`" {
+		t.Fatal("tenant field error: ", sqlEntity.tenantField)
+	}
+	err = sqlEntity.assignTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dbEntity.TenantID != 7 {
+		t.Error("tenant should be assigned: ", dbEntity.TenantID)
+	}
+
+	a := &access{config: config}
+	sqlBuilder := &builder{}
+	sqlBuilder.Reset()
+	a.fillEntityWhere(sqlBuilder, sqlEntity, []sqldb.SqlFilter{sqldb.Unscoped()}, false)
+	if strings.Join(strings.Fields(sqlBuilder.Query()), "") != "WHERE`tenant_id`=?" || len(sqlBuilder.Args()) != 1 || sqlBuilder.Args()[0] != uint64(7) {
+		t.Error("tenant where error: ", sqlBuilder.Query(), sqlBuilder.Args())
+	}
+
+	// the rows of the entities without the tenant column can not be restricted
+	lazyEntity := &entity{config: config}
+	err = lazyEntity.Parse(&tabLazyEntity{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = a.fillEntityWhere(sqlBuilder, lazyEntity, nil, false); err != sqldb.ErrNoTenantColumn {
+		t.Error("entity without tenant column should be error: ", err)
+	}
+	if err = lazyEntity.assignTenant(); err != sqldb.ErrNoTenantColumn {
+		t.Error("entity without tenant column should not be assigned: ", err)
+	}
+	sharedEntity := &entity{config: config.With(sqldb.WithSharedTables("TABLAZY"))}
+	err = sharedEntity.Parse(&tabLazyEntity{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlBuilder.Reset()
+	if err = a.fillEntityWhere(sqlBuilder, sharedEntity, nil, false); err != nil || sharedEntity.tenantField != nil {
+		t.Error("shared table should not be scoped: ", err)
+	}
+
+	schemaConfig := config.With(sqldb.WithTenantSchema(func(id interface{}) string {
+		return fmt.Sprint("tenant", id)
+	}))
+	schemaEntity := &entity{config: schemaConfig}
+	err = schemaEntity.Parse(&tabTenantEntity{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schemaEntity.Name() != "`tenant7`.`tabTenant`" || schemaEntity.tenantField != nil {
+		t.Error("schema scope error: ", schemaEntity.Name())
+	}
+
+	sqlAccess := &normal{access: access{config: config}}
+	_, err = sqlAccess.Query("SELECT 1")
+	if err != sqldb.ErrRawQuery {
+		t.Error("raw query should be rejected: ", err)
+	}
+	if _, ok := sqlAccess.trusted(sqlAccess, sqlAccess.db).(*entityAccess); !ok {
+		t.Error("entity operations should bypass the raw query guard")
+	}
+	sqlAccess.config = config.With(sqldb.WithRawQuery(true))
+	if sqlAccess.trusted(sqlAccess, sqlAccess.db) != sqlAccess {
+		t.Error("allowed raw queries should not be wrapped")
+	}
+}
+
 func checkField(t *testing.T, entityField *field, name, filter string, value, address interface{}) {
 	if entityField.name != name {
 		t.Error("field name error: expect=", name, ", actual=", entityField.name)
@@ -143,3 +832,355 @@ type tabEntity22 struct {
 func (s tabEntity22) TableName() string {
 	return ""
 }
+
+type benchEntity struct {
+	ID        uint64    `sql:"id" auto:"true" primary:"true"`
+	Code      string    `sql:"code" filter:"like"`
+	Name      string    `sql:"name"`
+	Status    int       `sql:"status"`
+	Remark    string    `sql:"remark"`
+	Amount    float64   `sql:"amount"`
+	Creator   string    `sql:"creator"`
+	CreatedAt time.Time `sql:"createdAt" order:"DESC"`
+	UpdatedAt time.Time `sql:"updatedAt"`
+}
+
+func (s benchEntity) TableName() string {
+	return "bench"
+}
+
+// selectList parses the entity, each filter and the order entity once per query
+func BenchmarkEntity_Parse(b *testing.B) {
+	dbEntity := &benchEntity{}
+	for i := 0; i < b.N; i++ {
+		sqlEntity := &entity{}
+		err := sqlEntity.Parse(dbEntity)
+		if err != nil {
+			b.Fatal(err)
+		}
+		sqlEntity.ScanArgs()
+	}
+}
+
+func BenchmarkEntity_ParseUncached(b *testing.B) {
+	dbEntity := &benchEntity{}
+	v := reflect.ValueOf(dbEntity).Elem()
+	for i := 0; i < b.N; i++ {
+		sqlEntity := &entity{}
+		err := sqlEntity.parseName(dbEntity, v)
+		if err != nil {
+			b.Fatal(err)
+		}
+		sqlEntity.bind(v, newEntityMeta(v.Type(), false, nil))
+		sqlEntity.ScanArgs()
+	}
+}
+
+func BenchmarkEntity_ParseFilter(b *testing.B) {
+	dbFilter := &benchEntity{Code: "a%"}
+	for i := 0; i < b.N; i++ {
+		sqlEntity := &entity{}
+		err := sqlEntity.ParseFilter(dbFilter)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEntity_ParseFilterUncached(b *testing.B) {
+	dbFilter := &benchEntity{Code: "a%"}
+	v := reflect.ValueOf(dbFilter).Elem()
+	for i := 0; i < b.N; i++ {
+		sqlEntity := &entity{}
+		sqlEntity.bind(v, newEntityMeta(v.Type(), true, nil))
+	}
+}
+
+type tabLazyEntity struct {
+	ID      uint64 `sql:"id" primary:"true"`
+	Name    string `sql:"name"`
+	Content []byte `sql:"content" select:"-"`
+}
+
+func (s tabLazyEntity) TableName() string {
+	return "tabLazy"
+}
+
+type tabSoftDeleteEntity struct {
+	ID        uint64     `sql:"id" primary:"true"`
+	Name      string     `sql:"name"`
+	DeletedAt *time.Time `sql:"deletedAt" softdelete:"true"`
+}
+
+func (s tabSoftDeleteEntity) TableName() string {
+	return "tabSoftDelete"
+}
+
+type tabSoftDeleteFlagEntity struct {
+	ID      uint64 `sql:"id" primary:"true"`
+	Deleted int    `sql:"deleted" softdelete:"true"`
+}
+
+func (s tabSoftDeleteFlagEntity) TableName() string {
+	return "tabSoftDeleteFlag"
+}
+
+type tabSoftDeleteFilter struct {
+	Name string `sql:"name"`
+}
+
+type tabAuditEntity struct {
+	ID        uint64         `sql:"id" primary:"true"`
+	CreatedAt time.Time      `sql:"createdAt" created:"true"`
+	UpdatedAt *time.Time     `sql:"updatedAt" updated:"true"`
+	CreatedBy string         `sql:"createdBy" createdBy:"true"`
+	UpdatedBy sql.NullString `sql:"updatedBy" updatedBy:"true"`
+}
+
+func (s tabAuditEntity) TableName() string {
+	return "tabAudit"
+}
+
+type tabVersionEntity struct {
+	ID      uint64 `sql:"id" primary:"true"`
+	Name    string `sql:"name"`
+	Version uint32 `sql:"version" version:"true"`
+}
+
+func (s tabVersionEntity) TableName() string {
+	return "tabVersion"
+}
+
+type tabHookEntity struct {
+	ID   uint64 `sql:"id" primary:"true" auto:"true"`
+	Name string `sql:"name"`
+
+	calls string
+}
+
+func (s tabHookEntity) TableName() string {
+	return "tabHook"
+}
+
+func (s *tabHookEntity) call(name string) {
+	if s.calls != "" {
+		s.calls += ","
+	}
+	s.calls += name
+}
+
+func (s *tabHookEntity) BeforeInsert() error {
+	s.call("insert")
+	s.Name = strings.ToLower(strings.TrimSpace(s.Name))
+	return nil
+}
+
+func (s *tabHookEntity) AfterInsert(id uint64) {
+	s.ID = id
+}
+
+func (s *tabHookEntity) BeforeUpdate() error {
+	s.call("update")
+	s.Name = strings.TrimSpace(s.Name)
+	return nil
+}
+
+func (s *tabHookEntity) AfterSelect() error {
+	s.call("select")
+	return nil
+}
+
+func (s *tabHookEntity) Validate() error {
+	s.call("validate")
+	if s.Name == "" {
+		return errors.New("name is empty")
+	}
+	return nil
+}
+
+type tabCodecEntity struct {
+	ID    uint64            `sql:"id" primary:"true"`
+	Tags  []string          `sql:"tags" codec:"csv"`
+	Extra map[string]string `sql:"extra" codec:"json"`
+}
+
+func (s tabCodecEntity) TableName() string {
+	return "tabCodec"
+}
+
+type tabUnknownCodecEntity struct {
+	ID   uint64 `sql:"id" primary:"true"`
+	Data []byte `sql:"data" codec:"unknown"`
+}
+
+func (s tabUnknownCodecEntity) TableName() string {
+	return "tabUnknownCodec"
+}
+
+type tabNullEntity struct {
+	ID     uint64  `sql:"id" primary:"true"`
+	Name   string  `sql:"name" null:"zero"`
+	Amount float64 `sql:"amount" null:"zero"`
+	Remark string  `sql:"remark"`
+}
+
+func (s tabNullEntity) TableName() string {
+	return "tabNull"
+}
+
+type tabNamingEntity struct {
+	ID       uint64 `primary:"true"`
+	UserName string
+	Secret   string `sql:"-"`
+	Nick     string `sql:"display"`
+
+	internal string
+}
+
+type tabCombinedEntity struct {
+	ID        uint64    `db:"id,pk,auto"`
+	Name      string    `db:"name, filter=like, order=desc, index=1"`
+	CreatedAt time.Time `db:"createdAt,index=2" created:"true"`
+	Content   string    `db:"content,lazy,index=3"`
+	Ignored   string    `db:"-"`
+}
+
+func (s tabCombinedEntity) TableName() string {
+	return "tabCombined"
+}
+
+type tabUnknownOptionEntity struct {
+	ID uint64 `db:"id,primary,autoincrement"`
+}
+
+func (s tabUnknownOptionEntity) TableName() string {
+	return "tabUnknownOption"
+}
+
+type tabConflictEntity struct {
+	ID uint64 `db:"id,order=desc" sql:"key" order:"ASC"`
+}
+
+func (s tabConflictEntity) TableName() string {
+	return "tabConflict"
+}
+
+type tabInvalidIndexEntity struct {
+	ID uint64 `sql:"id" index:"first"`
+}
+
+func (s tabInvalidIndexEntity) TableName() string {
+	return "tabInvalidIndex"
+}
+
+type tabTwoVersionEntity struct {
+	ID       uint64 `db:"id,pk"`
+	Version  int    `db:"version,version"`
+	Revision int    `db:"revision,version"`
+}
+
+func (s tabTwoVersionEntity) TableName() string {
+	return "tabTwoVersion"
+}
+
+type tabInvalidFlagEntity struct {
+	ID   uint64 `sql:"id" primary:"yes"`
+	Name string `sql:"name" auto:"false"`
+}
+
+func (s tabInvalidFlagEntity) TableName() string {
+	return "tabInvalidFlag"
+}
+
+type tabInvalidFilterEntity struct {
+	ID   uint64 `db:"id,pk"`
+	Name string `db:"name,filter=lik"`
+}
+
+func (s tabInvalidFilterEntity) TableName() string {
+	return "tabInvalidFilter"
+}
+
+type TabEmbeddedBase struct {
+	ID        uint64    `sql:"id" primary:"true"`
+	Name      string    `sql:"name"`
+	CreatedAt time.Time `sql:"createdAt"`
+}
+
+type TabEmbeddedAddress struct {
+	City   string `sql:"city"`
+	Street string `sql:"street"`
+}
+
+type TabEmbeddedNick struct {
+	Name string `sql:"name"`
+	Nick string `sql:"nick"`
+}
+
+type tabEmbeddedEntity struct {
+	Name string `sql:"name"`
+	*TabEmbeddedBase
+	Address *TabEmbeddedAddress `prefix:"addr_"`
+	TabEmbeddedNick
+}
+
+func (s tabEmbeddedEntity) TableName() string {
+	return "tabEmbedded"
+}
+
+type tabEmbeddedConflictEntity struct {
+	TabEmbeddedBase
+	TabEmbeddedNick
+}
+
+func (s tabEmbeddedConflictEntity) TableName() string {
+	return "tabEmbeddedConflict"
+}
+
+type tabRelationCustomer struct {
+	ID   uint64 `sql:"id" primary:"true"`
+	Name string `sql:"name"`
+}
+
+func (s tabRelationCustomer) TableName() string {
+	return "tabCustomer"
+}
+
+type tabRelationItem struct {
+	ID      uint64 `sql:"id" primary:"true"`
+	OrderID uint64 `sql:"orderId"`
+}
+
+func (s tabRelationItem) TableName() string {
+	return "tabItem"
+}
+
+type tabRelationOrder struct {
+	ID         uint64 `sql:"id" primary:"true"`
+	CustomerID uint64 `sql:"customerId"`
+
+	Customer *tabRelationCustomer `rel:"belongsTo,fk=customerId"`
+	Items    []tabRelationItem    `rel:"hasMany,fk=orderId"`
+}
+
+func (s tabRelationOrder) TableName() string {
+	return "tabOrder"
+}
+
+type tabRelationInvalid struct {
+	ID    uint64           `sql:"id" primary:"true"`
+	Items *tabRelationItem `rel:"hasMany,fk=orderId"`
+}
+
+func (s tabRelationInvalid) TableName() string {
+	return "tabRelationInvalid"
+}
+
+type tabTenantEntity struct {
+	ID       uint64 `sql:"id" primary:"true"`
+	TenantID uint64 `sql:"tenant_id"`
+}
+
+func (s tabTenantEntity) TableName() string {
+	return "tabTenant"
+}
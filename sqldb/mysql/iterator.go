@@ -0,0 +1,58 @@
+package mysql
+
+import (
+	"database/sql"
+)
+
+type iterator struct {
+	rows     *sql.Rows
+	entity   *entity
+	scanArgs []interface{}
+	err      error
+	closed   bool
+
+	// called after each row is scanned, e.g. to decode the codec fields and call the AfterSelect hook
+	afterScan func() error
+
+	// called after the rows closed, e.g. release the connection opened for the iteration
+	release func() error
+}
+
+func (s *iterator) Next() bool {
+	if s.err != nil || s.closed {
+		return false
+	}
+
+	if !s.rows.Next() {
+		s.err = s.rows.Err()
+		return false
+	}
+
+	s.err = s.rows.Scan(s.scanArgs...)
+	if s.err == nil && s.afterScan != nil {
+		s.err = s.afterScan()
+	}
+
+	return s.err == nil
+}
+
+func (s *iterator) Err() error {
+	return s.err
+}
+
+func (s *iterator) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	err := s.rows.Close()
+	if s.release != nil {
+		releaseErr := s.release()
+		if err == nil {
+			err = releaseErr
+		}
+	}
+
+	return err
+}
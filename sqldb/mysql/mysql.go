@@ -1,6 +1,7 @@
 package mysql
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"github.com/ktpswjz/database/sqldb"
@@ -11,10 +12,33 @@ import (
 
 type mysql struct {
 	connection sqldb.SqlConnection
+	config     *sqldb.Config
+	ctx        context.Context
 }
 
-func NewDatabase(conn sqldb.SqlConnection) sqldb.SqlDatabase {
-	return &mysql{connection: conn}
+func NewDatabase(conn sqldb.SqlConnection, options ...sqldb.Option) sqldb.SqlDatabase {
+	return &mysql{connection: conn, config: sqldb.NewConfig(options...)}
+}
+
+// WithContext returns a copy of the database whose accesses carry ctx, such as the user set by sqldb.ContextWithUser
+func (s *mysql) WithContext(ctx context.Context) sqldb.SqlDatabase {
+	return &mysql{connection: s.connection, config: s.config, ctx: ctx}
+}
+
+// ForTenant returns a copy of the database scoped to the tenant id,
+// options such as sqldb.WithRawQuery apply to the copy only
+func (s *mysql) ForTenant(id interface{}, options ...sqldb.Option) sqldb.SqlDatabase {
+	config := s.config.With(sqldb.WithTenant(id)).With(options...)
+
+	return &mysql{connection: s.connection, config: config, ctx: s.ctx}
+}
+
+func (s *mysql) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+
+	return s.ctx
 }
 
 func (s *mysql) Open() (*sql.DB, error) {
@@ -299,26 +323,47 @@ func (s *mysql) ViewDefinition(viewName string) (string, error) {
 }
 
 func (s *mysql) NewAccess(transactional bool) (sqldb.SqlAccess, error) {
+	if transactional {
+		return s.NewAccessTx(s.context(), sqldb.TxOptions{})
+	}
+
 	db, err := sql.Open(s.connection.DriverName(), s.connection.SourceName())
 	if err != nil {
 		return nil, err
 	}
 
-	if transactional {
-		tx, err := db.Begin()
-		if err != nil {
-			db.Close()
-			return nil, err
-		}
+	return &normal{access: access{config: s.config, ctx: s.ctx}, db: db}, nil
+}
+
+func (s *mysql) NewAccessTx(ctx context.Context, opts sqldb.TxOptions) (sqldb.SqlAccess, error) {
+	if ctx == nil {
+		ctx = s.context()
+	}
+
+	db, err := sql.Open(s.connection.DriverName(), s.connection.SourceName())
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.BeginTx(ctx, opts.SqlOptions())
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &transaction{access: access{config: s.config, ctx: ctx}, db: db, tx: tx}, nil
+}
 
-		return &transaction{db: db, tx: tx}, nil
+func (s *mysql) RunInTx(ctx context.Context, opts sqldb.TxOptions, fn func(tx sqldb.SqlAccess) error) error {
+	begin := func() (sqldb.SqlAccess, error) {
+		return s.NewAccessTx(ctx, opts)
 	}
 
-	return &normal{db: db}, nil
+	return sqldb.RunInTx(ctx, opts, begin, s.IsRetryable, fn)
 }
 
 func (s *mysql) NewEntity() sqldb.SqlEntity {
-	return &entity{}
+	return &entity{config: s.config}
 }
 
 func (s *mysql) NewBuilder() sqldb.SqlBuilder {
@@ -344,6 +389,22 @@ func (s *mysql) IsNoRows(err error) bool {
 	return false
 }
 
+func (s *mysql) IsRetryable(err error) bool {
+	sqlAccess := &access{}
+
+	return sqlAccess.isRetryable(err)
+}
+
+func (s *mysql) QueryInto(dest interface{}, query string, args ...interface{}) error {
+	sqlAccess, err := s.NewAccess(false)
+	if err != nil {
+		return err
+	}
+	defer sqlAccess.Close()
+
+	return sqlAccess.QueryInto(dest, query, args...)
+}
+
 func (s *mysql) Insert(entity interface{}) (uint64, error) {
 	sqlAccess, err := s.NewAccess(false)
 	if err != nil {
@@ -444,6 +505,33 @@ func (s *mysql) SelectList(entity interface{}, row func(), order interface{}, fi
 	return sqlAccess.SelectList(entity, row, order, filters...)
 }
 
+func (s *mysql) SelectEach(entity interface{}, row func() error, order interface{}, filters ...sqldb.SqlFilter) error {
+	sqlAccess, err := s.NewAccess(false)
+	if err != nil {
+		return err
+	}
+	defer sqlAccess.Close()
+
+	return sqlAccess.SelectEach(entity, row, order, filters...)
+}
+
+// the connection is released when the iterator is closed
+func (s *mysql) Iterate(entity interface{}, order interface{}, filters ...sqldb.SqlFilter) (sqldb.SqlIterator, error) {
+	sqlAccess, err := s.NewAccess(false)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := sqlAccess.Iterate(entity, order, filters...)
+	if err != nil {
+		sqlAccess.Close()
+		return nil, err
+	}
+	rows.(*iterator).release = sqlAccess.Close
+
+	return rows, nil
+}
+
 func (s *mysql) SelectPage(entity interface{}, page func(total, page, size, index uint64), row func(), size, index uint64, order interface{}, filters ...sqldb.SqlFilter) error {
 	sqlAccess, err := s.NewAccess(false)
 	if err != nil {
@@ -454,6 +542,16 @@ func (s *mysql) SelectPage(entity interface{}, page func(total, page, size, inde
 	return sqlAccess.SelectPage(entity, page, row, size, index, order, filters...)
 }
 
+func (s *mysql) SelectAfter(entity interface{}, page func(total uint64), row func(), cursor string, size uint64, order interface{}, filters ...sqldb.SqlFilter) (*sqldb.SqlCursor, error) {
+	sqlAccess, err := s.NewAccess(false)
+	if err != nil {
+		return nil, err
+	}
+	defer sqlAccess.Close()
+
+	return sqlAccess.SelectAfter(entity, page, row, cursor, size, order, filters...)
+}
+
 func (s *mysql) SelectCount(entity interface{}, filters ...sqldb.SqlFilter) (uint64, error) {
 	sqlAccess, err := s.NewAccess(false)
 	if err != nil {
@@ -463,3 +561,63 @@ func (s *mysql) SelectCount(entity interface{}, filters ...sqldb.SqlFilter) (uin
 
 	return sqlAccess.SelectCount(entity, filters...)
 }
+
+func (s *mysql) SelectSum(entity interface{}, column string, filters ...sqldb.SqlFilter) (float64, error) {
+	sqlAccess, err := s.NewAccess(false)
+	if err != nil {
+		return 0, err
+	}
+	defer sqlAccess.Close()
+
+	return sqlAccess.SelectSum(entity, column, filters...)
+}
+
+func (s *mysql) SelectAvg(entity interface{}, column string, filters ...sqldb.SqlFilter) (float64, error) {
+	sqlAccess, err := s.NewAccess(false)
+	if err != nil {
+		return 0, err
+	}
+	defer sqlAccess.Close()
+
+	return sqlAccess.SelectAvg(entity, column, filters...)
+}
+
+func (s *mysql) SelectMax(entity interface{}, column string, filters ...sqldb.SqlFilter) (interface{}, error) {
+	sqlAccess, err := s.NewAccess(false)
+	if err != nil {
+		return nil, err
+	}
+	defer sqlAccess.Close()
+
+	return sqlAccess.SelectMax(entity, column, filters...)
+}
+
+func (s *mysql) SelectMin(entity interface{}, column string, filters ...sqldb.SqlFilter) (interface{}, error) {
+	sqlAccess, err := s.NewAccess(false)
+	if err != nil {
+		return nil, err
+	}
+	defer sqlAccess.Close()
+
+	return sqlAccess.SelectMin(entity, column, filters...)
+}
+
+func (s *mysql) SelectGroupCount(entity interface{}, groupColumns []string, filters ...sqldb.SqlFilter) ([]*sqldb.SqlGroupCount, error) {
+	sqlAccess, err := s.NewAccess(false)
+	if err != nil {
+		return nil, err
+	}
+	defer sqlAccess.Close()
+
+	return sqlAccess.SelectGroupCount(entity, groupColumns, filters...)
+}
+
+func (s *mysql) Exists(entity interface{}, filters ...sqldb.SqlFilter) (bool, error) {
+	sqlAccess, err := s.NewAccess(false)
+	if err != nil {
+		return false, err
+	}
+	defer sqlAccess.Close()
+
+	return sqlAccess.Exists(entity, filters...)
+}
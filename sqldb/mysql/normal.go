@@ -1,6 +1,7 @@
 package mysql
 
 import (
+	"context"
 	"database/sql"
 	"github.com/ktpswjz/database/sqldb"
 )
@@ -15,8 +16,39 @@ func (s *normal) Close() error {
 	return s.db.Close()
 }
 
+// Commit and Rollback are misused without transaction as Savepoint is
 func (s *normal) Commit() error {
-	return nil
+	return sqldb.ErrNotInTransaction
+}
+
+func (s *normal) Rollback() error {
+	return sqldb.ErrNotInTransaction
+}
+
+func (s *normal) InTransaction() bool {
+	return false
+}
+
+// statements are auto committed without transaction, fn is called immediately
+func (s *normal) OnCommit(fn func()) {
+	if fn != nil {
+		fn()
+	}
+}
+
+func (s *normal) OnRollback(fn func()) {
+}
+
+func (s *normal) Savepoint(name string) error {
+	return sqldb.ErrNotInTransaction
+}
+
+func (s *normal) RollbackTo(name string) error {
+	return sqldb.ErrNotInTransaction
+}
+
+func (s *normal) Release(name string) error {
+	return sqldb.ErrNotInTransaction
 }
 
 func (s *normal) Version() int {
@@ -24,75 +56,144 @@ func (s *normal) Version() int {
 }
 
 func (s *normal) Exec(query string, args ...interface{}) (sql.Result, error) {
+	err := s.config.CheckRawQuery()
+	if err != nil {
+		return nil, err
+	}
+
 	return s.db.Exec(query, args...)
 }
 
 func (s *normal) Prepare(query string) (*sql.Stmt, error) {
+	err := s.config.CheckRawQuery()
+	if err != nil {
+		return nil, err
+	}
+
 	return s.db.Prepare(query)
 }
 
 func (s *normal) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	err := s.config.CheckRawQuery()
+	if err != nil {
+		return nil, err
+	}
+
 	return s.db.Query(query, args...)
 }
 
 func (s *normal) QueryRow(query string, args ...interface{}) *sql.Row {
+	err := s.config.CheckRawQuery()
+	if err != nil {
+		return s.db.QueryRowContext(&rejectedContext{Context: context.Background(), err: err}, query, args...)
+	}
+
 	return s.db.QueryRow(query, args...)
 }
 
+func (s *normal) QueryInto(dest interface{}, query string, args ...interface{}) error {
+	return s.queryInto(s, dest, query, args...)
+}
+
 func (s *normal) IsNoRows(err error) bool {
 	return s.isNoRows(err)
 }
 
+func (s *normal) IsRetryable(err error) bool {
+	return s.isRetryable(err)
+}
+
 func (s *normal) Insert(entity interface{}) (uint64, error) {
-	return s.insert(s, false, entity)
+	return s.insert(s.trusted(s, s.db), false, entity)
 }
 
 func (s *normal) InsertSelective(entity interface{}) (uint64, error) {
-	return s.insert(s, true, entity)
+	return s.insert(s.trusted(s, s.db), true, entity)
 }
 
 func (s *normal) Delete(entity interface{}, filters ...sqldb.SqlFilter) (uint64, error) {
-	return s.delete(s, entity, filters...)
+	return s.delete(s.trusted(s, s.db), entity, filters...)
 }
 
 func (s *normal) Update(entity interface{}, filters ...sqldb.SqlFilter) (uint64, error) {
-	return s.update(s, false, entity, filters...)
+	return s.update(s.trusted(s, s.db), false, entity, filters...)
 }
 
 func (s *normal) UpdateSelective(entity interface{}, filters ...sqldb.SqlFilter) (uint64, error) {
-	return s.update(s, true, entity, filters...)
+	return s.update(s.trusted(s, s.db), true, entity, filters...)
 }
 
 func (s *normal) UpdateByPrimaryKey(entity interface{}) (uint64, error) {
-	return s.updateByPrimaryKey(s, false, entity)
+	return s.updateByPrimaryKey(s.trusted(s, s.db), false, entity)
 }
 
 func (s *normal) UpdateSelectiveByPrimaryKey(entity interface{}) (uint64, error) {
-	return s.updateByPrimaryKey(s, true, entity)
+	return s.updateByPrimaryKey(s.trusted(s, s.db), true, entity)
 }
 
 func (s *normal) SelectOne(entity interface{}, filters ...sqldb.SqlFilter) error {
-	return s.selectOne(s, entity, filters...)
+	return s.selectOne(s.trusted(s, s.db), entity, filters...)
 }
 
 func (s *normal) SelectDistinct(entity interface{}, row func(), order interface{}, filters ...sqldb.SqlFilter) error {
-	return s.selectList(s, true, entity, row, order, filters...)
+	return s.selectList(s.trusted(s, s.db), true, entity, row, order, filters...)
 }
 
 func (s *normal) SelectList(entity interface{}, row func(), order interface{}, filters ...sqldb.SqlFilter) error {
-	return s.selectList(s, false, entity, row, order, filters...)
+	return s.selectList(s.trusted(s, s.db), false, entity, row, order, filters...)
+}
+
+func (s *normal) SelectEach(entity interface{}, row func() error, order interface{}, filters ...sqldb.SqlFilter) error {
+	return s.selectEach(s.trusted(s, s.db), false, entity, row, order, filters...)
+}
+
+func (s *normal) Iterate(entity interface{}, order interface{}, filters ...sqldb.SqlFilter) (sqldb.SqlIterator, error) {
+	rows, err := s.iterate(s.trusted(s, s.db), false, entity, order, filters...)
+	if err != nil {
+		return nil, err
+	}
+
+	return rows, nil
 }
 
 func (s *normal) SelectPage(entity interface{}, page func(total, page, size, index uint64), row func(), size, index uint64, order interface{}, filters ...sqldb.SqlFilter) error {
-	return s.selectPage(s, entity, page, row, size, index, order, filters...)
+	return s.selectPage(s.trusted(s, s.db), entity, page, row, size, index, order, filters...)
+}
+
+func (s *normal) SelectAfter(entity interface{}, page func(total uint64), row func(), cursor string, size uint64, order interface{}, filters ...sqldb.SqlFilter) (*sqldb.SqlCursor, error) {
+	return s.selectAfter(s.trusted(s, s.db), entity, page, row, cursor, size, order, filters...)
 }
 
 func (s *normal) SelectCount(dbEntity interface{}, filters ...sqldb.SqlFilter) (uint64, error) {
-	sqlEntity := &entity{}
+	sqlEntity := s.newEntity()
 	err := sqlEntity.Parse(dbEntity)
 	if err != nil {
 		return 0, err
 	}
 
-	return s.selectCount(s, sqlEntity.Name(), filters...)
+	return s.selectCount(s.trusted(s, s.db), sqlEntity, filters...)
+}
+
+func (s *normal) SelectSum(entity interface{}, column string, filters ...sqldb.SqlFilter) (float64, error) {
+	return s.selectNumber(s.trusted(s, s.db), "SUM", entity, column, filters...)
+}
+
+func (s *normal) SelectAvg(entity interface{}, column string, filters ...sqldb.SqlFilter) (float64, error) {
+	return s.selectNumber(s.trusted(s, s.db), "AVG", entity, column, filters...)
+}
+
+func (s *normal) SelectMax(entity interface{}, column string, filters ...sqldb.SqlFilter) (interface{}, error) {
+	return s.selectValue(s.trusted(s, s.db), "MAX", entity, column, filters...)
+}
+
+func (s *normal) SelectMin(entity interface{}, column string, filters ...sqldb.SqlFilter) (interface{}, error) {
+	return s.selectValue(s.trusted(s, s.db), "MIN", entity, column, filters...)
+}
+
+func (s *normal) SelectGroupCount(entity interface{}, groupColumns []string, filters ...sqldb.SqlFilter) ([]*sqldb.SqlGroupCount, error) {
+	return s.selectGroupCount(s.trusted(s, s.db), entity, groupColumns, filters...)
+}
+
+func (s *normal) Exists(entity interface{}, filters ...sqldb.SqlFilter) (bool, error) {
+	return s.exists(s.trusted(s, s.db), entity, filters...)
 }
@@ -0,0 +1,130 @@
+package sqldb
+
+import (
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// NamingStrategy names the columns of the entity fields without `sql` tag,
+// and the tables of the entities without TableName method.
+// The cached metadata is keyed by NamingKey of the strategy, share the strategy among the databases of the same names.
+type NamingStrategy interface {
+	ColumnName(fieldName string) string
+	TableName(typeName string) string
+}
+
+// NamingKeyer is implemented by the strategies of the same names identified by the key rather than the instance,
+// such as the strategies of a map type, which are not comparable
+type NamingKeyer interface {
+	NamingKey() string
+}
+
+// NamingKey identifies the names given by the strategy for the metadata cached per strategy,
+// which is the key of NamingKeyer, or else the strategy itself, so that a pointer is identified by the instance,
+// as the closures capturing different values can not be told apart by their code.
+// ok is false if the strategy is neither NamingKeyer nor comparable, whose metadata can not be cached.
+func NamingKey(naming NamingStrategy) (key interface{}, ok bool) {
+	if naming == nil {
+		return nil, true
+	}
+	if keyer, ok := naming.(NamingKeyer); ok {
+		return namingKey{t: reflect.TypeOf(naming), key: keyer.NamingKey()}, true
+	}
+	if !reflect.TypeOf(naming).Comparable() {
+		return nil, false
+	}
+
+	return naming, true
+}
+
+type namingKey struct {
+	t   reflect.Type
+	key string
+}
+
+// Naming is the NamingStrategy converting the names of fields and types by Column,
+// the table name is then pluralized and prefixed if required
+type Naming struct {
+	// SnakeCase, CamelCase, PascalCase or a custom func, the name is kept if nil
+	Column func(name string) string
+	// prefix of the table names
+	Prefix string
+	// the table names are pluralized
+	Plural bool
+}
+
+func (s *Naming) ColumnName(fieldName string) string {
+	if s.Column == nil {
+		return fieldName
+	}
+
+	return s.Column(fieldName)
+}
+
+func (s *Naming) TableName(typeName string) string {
+	name := s.ColumnName(typeName)
+	if s.Plural {
+		name = Pluralize(name)
+	}
+
+	return s.Prefix + name
+}
+
+// SnakeCase converts "UserID" to "user_id" and "HTTPServer" to "http_server"
+func SnakeCase(name string) string {
+	runes := []rune(name)
+	sb := &strings.Builder{}
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (!unicode.IsUpper(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) && runes[i-1] != '_' {
+				sb.WriteRune('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		sb.WriteRune(r)
+	}
+
+	return sb.String()
+}
+
+// CamelCase converts "UserID" to "userID" and "HTTPServer" to "httpServer"
+func CamelCase(name string) string {
+	runes := []rune(name)
+	for i := range runes {
+		if !unicode.IsUpper(runes[i]) {
+			break
+		}
+		// the last upper letter of an acronym starts the next word
+		if i > 0 && i+1 < len(runes) && unicode.IsLower(runes[i+1]) {
+			break
+		}
+		runes[i] = unicode.ToLower(runes[i])
+	}
+
+	return string(runes)
+}
+
+// PascalCase keeps the name of the exported field or type as it is
+func PascalCase(name string) string {
+	return name
+}
+
+// Pluralize returns the english plural of the last word of name
+func Pluralize(name string) string {
+	if name == "" {
+		return name
+	}
+
+	lower := strings.ToLower(name)
+	for _, suffix := range []string{"s", "x", "z", "ch", "sh"} {
+		if strings.HasSuffix(lower, suffix) {
+			return name + "es"
+		}
+	}
+	if len(lower) > 1 && strings.HasSuffix(lower, "y") && !strings.ContainsRune("aeiou", rune(lower[len(lower)-2])) {
+		return name[:len(name)-1] + "ies"
+	}
+
+	return name + "s"
+}
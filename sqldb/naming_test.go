@@ -0,0 +1,102 @@
+package sqldb
+
+import (
+	"testing"
+)
+
+func TestNaming_Case(t *testing.T) {
+	cases := []struct {
+		name, snake, camel string
+	}{
+		{"UserID", "user_id", "userID"},
+		{"HTTPServer", "http_server", "httpServer"},
+		{"Name", "name", "name"},
+		{"ID", "id", "id"},
+		{"OrderItem2", "order_item2", "orderItem2"},
+		{"Created_At", "created_at", "created_At"},
+	}
+	for _, c := range cases {
+		if SnakeCase(c.name) != c.snake {
+			t.Error("snake case of ", c.name, " error: expect=", c.snake, ", actual=", SnakeCase(c.name))
+		}
+		if CamelCase(c.name) != c.camel {
+			t.Error("camel case of ", c.name, " error: expect=", c.camel, ", actual=", CamelCase(c.name))
+		}
+	}
+}
+
+func TestNaming_TableName(t *testing.T) {
+	naming := &Naming{Column: SnakeCase, Prefix: "t_", Plural: true}
+	cases := map[string]string{
+		"User":      "t_users",
+		"OrderItem": "t_order_items",
+		"Category":  "t_categories",
+		"Box":       "t_boxes",
+		"Day":       "t_days",
+	}
+	for typeName, expect := range cases {
+		if naming.TableName(typeName) != expect {
+			t.Error("table name of ", typeName, " error: expect=", expect, ", actual=", naming.TableName(typeName))
+		}
+	}
+
+	naming = &Naming{}
+	if naming.ColumnName("UserName") != "UserName" || naming.TableName("User") != "User" {
+		t.Error("names should be kept without conversion")
+	}
+}
+
+type testKeyNaming struct {
+	Naming
+	key string
+}
+
+func (s *testKeyNaming) NamingKey() string {
+	return s.key
+}
+
+type testMapNaming map[string]string
+
+func (s testMapNaming) ColumnName(fieldName string) string {
+	return s[fieldName]
+}
+
+func (s testMapNaming) TableName(typeName string) string {
+	return typeName
+}
+
+func TestNamingKey(t *testing.T) {
+	key := func(naming NamingStrategy) interface{} {
+		key, ok := NamingKey(naming)
+		if !ok {
+			t.Fatalf("key of %T should be ok", naming)
+		}
+		return key
+	}
+
+	naming := &Naming{Column: SnakeCase, Prefix: "t_"}
+	if key(naming) != key(naming) {
+		t.Error("key of the same strategy should be same")
+	}
+	// closures of the same code print the same, but capture different prefixes
+	prefixed := func(prefix string) func(string) string {
+		return func(name string) string {
+			return prefix + name
+		}
+	}
+	if key(&Naming{Column: prefixed("a_")}) == key(&Naming{Column: prefixed("b_")}) {
+		t.Error("key of the different strategies should not be same")
+	}
+	if key(&testKeyNaming{key: "a"}) == key(&testKeyNaming{key: "b"}) {
+		t.Error("key of NamingKeyer should be used")
+	}
+	if key(&testKeyNaming{key: "a"}) != key(&testKeyNaming{key: "a"}) {
+		t.Error("key of the equal NamingKeyer should be same")
+	}
+	if key(nil) != nil {
+		t.Error("key of nil should be nil")
+	}
+	if _, ok := NamingKey(testMapNaming{}); ok {
+		t.Error("key of the map strategy should not be ok")
+	}
+}
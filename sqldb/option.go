@@ -0,0 +1,62 @@
+package sqldb
+
+// options are passed along with the filters of an operation,
+// they add no condition but change how the statement is built
+
+type option struct {
+}
+
+func (s *option) FieldOr() bool {
+	return false
+}
+
+func (s *option) GroupOr() bool {
+	return false
+}
+
+func (s *option) Fields() interface{} {
+	return nil
+}
+
+type ColumnsOption struct {
+	option
+
+	Names []string
+}
+
+// Columns restricts the selected columns, or the updated columns of Update and UpdateSelective.
+// Columns tagged with `select:"-"` are only selected when they are listed explicitly.
+func Columns(names ...string) SqlFilter {
+	return &ColumnsOption{Names: names}
+}
+
+type UnscopedOption struct {
+	option
+}
+
+// Unscoped includes the rows marked by the `softdelete:"true"` column in selects, counts and updates.
+func Unscoped() SqlFilter {
+	return &UnscopedOption{}
+}
+
+type HardDeleteOption struct {
+	option
+}
+
+// HardDelete removes the rows of a soft deleted entity by DELETE,
+// the rows already marked as deleted are removed as well.
+func HardDelete() SqlFilter {
+	return &HardDeleteOption{}
+}
+
+type PreloadOption struct {
+	option
+
+	Names []string
+}
+
+// Preload loads the relations of the entity tagged with `rel`, such as `rel:"belongsTo,fk=customerId"`,
+// by one query per relation for all selected rows of SelectOne, SelectList, SelectEach, SelectPage and SelectAfter.
+func Preload(names ...string) SqlFilter {
+	return &PreloadOption{Names: names}
+}
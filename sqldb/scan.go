@@ -0,0 +1,254 @@
+package sqldb
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	timeType    = reflect.TypeOf(time.Time{})
+	mapType     = reflect.TypeOf(map[string]interface{}{})
+
+	scanColumns = &scanColumnRegistry{items: make(map[reflect.Type]map[string][]int)}
+)
+
+// ScanRows scans the rows into dest, which is the address of
+//   - a struct, only the first row is scanned and sql.ErrNoRows is returned if there is no row
+//   - a slice of structs or struct pointers
+//   - a map[string]interface{} or a slice of them
+//   - a scalar such as int64, string, time.Time or sql.Scanner, or a slice of them, the query must return one column
+//
+// Columns are matched to struct fields by the `sql` tag, or by the field name ignoring case when untagged.
+// Embedded structs are flattened, a struct field tagged with `sql:"alias"` receives the columns
+// named "alias.column" or "alias_column", unless it is of a type enclosing it, such as `Manager *Employee` of Employee.
+// Columns without a matching field are ignored.
+func ScanRows(rows *sql.Rows, dest interface{}) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("invalid destination: not address")
+	}
+	v = v.Elem()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 {
+		itemType := v.Type().Elem()
+		isPtr := itemType.Kind() == reflect.Ptr
+		if isPtr {
+			itemType = itemType.Elem()
+		}
+
+		items := reflect.MakeSlice(v.Type(), 0, 0)
+		for rows.Next() {
+			item := reflect.New(itemType)
+			err = scanRow(rows, columns, item.Elem())
+			if err != nil {
+				return err
+			}
+			if isPtr {
+				items = reflect.Append(items, item)
+			} else {
+				items = reflect.Append(items, item.Elem())
+			}
+		}
+		err = rows.Err()
+		if err != nil {
+			return err
+		}
+		v.Set(items)
+
+		return nil
+	}
+
+	if !rows.Next() {
+		err = rows.Err()
+		if err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+
+	return scanRow(rows, columns, v)
+}
+
+func scanRow(rows *sql.Rows, columns []string, v reflect.Value) error {
+	if v.Type() == mapType {
+		values := make([]interface{}, len(columns))
+		args := make([]interface{}, len(columns))
+		for i := range values {
+			args[i] = &values[i]
+		}
+		err := rows.Scan(args...)
+		if err != nil {
+			return err
+		}
+
+		item := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			if data, ok := values[i].([]byte); ok {
+				item[column] = string(data)
+			} else {
+				item[column] = values[i]
+			}
+		}
+		v.Set(reflect.ValueOf(item))
+
+		return nil
+	}
+
+	if !isScanStruct(v.Type()) {
+		if len(columns) != 1 {
+			return fmt.Errorf("scan %d columns into %s", len(columns), v.Type())
+		}
+		return rows.Scan(v.Addr().Interface())
+	}
+
+	paths := scanColumns.load(v.Type())
+	args := make([]interface{}, len(columns))
+	for i, column := range columns {
+		path, ok := paths[strings.ToLower(column)]
+		if !ok {
+			args[i] = new(interface{})
+			continue
+		}
+		field, err := fieldByPath(v, path)
+		if err != nil {
+			return err
+		}
+		args[i] = field.Addr().Interface()
+	}
+
+	return rows.Scan(args...)
+}
+
+// structs other than time.Time and sql.Scanner are scanned field by field
+func isScanStruct(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct || t == timeType {
+		return false
+	}
+	if reflect.PtrTo(t).Implements(scannerType) {
+		return false
+	}
+
+	return true
+}
+
+// fieldByPath allocates the nil embedded pointers on the path,
+// the pointers of private types can not be allocated, as they are skipped by addScanColumns
+func fieldByPath(v reflect.Value, path []int) (reflect.Value, error) {
+	for i, index := range path {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return v, fmt.Errorf("embedded pointer %s can not be allocated", v.Type())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(index)
+	}
+
+	return v, nil
+}
+
+type scanColumnRegistry struct {
+	sync.RWMutex
+
+	items map[reflect.Type]map[string][]int
+}
+
+func (s *scanColumnRegistry) load(t reflect.Type) map[string][]int {
+	s.RLock()
+	paths, ok := s.items[t]
+	s.RUnlock()
+	if ok {
+		return paths
+	}
+
+	paths = make(map[string][]int)
+	addScanColumns(paths, t, nil, "", nil)
+
+	s.Lock()
+	defer s.Unlock()
+	s.items[t] = paths
+
+	return paths
+}
+
+// the fields of the outer struct are added before the embedded ones, so that they take precedence,
+// the types being visited are skipped, as the self-referencing struct is nested endlessly
+func addScanColumns(paths map[string][]int, t reflect.Type, parent []int, prefix string, visiting []reflect.Type) {
+	for _, visited := range visiting {
+		if visited == t {
+			return
+		}
+	}
+	visiting = append(visiting, t)
+
+	type nested struct {
+		t      reflect.Type
+		path   []int
+		prefix []string
+	}
+	nests := make([]nested, 0)
+
+	n := t.NumField()
+	for i := 0; i < n; i++ {
+		typeField := t.Field(i)
+		path := append(append([]int{}, parent...), i)
+		fieldType := typeField.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+
+		tag := typeField.Tag.Get("sql")
+		if tag == "-" {
+			continue
+		}
+		if typeField.Anonymous {
+			// nil pointer of a private type can not be allocated
+			if typeField.PkgPath != "" && typeField.Type.Kind() == reflect.Ptr {
+				continue
+			}
+			if isScanStruct(fieldType) {
+				nests = append(nests, nested{t: fieldType, path: path, prefix: []string{prefix}})
+				continue
+			}
+		}
+		// ignore private field
+		if typeField.PkgPath != "" {
+			continue
+		}
+
+		if isScanStruct(fieldType) {
+			if tag != "" {
+				nests = append(nests, nested{t: fieldType, path: path, prefix: []string{prefix + tag + ".", prefix + tag + "_"}})
+			}
+			continue
+		}
+
+		name := tag
+		if name == "" {
+			name = typeField.Name
+		}
+		name = strings.ToLower(prefix + name)
+		if _, ok := paths[name]; !ok {
+			paths[name] = path
+		}
+	}
+
+	for _, nest := range nests {
+		for _, nestPrefix := range nest.prefix {
+			addScanColumns(paths, nest.t, nest.path, nestPrefix, visiting)
+		}
+	}
+}
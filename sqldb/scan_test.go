@@ -0,0 +1,106 @@
+package sqldb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type scanBase struct {
+	ID        uint64    `sql:"id"`
+	CreatedAt time.Time `sql:"createdAt"`
+}
+
+type scanCustomer struct {
+	ID   uint64 `sql:"id"`
+	Name string `sql:"name"`
+}
+
+type scanOrder struct {
+	*scanBase
+	scanOrderNo
+
+	ID       string  `sql:"orderId"`
+	Amount   float64 `sql:"amount"`
+	Remark   string  `sql:"-"`
+	Status   int
+	Customer scanCustomer `sql:"customer"`
+	Ignored  scanCustomer
+}
+
+type scanOrderNo struct {
+	No string `sql:"no"`
+}
+
+func TestScanColumns(t *testing.T) {
+	paths := scanColumns.load(reflect.TypeOf(scanOrder{}))
+
+	expected := map[string][]int{
+		"orderid":       {2},
+		"amount":        {3},
+		"status":        {5},
+		"no":            {1, 0},
+		"customer.id":   {6, 0},
+		"customer_name": {6, 1},
+	}
+	for column, path := range expected {
+		if !reflect.DeepEqual(paths[column], path) {
+			t.Error("path of ", column, " error: expect=", path, ", actual=", paths[column])
+		}
+	}
+	for _, column := range []string{"remark", "id", "createdat", "ignored.id"} {
+		if _, ok := paths[column]; ok {
+			t.Error("column ", column, " should not be mapped")
+		}
+	}
+}
+
+type scanEmployee struct {
+	ID      uint64        `sql:"id"`
+	Manager *scanEmployee `sql:"manager"`
+}
+
+func TestScanColumns_SelfReference(t *testing.T) {
+	paths := scanColumns.load(reflect.TypeOf(scanEmployee{}))
+
+	if !reflect.DeepEqual(paths["id"], []int{0}) {
+		t.Error("path of id error: ", paths["id"])
+	}
+	// the nested struct of the same type is not mapped again
+	for _, column := range []string{"manager.id", "manager_id", "manager.manager.id"} {
+		if _, ok := paths[column]; ok {
+			t.Error("column ", column, " should not be mapped")
+		}
+	}
+}
+
+type ScanBase struct {
+	Name string
+}
+
+func TestFieldByPath(t *testing.T) {
+	type item struct {
+		*ScanBase
+	}
+
+	v := reflect.ValueOf(&item{}).Elem()
+	field, err := fieldByPath(v, []int{0, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	field.SetString("name")
+	if v.Interface().(item).ScanBase == nil || v.Interface().(item).Name != "name" {
+		t.Error("embedded pointer should be allocated")
+	}
+
+	type base struct {
+		Name string
+	}
+	type privateItem struct {
+		*base
+	}
+	_, err = fieldByPath(reflect.ValueOf(&privateItem{}).Elem(), []int{0, 0})
+	if err == nil {
+		t.Error("embedded pointer of private type should be error")
+	}
+}
@@ -0,0 +1,192 @@
+package shard
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sync"
+)
+
+const shardTagName = "shard"
+
+// Selector returns the index of the shard holding key, the value of the field tagged with `shard:"true"`,
+// count is the number of shards
+type Selector func(key interface{}, count int) (int, error)
+
+// Hash selects the shard by the FNV-1a hash of the key text
+func Hash() Selector {
+	return func(key interface{}, count int) (int, error) {
+		if count < 1 {
+			return 0, fmt.Errorf("shard: no shard")
+		}
+		key, err := keyValue(key)
+		if err != nil {
+			return 0, err
+		}
+
+		h := fnv.New32a()
+		h.Write([]byte(fmt.Sprint(key)))
+
+		return int(h.Sum32() % uint32(count)), nil
+	}
+}
+
+// Range selects the shard by the ascending upper bounds (exclusive) of the integer keys:
+// shard i holds the keys less than bounds[i], the last shard holds the keys not less than the last bound,
+// so there must be one shard more than the bounds
+func Range(bounds ...int64) Selector {
+	return func(key interface{}, count int) (int, error) {
+		if count != len(bounds)+1 {
+			return 0, fmt.Errorf("shard: %d shards for %d range bounds", count, len(bounds))
+		}
+		key, err := keyValue(key)
+		if err != nil {
+			return 0, err
+		}
+
+		var number int64
+		v := reflect.ValueOf(key)
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			number = v.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			number = int64(v.Uint())
+		default:
+			return 0, fmt.Errorf("shard: range key %T is not integer", key)
+		}
+
+		for i, bound := range bounds {
+			if number < bound {
+				return i, nil
+			}
+		}
+
+		return len(bounds), nil
+	}
+}
+
+// keyValue dereferences the pointer and driver.Valuer keys
+func keyValue(key interface{}) (interface{}, error) {
+	if valuer, ok := key.(driver.Valuer); ok {
+		value, err := valuer.Value()
+		if err != nil {
+			return nil, err
+		}
+		key = value
+	}
+	v := reflect.ValueOf(key)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, ErrNoShardKey
+		}
+		key = v.Elem().Interface()
+	}
+	if key == nil {
+		return nil, ErrNoShardKey
+	}
+
+	return key, nil
+}
+
+type keyRegistry struct {
+	sync.RWMutex
+
+	items map[reflect.Type][]int
+}
+
+var keys = &keyRegistry{items: make(map[reflect.Type][]int)}
+
+// path of the field tagged with `shard:"true"` in the struct type t, nil if not tagged
+func (s *keyRegistry) path(t reflect.Type) []int {
+	s.RLock()
+	path, ok := s.items[t]
+	s.RUnlock()
+	if ok {
+		return path
+	}
+
+	path = findKey(t, nil, make(map[reflect.Type]bool))
+
+	s.Lock()
+	s.items[t] = path
+	s.Unlock()
+
+	return path
+}
+
+// the embedded structs are searched after the fields of t
+func findKey(t reflect.Type, parent []int, visiting map[reflect.Type]bool) []int {
+	if visiting[t] {
+		return nil
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	fieldCount := t.NumField()
+	for i := 0; i < fieldCount; i++ {
+		typeField := t.Field(i)
+		if typeField.Tag.Get(shardTagName) == "true" {
+			return append(append([]int{}, parent...), i)
+		}
+	}
+	for i := 0; i < fieldCount; i++ {
+		typeField := t.Field(i)
+		if !typeField.Anonymous {
+			continue
+		}
+		fieldType := typeField.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if fieldType.Kind() != reflect.Struct {
+			continue
+		}
+		path := findKey(fieldType, append(append([]int{}, parent...), i), visiting)
+		if path != nil {
+			return path
+		}
+	}
+
+	return nil
+}
+
+// shardKey returns the value of the key field of entity, false if entity has no key field or a nil embedded pointer on the path
+func shardKey(entity interface{}) (interface{}, bool) {
+	v, ok := shardKeyField(entity)
+	if !ok {
+		return nil, false
+	}
+
+	return v.Interface(), true
+}
+
+// shardKeyField returns the key field of entity as shardKey
+func shardKeyField(entity interface{}) (reflect.Value, bool) {
+	v := reflect.ValueOf(entity)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return v, false
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return v, false
+	}
+
+	path := keys.path(v.Type())
+	if path == nil {
+		return v, false
+	}
+	for i, index := range path {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return v, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(index)
+	}
+
+	return v, true
+}
@@ -0,0 +1,554 @@
+package shard
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"github.com/ktpswjz/database/sqldb"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// returned when the shard of an operation can not be selected by the field tagged with `shard:"true"`
+	ErrNoShardKey = errors.New("shard key not found")
+)
+
+// Router selects the shard of an operation by the field of the entity tagged with `shard:"true"`.
+// Insert and UpdateByPrimaryKey are executed on the shard of the key of the entity,
+// SelectOne, SelectCount, SelectList and SelectPage on the shard of the key compared by "=" in the filters,
+// the entity of a select is only the output, so SelectOne requires the key in the filters,
+// and the others are executed on all shards in parallel without it, whose rows are merged in the order of the order entity.
+// The strings are merged in byte order unless Collate is set, which differs from the case insensitive collation
+// by default of mysql and sql server.
+type Router struct {
+	shards   []sqldb.SqlDatabase
+	selector Selector
+	collate  func(a, b string) int
+}
+
+func NewRouter(shards []sqldb.SqlDatabase, selector Selector) *Router {
+	if selector == nil {
+		selector = Hash()
+	}
+
+	return &Router{shards: shards, selector: selector, collate: strings.Compare}
+}
+
+// Collate sets the comparison of the strings merged from the shards to the collation of the order columns,
+// such as CaseInsensitive, the byte order of strings.Compare is used if nil
+func (s *Router) Collate(compare func(a, b string) int) *Router {
+	if compare == nil {
+		compare = strings.Compare
+	}
+	s.collate = compare
+
+	return s
+}
+
+// CaseInsensitive compares the strings ignoring case, like the default collations of mysql and sql server
+func CaseInsensitive(a, b string) int {
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
+
+func (s *Router) Shards() []sqldb.SqlDatabase {
+	return s.shards
+}
+
+// Shard returns the shard holding key
+func (s *Router) Shard(key interface{}) (sqldb.SqlDatabase, error) {
+	index, err := s.selector(key, len(s.shards))
+	if err != nil {
+		return nil, err
+	}
+	if index < 0 || index >= len(s.shards) {
+		return nil, fmt.Errorf("shard: index %d out of %d shards", index, len(s.shards))
+	}
+
+	return s.shards[index], nil
+}
+
+// shardOf returns the shard of the key of entity, the zero key is a key as well
+func (s *Router) shardOf(entity interface{}) (sqldb.SqlDatabase, error) {
+	key, ok := shardKey(entity)
+	if !ok {
+		return nil, ErrNoShardKey
+	}
+
+	return s.Shard(key)
+}
+
+// findShard returns the shard of the key compared by "=" in the filters, nil if the rows may be in any shard.
+// The key restricts the rows only in a filter of the fields joined by AND, which is not joined by OR to the other filters
+func (s *Router) findShard(filters []sqldb.SqlFilter) (sqldb.SqlDatabase, error) {
+	if len(s.shards) < 1 {
+		return nil, nil
+	}
+	for _, filter := range filters {
+		if filter != nil && filter.Fields() != nil && filter.GroupOr() {
+			return nil, nil
+		}
+	}
+
+	for _, filter := range filters {
+		if filter == nil || filter.Fields() == nil || filter.FieldOr() {
+			continue
+		}
+		key, ok, err := s.filterKey(filter.Fields())
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return s.Shard(key)
+		}
+	}
+
+	return nil, nil
+}
+
+// filterKey returns the key of the filter fields if it is a condition of "=", as the filter is parsed by the shards
+func (s *Router) filterKey(fields interface{}) (interface{}, bool, error) {
+	keyField, ok := shardKeyField(fields)
+	if !ok || !keyField.CanAddr() {
+		return nil, false, nil
+	}
+	address := keyField.Addr().Interface()
+
+	filterEntity := s.shards[0].NewEntity()
+	err := filterEntity.ParseFilter(fields)
+	if err != nil {
+		return nil, false, err
+	}
+	for i := 0; i < filterEntity.FieldCount(); i++ {
+		field := filterEntity.Field(i)
+		if field.Address() != address {
+			continue
+		}
+		if field.ValueEmpty() || strings.TrimSpace(field.Filter()) != "=" {
+			return nil, false, nil
+		}
+		return keyField.Interface(), true, nil
+	}
+
+	return nil, false, nil
+}
+
+func (s *Router) Insert(entity interface{}) (uint64, error) {
+	db, err := s.shardOf(entity)
+	if err != nil {
+		return 0, err
+	}
+
+	return db.Insert(entity)
+}
+
+func (s *Router) InsertSelective(entity interface{}) (uint64, error) {
+	db, err := s.shardOf(entity)
+	if err != nil {
+		return 0, err
+	}
+
+	return db.InsertSelective(entity)
+}
+
+func (s *Router) UpdateByPrimaryKey(entity interface{}) (uint64, error) {
+	db, err := s.shardOf(entity)
+	if err != nil {
+		return 0, err
+	}
+
+	return db.UpdateByPrimaryKey(entity)
+}
+
+func (s *Router) UpdateSelectiveByPrimaryKey(entity interface{}) (uint64, error) {
+	db, err := s.shardOf(entity)
+	if err != nil {
+		return 0, err
+	}
+
+	return db.UpdateSelectiveByPrimaryKey(entity)
+}
+
+// SelectOne selects from the shard of the key given by the filters
+func (s *Router) SelectOne(entity interface{}, filters ...sqldb.SqlFilter) error {
+	db, err := s.findShard(filters)
+	if err != nil {
+		return err
+	}
+	if db == nil {
+		return ErrNoShardKey
+	}
+
+	return db.SelectOne(entity, filters...)
+}
+
+func (s *Router) SelectCount(entity interface{}, filters ...sqldb.SqlFilter) (uint64, error) {
+	db, err := s.findShard(filters)
+	if err != nil {
+		return 0, err
+	}
+	if db != nil {
+		return db.SelectCount(entity, filters...)
+	}
+
+	counts := make([]uint64, len(s.shards))
+	err = s.fanOut(func(i int, db sqldb.SqlDatabase) error {
+		var err error
+		counts[i], err = db.SelectCount(entity, filters...)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	total := uint64(0)
+	for _, count := range counts {
+		total += count
+	}
+
+	return total, nil
+}
+
+func (s *Router) SelectList(entity interface{}, row func(), order interface{}, filters ...sqldb.SqlFilter) error {
+	db, err := s.findShard(filters)
+	if err != nil {
+		return err
+	}
+	if db != nil {
+		return db.SelectList(entity, row, order, filters...)
+	}
+
+	items, err := s.selectRows(entity, order, 0, filters)
+	if err != nil {
+		return err
+	}
+
+	target := reflect.ValueOf(entity).Elem()
+	for _, item := range items {
+		target.Set(item.Elem())
+		if row != nil {
+			row()
+		}
+	}
+
+	return nil
+}
+
+// SelectPage selects the first index*size rows of each shard, the page is cut from the merged rows
+func (s *Router) SelectPage(entity interface{}, page func(total, page, size, index uint64), row func(), size, index uint64, order interface{}, filters ...sqldb.SqlFilter) error {
+	db, err := s.findShard(filters)
+	if err != nil {
+		return err
+	}
+	if db != nil {
+		return db.SelectPage(entity, page, row, size, index, order, filters...)
+	}
+
+	total, err := s.SelectCount(entity, filters...)
+	if err != nil {
+		return err
+	}
+	if size < 1 {
+		size = 1
+	}
+	pageCount := total / size
+	if (total % size) != 0 {
+		pageCount++
+	}
+	pageIndex := index
+	if pageIndex > pageCount {
+		pageIndex = pageCount
+	} else if pageIndex < 1 {
+		pageIndex = 1
+	}
+	if page != nil {
+		page(total, pageCount, size, pageIndex)
+	}
+	if total < 1 {
+		return nil
+	}
+
+	items, err := s.selectRows(entity, order, pageIndex*size, filters)
+	if err != nil {
+		return err
+	}
+	start := (pageIndex - 1) * size
+	end := start + size
+	if start > uint64(len(items)) {
+		start = uint64(len(items))
+	}
+	if end > uint64(len(items)) {
+		end = uint64(len(items))
+	}
+
+	target := reflect.ValueOf(entity).Elem()
+	for _, item := range items[start:end] {
+		target.Set(item.Elem())
+		if row != nil {
+			row()
+		}
+	}
+
+	return nil
+}
+
+// fanOut calls fn for each shard in parallel, the first error in the order of the shards is returned
+func (s *Router) fanOut(fn func(i int, db sqldb.SqlDatabase) error) error {
+	errs := make([]error, len(s.shards))
+	wg := &sync.WaitGroup{}
+	for i, db := range s.shards {
+		wg.Add(1)
+		go func(i int, db sqldb.SqlDatabase) {
+			defer wg.Done()
+			errs[i] = fn(i, db)
+		}(i, db)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// selectRows selects the rows of all shards into new entities of the type of entity, at most limit rows of each shard if limit > 0,
+// the rows are merged in the order of the order entity
+func (s *Router) selectRows(entity interface{}, order interface{}, limit uint64, filters []sqldb.SqlFilter) ([]reflect.Value, error) {
+	v := reflect.ValueOf(entity)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("shard: invalid entity %T", entity)
+	}
+	t := v.Elem().Type()
+
+	rows := make([][]reflect.Value, len(s.shards))
+	err := s.fanOut(func(i int, db sqldb.SqlDatabase) error {
+		target := reflect.New(t)
+		row := func() {
+			item := reflect.New(t)
+			item.Elem().Set(target.Elem())
+			rows[i] = append(rows[i], item)
+		}
+		if limit > 0 {
+			return db.SelectPage(target.Interface(), nil, row, limit, 1, order, filters...)
+		}
+		return db.SelectList(target.Interface(), row, order, filters...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]reflect.Value, 0)
+	for _, shardRows := range rows {
+		items = append(items, shardRows...)
+	}
+	if order == nil || len(s.shards) < 2 {
+		return items, nil
+	}
+
+	return s.sortRows(items, order)
+}
+
+type sortColumn struct {
+	name string
+	desc bool
+}
+
+// sortRows sorts the rows by the columns of the order entity, like the order clause of each shard
+func (s *Router) sortRows(items []reflect.Value, order interface{}) ([]reflect.Value, error) {
+	orderEntity := s.shards[0].NewEntity()
+	err := orderEntity.Parse(order)
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]sortColumn, orderEntity.FieldCount())
+	for i := range columns {
+		field := orderEntity.Field(i)
+		columns[i] = sortColumn{name: field.Name(), desc: strings.EqualFold(field.Order(), "DESC")}
+	}
+
+	values := make([][]interface{}, len(items))
+	for i, item := range items {
+		itemEntity := s.shards[0].NewEntity()
+		err = itemEntity.Parse(item.Interface())
+		if err != nil {
+			return nil, err
+		}
+		fields := make(map[string]interface{}, itemEntity.FieldCount())
+		for j := 0; j < itemEntity.FieldCount(); j++ {
+			field := itemEntity.Field(j)
+			fields[field.Name()] = field.Value()
+		}
+		values[i] = make([]interface{}, len(columns))
+		for j, column := range columns {
+			values[i][j] = fields[column.name]
+		}
+	}
+
+	indexes := make([]int, len(items))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(a, b int) bool {
+		for j, column := range columns {
+			result := compareValues(values[indexes[a]][j], values[indexes[b]][j], s.collate)
+			if result == 0 {
+				continue
+			}
+			if column.desc {
+				return result > 0
+			}
+			return result < 0
+		}
+		return false
+	})
+
+	sorted := make([]reflect.Value, len(items))
+	for i, index := range indexes {
+		sorted[i] = items[index]
+	}
+
+	return sorted, nil
+}
+
+// compareValues compares the values of a column, NULL is less than any value as in mysql and sql server,
+// the strings are compared by collate
+func compareValues(a, b interface{}, collate func(a, b string) int) int {
+	a, b = sortValue(a), sortValue(b)
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+
+	switch x := a.(type) {
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			switch {
+			case x.Before(y):
+				return -1
+			case x.After(y):
+				return 1
+			}
+			return 0
+		}
+	case []byte:
+		if y, ok := b.([]byte); ok {
+			return bytes.Compare(x, y)
+		}
+	}
+
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	switch {
+	case isInt(va) && isInt(vb):
+		return compareInt(va.Int(), vb.Int())
+	case isUint(va) && isUint(vb):
+		return compareUint(va.Uint(), vb.Uint())
+	case isNumber(va) && isNumber(vb):
+		return compareFloat(toFloat(va), toFloat(vb))
+	case va.Kind() == reflect.Bool && vb.Kind() == reflect.Bool:
+		return compareInt(boolInt(va.Bool()), boolInt(vb.Bool()))
+	case va.Kind() == reflect.String && vb.Kind() == reflect.String:
+		return collate(va.String(), vb.String())
+	}
+
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// the pointers and driver.Valuer values are compared by the values they hold
+func sortValue(value interface{}) interface{} {
+	if valuer, ok := value.(driver.Valuer); ok {
+		v, err := valuer.Value()
+		if err != nil {
+			return nil
+		}
+		value = v
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		return sortValue(v.Elem().Interface())
+	}
+
+	return value
+}
+
+func isInt(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+func isUint(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+func isNumber(v reflect.Value) bool {
+	return isInt(v) || isUint(v) || v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64
+}
+
+func toFloat(v reflect.Value) float64 {
+	switch {
+	case isInt(v):
+		return float64(v.Int())
+	case isUint(v):
+		return float64(v.Uint())
+	}
+	return v.Float()
+}
+
+func boolInt(value bool) int64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
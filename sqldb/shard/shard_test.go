@@ -0,0 +1,332 @@
+package shard
+
+import (
+	"fmt"
+	"github.com/ktpswjz/database/sqldb"
+	"github.com/ktpswjz/database/sqldb/mysql"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testOrderBase struct {
+	TenantID uint64 `sql:"tenantId" shard:"true"`
+}
+
+type testOrder struct {
+	testOrderBase
+
+	ID     uint64 `sql:"id" primary:"true"`
+	Amount int    `sql:"amount"`
+}
+
+func (s testOrder) TableName() string {
+	return "tabOrder"
+}
+
+type testOrderFilter struct {
+	TenantID uint64 `sql:"tenantId" shard:"true"`
+}
+
+type testOrderRangeFilter struct {
+	MinTenantID *uint64 `sql:"tenantId" filter:">=" shard:"true"`
+}
+
+type testOrderAmount struct {
+	Amount int `sql:"amount" order:"DESC"`
+}
+
+func (s testOrderAmount) TableName() string {
+	return "tabOrder"
+}
+
+// testShard holds the rows in the order of the amount
+type testShard struct {
+	sqldb.SqlDatabase
+
+	name    string
+	rows    []testOrder
+	inserts int
+	counts  int
+}
+
+func (s *testShard) Insert(entity interface{}) (uint64, error) {
+	s.inserts++
+	return 1, nil
+}
+
+func (s *testShard) SelectOne(entity interface{}, filters ...sqldb.SqlFilter) error {
+	*entity.(*testOrder) = s.rows[0]
+	return nil
+}
+
+func (s *testShard) SelectCount(entity interface{}, filters ...sqldb.SqlFilter) (uint64, error) {
+	s.counts++
+	return uint64(len(s.rows)), nil
+}
+
+func (s *testShard) SelectList(entity interface{}, row func(), order interface{}, filters ...sqldb.SqlFilter) error {
+	for _, item := range s.rows {
+		*entity.(*testOrder) = item
+		row()
+	}
+	return nil
+}
+
+func (s *testShard) SelectPage(entity interface{}, page func(total, page, size, index uint64), row func(), size, index uint64, order interface{}, filters ...sqldb.SqlFilter) error {
+	for i, item := range s.rows {
+		if uint64(i) >= size {
+			break
+		}
+		*entity.(*testOrder) = item
+		row()
+	}
+	return nil
+}
+
+func newTestRouter() (*Router, []*testShard) {
+	db := mysql.NewDatabase(nil)
+	shards := []*testShard{
+		{SqlDatabase: db, name: "0", rows: []testOrder{{ID: 1, Amount: 90}, {ID: 2, Amount: 50}, {ID: 3, Amount: 10}}},
+		{SqlDatabase: db, name: "1", rows: []testOrder{{ID: 4, Amount: 70}, {ID: 5, Amount: 60}, {ID: 6, Amount: 20}}},
+	}
+
+	return NewRouter([]sqldb.SqlDatabase{shards[0], shards[1]}, Range(100)), shards
+}
+
+func TestRange(t *testing.T) {
+	selector := Range(100, 200)
+	for key, expect := range map[interface{}]int{int64(-1): 0, 99: 0, uint8(100): 1, 199: 1, uint64(200): 2} {
+		index, err := selector(key, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if index != expect {
+			t.Error("range index error of ", key, ": expect=", expect, ", actual=", index)
+		}
+	}
+	if _, err := selector("a", 3); err == nil {
+		t.Error("string key should be error")
+	}
+	if _, err := selector(1, 2); err == nil {
+		t.Error("shard count should be checked")
+	}
+}
+
+func TestHash(t *testing.T) {
+	selector := Hash()
+	first, err := selector("tenant", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := "tenant"
+	second, err := selector(&key, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second || first < 0 || first >= 4 {
+		t.Error("hash index error: ", first, second)
+	}
+	if _, err = selector((*string)(nil), 4); err != ErrNoShardKey {
+		t.Error("nil key should be error: ", err)
+	}
+}
+
+func TestRouter_Route(t *testing.T) {
+	router, shards := newTestRouter()
+	_, err := router.Insert(&testOrder{testOrderBase: testOrderBase{TenantID: 150}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = router.Insert(&testOrder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shards[0].inserts != 1 || shards[1].inserts != 1 {
+		t.Error("insert routing error: ", shards[0].inserts, shards[1].inserts)
+	}
+	if _, err = router.Insert(&testOrderFilter{}); err != nil {
+		t.Error(err)
+	}
+	if _, err = router.Insert(&testOrderAmount{}); err != ErrNoShardKey {
+		t.Error("entity without key should be error: ", err)
+	}
+
+	// the key of the filter selects the shard
+	dbEntity := &testOrder{}
+	err = router.SelectOne(dbEntity, shards[0].NewFilter(&testOrderFilter{TenantID: 120}, false, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dbEntity.ID != 4 {
+		t.Error("select one routing error: ", dbEntity.ID)
+	}
+	if err = router.SelectOne(&testOrder{}); err != ErrNoShardKey {
+		t.Error("select one without key should be error: ", err)
+	}
+}
+
+func TestRouter_FanOut(t *testing.T) {
+	router, _ := newTestRouter()
+	count, err := router.SelectCount(&testOrder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 6 {
+		t.Error("count error: ", count)
+	}
+
+	dbEntity := &testOrder{}
+	ids := make([]string, 0)
+	err = router.SelectList(dbEntity, func() {
+		ids = append(ids, fmt.Sprint(dbEntity.ID))
+	}, &testOrderAmount{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(ids, ",") != "1,4,5,2,6,3" {
+		t.Error("merged order error: ", ids)
+	}
+
+	ids = ids[:0]
+	pages := ""
+	err = router.SelectPage(dbEntity, func(total, page, size, index uint64) {
+		pages = fmt.Sprint(total, page, size, index)
+	}, func() {
+		ids = append(ids, fmt.Sprint(dbEntity.ID))
+	}, 4, 2, &testOrderAmount{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages != "6 2 4 2" || strings.Join(ids, ",") != "6,3" {
+		t.Error("page error: ", pages, ids)
+	}
+}
+
+func TestCompareValues(t *testing.T) {
+	one := 1
+	cases := []struct {
+		a, b   interface{}
+		expect int
+	}{
+		{1, 2, -1},
+		{uint64(3), 2, 1},
+		{&one, int64(1), 0},
+		{nil, 0, -1},
+		{"b", "a", 1},
+		{1.5, 2, -1},
+	}
+	for _, c := range cases {
+		if result := compareValues(c.a, c.b, strings.Compare); result != c.expect {
+			t.Error("compare error of ", c.a, " and ", c.b, ": expect=", c.expect, ", actual=", result)
+		}
+	}
+
+	if _, ok := shardKey(&testOrder{}); !ok {
+		t.Error("key of embedded struct should be found")
+	}
+	if path := keys.path(reflect.TypeOf(testOrderAmount{})); path != nil {
+		t.Error("entity without key should have no path: ", path)
+	}
+}
+
+type testCustomer struct {
+	ID   uint64 `sql:"id" primary:"true"`
+	Name string `sql:"name"`
+}
+
+func (s testCustomer) TableName() string {
+	return "tabCustomer"
+}
+
+type testCustomerName struct {
+	Name string `sql:"name"`
+	ID   uint64 `sql:"id"`
+}
+
+func (s testCustomerName) TableName() string {
+	return "tabCustomer"
+}
+
+func TestRouter_Collate(t *testing.T) {
+	router, _ := newTestRouter()
+	items := make([]reflect.Value, 0)
+	for i, name := range []string{"bob", "Alice", "Bill", "anna"} {
+		items = append(items, reflect.ValueOf(&testCustomer{ID: uint64(i + 1), Name: name}))
+	}
+	sorted := func() string {
+		rows, err := router.sortRows(items, &testCustomerName{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, 0, len(rows))
+		for _, row := range rows {
+			names = append(names, row.Interface().(*testCustomer).Name)
+		}
+		return strings.Join(names, ",")
+	}
+
+	// the upper case letters are before the lower case ones in byte order
+	if names := sorted(); names != "Alice,Bill,anna,bob" {
+		t.Error("byte order error: ", names)
+	}
+	router.Collate(CaseInsensitive)
+	if names := sorted(); names != "Alice,anna,Bill,bob" {
+		t.Error("case insensitive order error: ", names)
+	}
+}
+
+func TestRouter_FilterRoute(t *testing.T) {
+	router, shards := newTestRouter()
+	key := uint64(150)
+	cases := []struct {
+		name    string
+		filters []sqldb.SqlFilter
+		routed  bool
+	}{
+		{"equal", []sqldb.SqlFilter{shards[0].NewFilter(&testOrderFilter{TenantID: key}, false, false)}, true},
+		{"range", []sqldb.SqlFilter{shards[0].NewFilter(&testOrderRangeFilter{MinTenantID: &key}, false, false)}, false},
+		{"field or", []sqldb.SqlFilter{shards[0].NewFilter(&testOrderFilter{TenantID: key}, true, false)}, false},
+		{"group or", []sqldb.SqlFilter{
+			shards[0].NewFilter(&testOrderFilter{TenantID: key}, false, false),
+			shards[0].NewFilter(&testOrderRangeFilter{MinTenantID: &key}, false, true),
+		}, false},
+		{"no filter", nil, false},
+	}
+	for _, c := range cases {
+		shards[0].counts, shards[1].counts = 0, 0
+		// the key of the entity is not a condition of the select
+		_, err := router.SelectCount(&testOrder{testOrderBase: testOrderBase{TenantID: key}}, c.filters...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		routed := shards[0].counts == 0 && shards[1].counts == 1
+		fannedOut := shards[0].counts == 1 && shards[1].counts == 1
+		if (c.routed && !routed) || (!c.routed && !fannedOut) {
+			t.Error(c.name+" routing error: ", shards[0].counts, shards[1].counts)
+		}
+	}
+
+	// the entity reused as the output of a fan-out selects nothing
+	dbEntity := &testOrder{}
+	err := router.SelectList(dbEntity, func() {}, &testOrderAmount{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbEntity.TenantID = key
+	if count, _ := router.SelectCount(dbEntity); count != 6 {
+		t.Error("reused entity should not select the shard: ", count)
+	}
+	if err = router.SelectOne(dbEntity); err != ErrNoShardKey {
+		t.Error("select one by the key of the entity should be error: ", err)
+	}
+}
+
+func TestRouter_InvalidOrder(t *testing.T) {
+	router, _ := newTestRouter()
+	// the order entity without table name can not be parsed
+	err := router.SelectList(&testOrder{}, func() {}, &testOrderFilter{})
+	if err == nil {
+		t.Error("invalid order should be error")
+	}
+}
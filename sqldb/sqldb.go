@@ -1,6 +1,7 @@
 package sqldb
 
 import (
+	"context"
 	"database/sql"
 )
 
@@ -17,11 +18,17 @@ type SqlDatabase interface {
 	Columns(tableName string) ([]*SqlColumn, error)
 
 	NewAccess(transactional bool) (SqlAccess, error)
+	NewAccessTx(ctx context.Context, opts TxOptions) (SqlAccess, error)
+	RunInTx(ctx context.Context, opts TxOptions, fn func(tx SqlAccess) error) error
+	WithContext(ctx context.Context) SqlDatabase
+	ForTenant(id interface{}, options ...Option) SqlDatabase
 	NewEntity() SqlEntity
 	NewBuilder() SqlBuilder
 	NewFilter(entity interface{}, fieldOr, groupOr bool) SqlFilter
 
 	IsNoRows(err error) bool
+	IsRetryable(err error) bool
+	QueryInto(dest interface{}, query string, args ...interface{}) error
 	Insert(entity interface{}) (uint64, error)
 	InsertSelective(entity interface{}) (uint64, error)
 	Delete(entity interface{}, filters ...SqlFilter) (uint64, error)
@@ -30,23 +37,42 @@ type SqlDatabase interface {
 	UpdateByPrimaryKey(entity interface{}) (uint64, error)
 	UpdateSelectiveByPrimaryKey(entity interface{}) (uint64, error)
 	SelectCount(entity interface{}, filters ...SqlFilter) (uint64, error)
+	SelectSum(entity interface{}, column string, filters ...SqlFilter) (float64, error)
+	SelectAvg(entity interface{}, column string, filters ...SqlFilter) (float64, error)
+	SelectMax(entity interface{}, column string, filters ...SqlFilter) (interface{}, error)
+	SelectMin(entity interface{}, column string, filters ...SqlFilter) (interface{}, error)
+	SelectGroupCount(entity interface{}, groupColumns []string, filters ...SqlFilter) ([]*SqlGroupCount, error)
+	Exists(entity interface{}, filters ...SqlFilter) (bool, error)
 	SelectOne(entity interface{}, filters ...SqlFilter) error
 	SelectDistinct(entity interface{}, row func(), order interface{}, filters ...SqlFilter) error
 	SelectList(entity interface{}, row func(), order interface{}, filters ...SqlFilter) error
+	SelectEach(entity interface{}, row func() error, order interface{}, filters ...SqlFilter) error
+	Iterate(entity interface{}, order interface{}, filters ...SqlFilter) (SqlIterator, error)
 	SelectPage(entity interface{}, page func(total, page, size, index uint64), row func(), size, index uint64, order interface{}, filters ...SqlFilter) error
+	SelectAfter(entity interface{}, page func(total uint64), row func(), cursor string, size uint64, order interface{}, filters ...SqlFilter) (*SqlCursor, error)
 }
 
 type SqlAccess interface {
 	Close() error
 	Commit() error
+	Rollback() error
+	InTransaction() bool
+	OnCommit(fn func())
+	OnRollback(fn func())
 	Version() int
 
+	Savepoint(name string) error
+	RollbackTo(name string) error
+	Release(name string) error
+
 	Exec(query string, args ...interface{}) (sql.Result, error)
 	Prepare(query string) (*sql.Stmt, error)
 	Query(query string, args ...interface{}) (*sql.Rows, error)
 	QueryRow(query string, args ...interface{}) *sql.Row
+	QueryInto(dest interface{}, query string, args ...interface{}) error
 
 	IsNoRows(err error) bool
+	IsRetryable(err error) bool
 	Insert(entity interface{}) (uint64, error)
 	InsertSelective(entity interface{}) (uint64, error)
 	Delete(entity interface{}, filters ...SqlFilter) (uint64, error)
@@ -55,10 +81,26 @@ type SqlAccess interface {
 	UpdateByPrimaryKey(entity interface{}) (uint64, error)
 	UpdateSelectiveByPrimaryKey(entity interface{}) (uint64, error)
 	SelectCount(entity interface{}, filters ...SqlFilter) (uint64, error)
+	SelectSum(entity interface{}, column string, filters ...SqlFilter) (float64, error)
+	SelectAvg(entity interface{}, column string, filters ...SqlFilter) (float64, error)
+	SelectMax(entity interface{}, column string, filters ...SqlFilter) (interface{}, error)
+	SelectMin(entity interface{}, column string, filters ...SqlFilter) (interface{}, error)
+	SelectGroupCount(entity interface{}, groupColumns []string, filters ...SqlFilter) ([]*SqlGroupCount, error)
+	Exists(entity interface{}, filters ...SqlFilter) (bool, error)
 	SelectOne(entity interface{}, filters ...SqlFilter) error
 	SelectDistinct(entity interface{}, row func(), order interface{}, filters ...SqlFilter) error
 	SelectList(entity interface{}, row func(), order interface{}, filters ...SqlFilter) error
+	SelectEach(entity interface{}, row func() error, order interface{}, filters ...SqlFilter) error
+	Iterate(entity interface{}, order interface{}, filters ...SqlFilter) (SqlIterator, error)
 	SelectPage(entity interface{}, page func(total, page, size, index uint64), row func(), size, index uint64, order interface{}, filters ...SqlFilter) error
+	SelectAfter(entity interface{}, page func(total uint64), row func(), cursor string, size uint64, order interface{}, filters ...SqlFilter) (*SqlCursor, error)
+}
+
+// SqlIterator scans the rows one by one into the entity passed to Iterate
+type SqlIterator interface {
+	Next() bool
+	Err() error
+	Close() error
 }
 
 type SqlField interface {
@@ -118,6 +160,12 @@ type SqlTable struct {
 	Description string `json:"description"`
 }
 
+// SqlGroupCount is a row of SelectGroupCount, Values are in the order of the group columns
+type SqlGroupCount struct {
+	Values []interface{} `json:"values" note:"分组字段值"`
+	Count  uint64        `json:"count" note:"数量"`
+}
+
 type SqlColumn struct {
 	Name    string `json:"name" note:"名称"`
 	Type    string `json:"type" note:"类型"`
@@ -0,0 +1,1066 @@
+package sqldbtest
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ktpswjz/database/sqldb"
+	"reflect"
+	"sort"
+)
+
+// access works on the tables of the database, or on the snapshot of the tables if transactional,
+// which replaces the changed tables of the database when committed unless they have been changed since the snapshot
+type access struct {
+	db *Database
+
+	tx         *store
+	dirty      map[string]bool
+	savepoints []*savepoint
+	state      int
+	onCommit   []func()
+	onRollback []func()
+}
+
+const (
+	txActive = iota
+	txCommitted
+	txRolledBack
+)
+
+type savepoint struct {
+	name string
+	data *store
+}
+
+// read calls fn with the tables
+func (s *access) read(fn func(data *store) error) error {
+	if s.tx != nil {
+		if s.state != txActive {
+			return sql.ErrTxDone
+		}
+		return fn(s.tx)
+	}
+
+	s.db.memory.RLock()
+	defer s.db.memory.RUnlock()
+
+	return fn(s.db.memory.data)
+}
+
+// write calls fn with the tables, the table name is marked as changed in the transaction
+func (s *access) write(name string, fn func(data *store) error) error {
+	if s.tx != nil {
+		if s.state != txActive {
+			return sql.ErrTxDone
+		}
+		s.dirty[name] = true
+		return fn(s.tx)
+	}
+
+	s.db.memory.Lock()
+	defer s.db.memory.Unlock()
+
+	data := s.db.memory.data
+	defer func() {
+		data.table(name).version++
+	}()
+
+	return fn(data)
+}
+
+func (s *access) Close() error {
+	if s.tx != nil && s.state == txActive {
+		return s.Rollback()
+	}
+
+	return nil
+}
+
+func (s *access) Commit() error {
+	if s.tx == nil {
+		return sqldb.ErrNotInTransaction
+	}
+	if s.state != txActive {
+		return sql.ErrTxDone
+	}
+
+	err := s.commit()
+	if err != nil {
+		s.state = txRolledBack
+		for _, fn := range s.onRollback {
+			fn()
+		}
+		return err
+	}
+
+	s.state = txCommitted
+	for _, fn := range s.onCommit {
+		fn()
+	}
+
+	return nil
+}
+
+// commit replaces the changed tables, the versions of the snapshot are kept by the transaction
+// so that a table changed by others since the snapshot is a conflict
+func (s *access) commit() error {
+	s.db.memory.Lock()
+	defer s.db.memory.Unlock()
+
+	for name := range s.dirty {
+		version := uint64(0)
+		if t, ok := s.db.memory.data.tables[name]; ok {
+			version = t.version
+		}
+		if version != s.tx.table(name).version {
+			return ErrConflict
+		}
+	}
+	for name := range s.dirty {
+		t := s.tx.table(name)
+		t.version++
+		s.db.memory.data.tables[name] = t
+	}
+
+	return nil
+}
+
+func (s *access) Rollback() error {
+	if s.tx == nil {
+		return sqldb.ErrNotInTransaction
+	}
+	if s.state != txActive {
+		return sql.ErrTxDone
+	}
+
+	s.state = txRolledBack
+	for _, fn := range s.onRollback {
+		fn()
+	}
+
+	return nil
+}
+
+func (s *access) InTransaction() bool {
+	return s.tx != nil && s.state == txActive
+}
+
+// statements are auto committed without transaction, fn is called immediately, as after the transaction committed
+func (s *access) OnCommit(fn func()) {
+	if fn == nil {
+		return
+	}
+	if s.tx == nil {
+		fn()
+		return
+	}
+
+	switch s.state {
+	case txActive:
+		s.onCommit = append(s.onCommit, fn)
+	case txCommitted:
+		fn()
+	}
+}
+
+// fn is called immediately after the transaction rolled back
+func (s *access) OnRollback(fn func()) {
+	if fn == nil || s.tx == nil {
+		return
+	}
+
+	switch s.state {
+	case txActive:
+		s.onRollback = append(s.onRollback, fn)
+	case txRolledBack:
+		fn()
+	}
+}
+
+func (s *access) Version() int {
+	return 0
+}
+
+func (s *access) Savepoint(name string) error {
+	if s.tx == nil {
+		return sqldb.ErrNotInTransaction
+	}
+	if s.state != txActive {
+		return sql.ErrTxDone
+	}
+
+	s.savepoints = append(s.savepoints, &savepoint{name: name, data: s.tx.clone()})
+
+	return nil
+}
+
+func (s *access) findSavepoint(name string) (int, error) {
+	if s.tx == nil {
+		return 0, sqldb.ErrNotInTransaction
+	}
+	if s.state != txActive {
+		return 0, sql.ErrTxDone
+	}
+	for i := len(s.savepoints) - 1; i >= 0; i-- {
+		if s.savepoints[i].name == name {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("sqldbtest: savepoint %s does not exist", name)
+}
+
+// RollbackTo restores the tables of the savepoint, which is kept while the later ones are released
+func (s *access) RollbackTo(name string) error {
+	index, err := s.findSavepoint(name)
+	if err != nil {
+		return err
+	}
+
+	s.tx = s.savepoints[index].data.clone()
+	s.savepoints = s.savepoints[:index+1]
+
+	return nil
+}
+
+func (s *access) Release(name string) error {
+	index, err := s.findSavepoint(name)
+	if err != nil {
+		return err
+	}
+
+	s.savepoints = s.savepoints[:index]
+
+	return nil
+}
+
+func (s *access) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return s.db.raw.Exec(query, args...)
+}
+
+func (s *access) Prepare(query string) (*sql.Stmt, error) {
+	return s.db.raw.Prepare(query)
+}
+
+func (s *access) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.raw.Query(query, args...)
+}
+
+func (s *access) QueryRow(query string, args ...interface{}) *sql.Row {
+	return s.db.raw.QueryRow(query, args...)
+}
+
+func (s *access) QueryInto(dest interface{}, query string, args ...interface{}) error {
+	return ErrNotSupported
+}
+
+func (s *access) IsNoRows(err error) bool {
+	return s.db.IsNoRows(err)
+}
+
+func (s *access) IsRetryable(err error) bool {
+	return s.db.IsRetryable(err)
+}
+
+func (s *access) Insert(entity interface{}) (uint64, error) {
+	return s.insert(false, entity)
+}
+
+func (s *access) InsertSelective(entity interface{}) (uint64, error) {
+	return s.insert(true, entity)
+}
+
+func (s *access) Delete(entity interface{}, filters ...sqldb.SqlFilter) (uint64, error) {
+	return s.delete(entity, filters)
+}
+
+func (s *access) Update(entity interface{}, filters ...sqldb.SqlFilter) (uint64, error) {
+	return s.update(false, entity, filters)
+}
+
+func (s *access) UpdateSelective(entity interface{}, filters ...sqldb.SqlFilter) (uint64, error) {
+	return s.update(true, entity, filters)
+}
+
+func (s *access) UpdateByPrimaryKey(entity interface{}) (uint64, error) {
+	return s.updateByPrimaryKey(false, entity)
+}
+
+func (s *access) UpdateSelectiveByPrimaryKey(entity interface{}) (uint64, error) {
+	return s.updateByPrimaryKey(true, entity)
+}
+
+func (s *access) SelectCount(entity interface{}, filters ...sqldb.SqlFilter) (uint64, error) {
+	_, rows, err := s.selectRows(entity, nil, filters)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(len(rows)), nil
+}
+
+func (s *access) SelectSum(entity interface{}, column string, filters ...sqldb.SqlFilter) (float64, error) {
+	sum, _, err := s.selectNumber(entity, column, filters)
+	return sum, err
+}
+
+func (s *access) SelectAvg(entity interface{}, column string, filters ...sqldb.SqlFilter) (float64, error) {
+	sum, count, err := s.selectNumber(entity, column, filters)
+	if err != nil || count == 0 {
+		return 0, err
+	}
+
+	return sum / float64(count), nil
+}
+
+func (s *access) SelectMax(entity interface{}, column string, filters ...sqldb.SqlFilter) (interface{}, error) {
+	return s.selectValue(entity, column, 1, filters)
+}
+
+func (s *access) SelectMin(entity interface{}, column string, filters ...sqldb.SqlFilter) (interface{}, error) {
+	return s.selectValue(entity, column, -1, filters)
+}
+
+// SelectGroupCount returns the groups in the ascending order of their values
+func (s *access) SelectGroupCount(entity interface{}, groupColumns []string, filters ...sqldb.SqlFilter) ([]*sqldb.SqlGroupCount, error) {
+	if len(groupColumns) < 1 {
+		return nil, fmt.Errorf("sqldbtest: no group column")
+	}
+	_, rows, err := s.selectRows(entity, nil, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]*sqldb.SqlGroupCount, 0)
+	indexes := make(map[string]int)
+	for _, r := range rows {
+		values := make([]interface{}, len(groupColumns))
+		for i, column := range groupColumns {
+			values[i] = plainValue(r[quote(column)])
+		}
+		key := fmt.Sprintf("%#v", values)
+		index, ok := indexes[key]
+		if !ok {
+			index = len(groups)
+			indexes[key] = index
+			groups = append(groups, &sqldb.SqlGroupCount{Values: values})
+		}
+		groups[index].Count++
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		for k := range groupColumns {
+			result := compareValues(groups[i].Values[k], groups[j].Values[k])
+			if result != 0 {
+				return result < 0
+			}
+		}
+		return false
+	})
+
+	return groups, nil
+}
+
+func (s *access) Exists(entity interface{}, filters ...sqldb.SqlFilter) (bool, error) {
+	count, err := s.SelectCount(entity, filters...)
+	return count > 0, err
+}
+
+func (s *access) SelectOne(entity interface{}, filters ...sqldb.SqlFilter) error {
+	sqlEntity, rows, err := s.selectRows(entity, nil, filters)
+	if err != nil {
+		return err
+	}
+	if len(rows) < 1 {
+		return sql.ErrNoRows
+	}
+
+	return s.scan(sqlEntity, rows[0], entity)
+}
+
+func (s *access) SelectDistinct(entity interface{}, row func(), order interface{}, filters ...sqldb.SqlFilter) error {
+	sqlEntity, rows, err := s.selectRows(entity, order, filters)
+	if err != nil {
+		return err
+	}
+
+	// the rows are distinct by the columns of the entity
+	added := make(map[string]bool)
+	for _, r := range rows {
+		values := make([]interface{}, sqlEntity.FieldCount())
+		for i := range values {
+			values[i] = plainValue(r[sqlEntity.Field(i).Name()])
+		}
+		key := fmt.Sprintf("%#v", values)
+		if added[key] {
+			continue
+		}
+		added[key] = true
+
+		err = s.scan(sqlEntity, r, entity)
+		if err != nil {
+			return err
+		}
+		if row != nil {
+			row()
+		}
+	}
+
+	return nil
+}
+
+func (s *access) SelectList(entity interface{}, row func(), order interface{}, filters ...sqldb.SqlFilter) error {
+	return s.SelectEach(entity, func() error {
+		if row != nil {
+			row()
+		}
+		return nil
+	}, order, filters...)
+}
+
+func (s *access) SelectEach(entity interface{}, row func() error, order interface{}, filters ...sqldb.SqlFilter) error {
+	sqlEntity, rows, err := s.selectRows(entity, order, filters)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range rows {
+		err = s.scan(sqlEntity, r, entity)
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			continue
+		}
+
+		err = row()
+		if errors.Is(err, sqldb.ErrStop) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *access) Iterate(entity interface{}, order interface{}, filters ...sqldb.SqlFilter) (sqldb.SqlIterator, error) {
+	sqlEntity, rows, err := s.selectRows(entity, order, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	return &iterator{access: s, sqlEntity: sqlEntity, entity: entity, rows: rows}, nil
+}
+
+func (s *access) SelectPage(entity interface{}, page func(total, page, size, index uint64), row func(), size, index uint64, order interface{}, filters ...sqldb.SqlFilter) error {
+	sqlEntity, rows, err := s.selectRows(entity, order, filters)
+	if err != nil {
+		return err
+	}
+
+	total := uint64(len(rows))
+	if size < 1 {
+		size = 1
+	}
+	pageCount := total / size
+	if (total % size) != 0 {
+		pageCount++
+	}
+	pageIndex := index
+	if pageIndex > pageCount {
+		pageIndex = pageCount
+	} else if pageIndex < 1 {
+		pageIndex = 1
+	}
+	if page != nil {
+		page(total, pageCount, size, pageIndex)
+	}
+	if total < 1 {
+		return nil
+	}
+
+	start := (pageIndex - 1) * size
+	end := start + size
+	if end > total {
+		end = total
+	}
+	for _, r := range rows[start:end] {
+		err = s.scan(sqlEntity, r, entity)
+		if err != nil {
+			return err
+		}
+		if row != nil {
+			row()
+		}
+	}
+
+	return nil
+}
+
+// the cursors of SelectAfter hold the position of the row in the ordered rows
+var cursorColumns = []string{"position"}
+
+func (s *access) SelectAfter(entity interface{}, page func(total uint64), row func(), cursor string, size uint64, order interface{}, filters ...sqldb.SqlFilter) (*sqldb.SqlCursor, error) {
+	sqlEntity, rows, err := s.selectRows(entity, order, filters)
+	if err != nil {
+		return nil, err
+	}
+	if size < 1 {
+		size = 1
+	}
+
+	total := uint64(len(rows))
+	start, end := uint64(0), size
+	if cursor != "" {
+		backward, values, err := sqldb.DecodeCursor(cursor, cursorColumns)
+		if err != nil {
+			return nil, err
+		}
+		position := uint64(0)
+		err = json.Unmarshal(values[0], &position)
+		if err != nil {
+			return nil, sqldb.ErrInvalidCursor
+		}
+		if backward {
+			end = position
+			start = 0
+			if end > size {
+				start = end - size
+			}
+		} else {
+			start = position
+			end = position + size
+		}
+	}
+	if start > total {
+		start = total
+	}
+	if end > total {
+		end = total
+	}
+	if page != nil {
+		page(total)
+	}
+
+	result := &sqldb.SqlCursor{}
+	if start > 0 {
+		result.Previous, err = sqldb.EncodeCursor(true, cursorColumns, []interface{}{start})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if end < total {
+		result.Next, err = sqldb.EncodeCursor(false, cursorColumns, []interface{}{end})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, r := range rows[start:end] {
+		err = s.scan(sqlEntity, r, entity)
+		if err != nil {
+			return nil, err
+		}
+		if row != nil {
+			row()
+		}
+	}
+
+	return result, nil
+}
+
+type iterator struct {
+	access    *access
+	sqlEntity sqldb.SqlEntity
+	entity    interface{}
+	rows      []row
+	index     int
+	err       error
+	closed    bool
+}
+
+func (s *iterator) Next() bool {
+	if s.err != nil || s.closed || s.index >= len(s.rows) {
+		return false
+	}
+
+	s.err = s.access.scan(s.sqlEntity, s.rows[s.index], s.entity)
+	s.index++
+
+	return s.err == nil
+}
+
+func (s *iterator) Err() error {
+	return s.err
+}
+
+func (s *iterator) Close() error {
+	s.closed = true
+	return nil
+}
+
+func (s *access) parse(entity interface{}) (sqldb.SqlEntity, error) {
+	sqlEntity := s.db.driver.NewEntity()
+	err := sqlEntity.Parse(entity)
+	if err != nil {
+		return nil, err
+	}
+	err = checkTags(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	return sqlEntity, nil
+}
+
+// tenantField returns the tenant column of the entity, nil if not scoped by tenant column,
+// or sqldb.ErrNoTenantColumn if the entity has no tenant column and the table is not shared as by the drivers
+func (s *access) tenantField(sqlEntity sqldb.SqlEntity) (sqldb.SqlField, error) {
+	column := s.db.config.TenantFilterColumn()
+	if column == "" || s.db.config.IsSharedTable(unquote(sqlEntity.Name())) {
+		return nil, nil
+	}
+	name := quote(column)
+	for i := 0; i < sqlEntity.FieldCount(); i++ {
+		if sqlEntity.Field(i).Name() == name {
+			return sqlEntity.Field(i), nil
+		}
+	}
+
+	return nil, sqldb.ErrNoTenantColumn
+}
+
+// where returns the conditions of the tenant and the filters, the filters without value are skipped
+// and the errors of the filter tags are returned as by the drivers
+func (s *access) where(sqlEntity sqldb.SqlEntity, sqlFilters []sqldb.SqlFilter) (*where, error) {
+	w := &where{scope: &group{}}
+	field, err := s.tenantField(sqlEntity)
+	if err != nil {
+		return nil, err
+	}
+	if field != nil {
+		w.scope.conditions = append(w.scope.conditions, condition{name: field.Name(), operator: "=", value: s.db.config.TenantID})
+		w.tenant = field.Name()
+	}
+
+	for _, sqlFilter := range sqlFilters {
+		if sqlFilter == nil || sqlFilter.Fields() == nil {
+			continue
+		}
+		filterEntity := s.db.driver.NewEntity()
+		err := filterEntity.ParseFilter(sqlFilter.Fields())
+		if err != nil {
+			return nil, err
+		}
+		err = checkTags(sqlFilter.Fields())
+		if err != nil {
+			return nil, err
+		}
+		g := &group{fieldOr: sqlFilter.FieldOr(), groupOr: sqlFilter.GroupOr()}
+		for i := 0; i < filterEntity.FieldCount(); i++ {
+			field := filterEntity.Field(i)
+			if field.ValueEmpty() {
+				continue
+			}
+			g.conditions = append(g.conditions, condition{name: field.Name(), operator: field.Filter(), value: field.Value()})
+		}
+		if len(g.conditions) > 0 {
+			w.filters = append(w.filters, g)
+		}
+	}
+
+	return w, nil
+}
+
+// rows returns the indexes of the rows matching the conditions
+func (s *access) rows(t *table, w *where) ([]int, error) {
+	indexes := make([]int, 0)
+	for i, r := range t.rows {
+		ok, err := w.match(r)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			indexes = append(indexes, i)
+		}
+	}
+
+	return indexes, nil
+}
+
+func (s *access) getColumns(sqlFilters []sqldb.SqlFilter) map[string]bool {
+	for _, sqlFilter := range sqlFilters {
+		if option, ok := sqlFilter.(*sqldb.ColumnsOption); ok {
+			columns := make(map[string]bool, len(option.Names))
+			for _, name := range option.Names {
+				columns[quote(name)] = true
+			}
+			return columns
+		}
+	}
+
+	return nil
+}
+
+func (s *access) insert(selective bool, entity interface{}) (uint64, error) {
+	if hook, ok := entity.(sqldb.BeforeInserter); ok {
+		err := hook.BeforeInsert()
+		if err != nil {
+			return 0, err
+		}
+	}
+	err := s.validate(entity)
+	if err != nil {
+		return 0, err
+	}
+	sqlEntity, err := s.parse(entity)
+	if err != nil {
+		return 0, err
+	}
+	field, err := s.tenantField(sqlEntity)
+	if err != nil {
+		return 0, err
+	}
+	if field != nil {
+		err = setField(field.Address(), s.db.config.TenantID)
+		if err != nil {
+			return 0, err
+		}
+		// parsed again for the value of the tenant field
+		sqlEntity, err = s.parse(entity)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	id := uint64(0)
+	err = s.write(sqlEntity.Name(), func(data *store) error {
+		t := data.table(sqlEntity.Name())
+		r := make(row)
+		for i := 0; i < sqlEntity.FieldCount(); i++ {
+			field := sqlEntity.Field(i)
+			// the auto increment fields are always generated, the drivers leave them out of the insert
+			if field.AutoIncrement() {
+				t.lastID++
+				id = t.lastID
+				r[field.Name()] = id
+				continue
+			}
+			if selective && field.ValueEmpty() {
+				continue
+			}
+			r[field.Name()] = storedValue(field.Value())
+		}
+		if s.duplicated(t, sqlEntity, r) {
+			return fmt.Errorf("sqldbtest: duplicate primary key of %s", sqlEntity.Name())
+		}
+		if t.columns == nil {
+			for i := 0; i < sqlEntity.FieldCount(); i++ {
+				field := sqlEntity.Field(i)
+				t.columns = append(t.columns, &sqldb.SqlColumn{
+					Name:          unquote(field.Name()),
+					Nullable:      reflect.TypeOf(field.Address()).Elem().Kind() == reflect.Ptr,
+					PrimaryKey:    field.PrimaryKey(),
+					AutoIncrement: field.AutoIncrement(),
+				})
+			}
+		}
+		t.rows = append(t.rows, r)
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if hook, ok := entity.(sqldb.AfterInserter); ok {
+		hook.AfterInsert(id)
+	}
+
+	return id, nil
+}
+
+func (s *access) duplicated(t *table, sqlEntity sqldb.SqlEntity, inserted row) bool {
+	keys := &group{}
+	for i := 0; i < sqlEntity.FieldCount(); i++ {
+		field := sqlEntity.Field(i)
+		if field.PrimaryKey() {
+			keys.conditions = append(keys.conditions, condition{name: field.Name(), operator: "=", value: inserted[field.Name()]})
+		}
+	}
+	if len(keys.conditions) < 1 {
+		return false
+	}
+	for _, r := range t.rows {
+		if ok, _ := keys.match(r); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// keys returns the conditions of the primary keys
+func (s *access) keys(sqlEntity sqldb.SqlEntity) *group {
+	keys := &group{}
+	for i := 0; i < sqlEntity.FieldCount(); i++ {
+		field := sqlEntity.Field(i)
+		if field.PrimaryKey() {
+			keys.conditions = append(keys.conditions, condition{name: field.Name(), operator: "=", value: field.Value()})
+		}
+	}
+
+	return keys
+}
+
+func (s *access) beforeUpdate(entity interface{}) (sqldb.SqlEntity, error) {
+	if hook, ok := entity.(sqldb.BeforeUpdater); ok {
+		err := hook.BeforeUpdate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := s.validate(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.parse(entity)
+}
+
+// set updates the row by the fields excluding the auto increment and tenant fields, and the primary keys if byKey,
+// the listed columns are updated even if empty as by the drivers
+func (s *access) set(r row, sqlEntity sqldb.SqlEntity, tenant string, selective, byKey bool, columns map[string]bool) {
+	for i := 0; i < sqlEntity.FieldCount(); i++ {
+		field := sqlEntity.Field(i)
+		if field.AutoIncrement() || (byKey && field.PrimaryKey()) {
+			continue
+		}
+		if tenant != "" && field.Name() == tenant {
+			continue
+		}
+		if columns != nil {
+			if columns[field.Name()] {
+				r[field.Name()] = storedValue(field.Value())
+			}
+			continue
+		}
+		if selective && field.ValueEmpty() {
+			continue
+		}
+		r[field.Name()] = storedValue(field.Value())
+	}
+}
+
+func (s *access) update(selective bool, entity interface{}, sqlFilters []sqldb.SqlFilter) (uint64, error) {
+	sqlEntity, err := s.beforeUpdate(entity)
+	if err != nil {
+		return 0, err
+	}
+
+	w, err := s.where(sqlEntity, sqlFilters)
+	if err != nil {
+		return 0, err
+	}
+
+	count := uint64(0)
+	err = s.write(sqlEntity.Name(), func(data *store) error {
+		t := data.table(sqlEntity.Name())
+		indexes, err := s.rows(t, w)
+		if err != nil {
+			return err
+		}
+		columns := s.getColumns(sqlFilters)
+		for _, index := range indexes {
+			s.set(t.rows[index], sqlEntity, w.tenant, selective, false, columns)
+		}
+		count = uint64(len(indexes))
+
+		return nil
+	})
+
+	return count, err
+}
+
+func (s *access) updateByPrimaryKey(selective bool, entity interface{}) (uint64, error) {
+	sqlEntity, err := s.beforeUpdate(entity)
+	if err != nil {
+		return 0, err
+	}
+	keys := s.keys(sqlEntity)
+	if len(keys.conditions) < 1 {
+		return 0, fmt.Errorf("sqldbtest: no primary key in %s", sqlEntity.Name())
+	}
+	w, err := s.where(sqlEntity, nil)
+	if err != nil {
+		return 0, err
+	}
+	w.scope.conditions = append(w.scope.conditions, keys.conditions...)
+
+	count := uint64(0)
+	err = s.write(sqlEntity.Name(), func(data *store) error {
+		t := data.table(sqlEntity.Name())
+		indexes, err := s.rows(t, w)
+		if err != nil {
+			return err
+		}
+		for _, index := range indexes {
+			s.set(t.rows[index], sqlEntity, w.tenant, selective, true, nil)
+		}
+		count = uint64(len(indexes))
+
+		return nil
+	})
+
+	return count, err
+}
+
+func (s *access) delete(entity interface{}, sqlFilters []sqldb.SqlFilter) (uint64, error) {
+	if hook, ok := entity.(sqldb.BeforeDeleter); ok {
+		err := hook.BeforeDelete()
+		if err != nil {
+			return 0, err
+		}
+	}
+	sqlEntity, err := s.parse(entity)
+	if err != nil {
+		return 0, err
+	}
+
+	w, err := s.where(sqlEntity, sqlFilters)
+	if err != nil {
+		return 0, err
+	}
+
+	count := uint64(0)
+	err = s.write(sqlEntity.Name(), func(data *store) error {
+		t := data.table(sqlEntity.Name())
+		rows := make([]row, 0, len(t.rows))
+		for _, r := range t.rows {
+			ok, err := w.match(r)
+			if err != nil {
+				return err
+			}
+			if ok {
+				count++
+				continue
+			}
+			rows = append(rows, r)
+		}
+		t.rows = rows
+
+		return nil
+	})
+
+	return count, err
+}
+
+// selectRows returns the copies of the rows matching the filters, sorted by the order
+func (s *access) selectRows(entity interface{}, order interface{}, sqlFilters []sqldb.SqlFilter) (sqldb.SqlEntity, []row, error) {
+	sqlEntity, err := s.parse(entity)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w, err := s.where(sqlEntity, sqlFilters)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows := make([]row, 0)
+	err = s.read(func(data *store) error {
+		t, ok := data.tables[sqlEntity.Name()]
+		if !ok {
+			return nil
+		}
+		indexes, err := s.rows(t, w)
+		if err != nil {
+			return err
+		}
+		for _, index := range indexes {
+			r := make(row, len(t.rows[index]))
+			for name, value := range t.rows[index] {
+				r[name] = copyBytes(value)
+			}
+			rows = append(rows, r)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	sortRows(rows, s.db.driver.NewEntity(), order)
+
+	return sqlEntity, rows, nil
+}
+
+func (s *access) selectNumber(entity interface{}, column string, sqlFilters []sqldb.SqlFilter) (float64, int, error) {
+	_, rows, err := s.selectRows(entity, nil, sqlFilters)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	sum, count := float64(0), 0
+	for _, r := range rows {
+		value, ok := toFloat(plainValue(r[quote(column)]))
+		if !ok {
+			continue
+		}
+		sum += value
+		count++
+	}
+
+	return sum, count, nil
+}
+
+// selectValue returns the greatest value if sign is 1, or the least if -1, nil if no row
+func (s *access) selectValue(entity interface{}, column string, sign int, sqlFilters []sqldb.SqlFilter) (interface{}, error) {
+	_, rows, err := s.selectRows(entity, nil, sqlFilters)
+	if err != nil {
+		return nil, err
+	}
+
+	var result interface{}
+	for _, r := range rows {
+		value := plainValue(r[quote(column)])
+		if value == nil {
+			continue
+		}
+		if result == nil || compareValues(value, result)*sign > 0 {
+			result = value
+		}
+	}
+
+	return result, nil
+}
+
+// scan sets the row to the fields of the entity then calls the AfterSelect hook
+func (s *access) scan(sqlEntity sqldb.SqlEntity, r row, entity interface{}) error {
+	// the nil embedded pointers are allocated for the scan
+	sqlEntity.ScanArgs()
+	for i := 0; i < sqlEntity.FieldCount(); i++ {
+		field := sqlEntity.Field(i)
+		err := setField(field.Address(), r[field.Name()])
+		if err != nil {
+			return err
+		}
+	}
+
+	if hook, ok := entity.(sqldb.AfterSelecter); ok {
+		return hook.AfterSelect()
+	}
+
+	return nil
+}
+
+func (s *access) validate(entity interface{}) error {
+	if validator, ok := entity.(sqldb.Validator); ok {
+		return validator.Validate()
+	}
+
+	return nil
+}
@@ -0,0 +1,534 @@
+package sqldbtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ktpswjz/database/sqldb"
+	"strings"
+	"testing"
+)
+
+// ConformanceTable is the table of ConformanceItem, which the factory of RunConformance creates empty, e.g. on mysql
+//
+//	CREATE TABLE `conformance_item` (
+//		`id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+//		`name` VARCHAR(64) NOT NULL,
+//		`age` INT NOT NULL,
+//		`email` VARCHAR(64) NULL
+//	)
+const ConformanceTable = "conformance_item"
+
+type ConformanceItem struct {
+	ID    uint64  `sql:"id" auto:"true" primary:"true"`
+	Name  string  `sql:"name"`
+	Age   int     `sql:"age"`
+	Email *string `sql:"email"`
+}
+
+func (s ConformanceItem) TableName() string {
+	return ConformanceTable
+}
+
+type conformanceFilter struct {
+	Name   string `sql:"name" filter:"like"`
+	Names  string `sql:"name" filter:"in"`
+	MinAge *int   `sql:"age" filter:">="`
+	MaxAge *int   `sql:"age" filter:"<"`
+}
+
+type conformanceOrder struct {
+	Age int    `sql:"age" order:"DESC"`
+	ID  uint64 `sql:"id"`
+}
+
+func (s conformanceOrder) TableName() string {
+	return ConformanceTable
+}
+
+type conformanceAge struct {
+	Age int `sql:"age"`
+}
+
+func (s conformanceAge) TableName() string {
+	return ConformanceTable
+}
+
+// ConformanceFactory returns the database under test, whose ConformanceTable exists and is empty
+type ConformanceFactory func(t *testing.T) sqldb.SqlDatabase
+
+// RunConformance runs the contract of sqldb.SqlDatabase against the databases of the factory,
+// so that the drivers and the fakes behave the same. Each subtest calls the factory for an empty table.
+func RunConformance(t *testing.T, factory ConformanceFactory) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, db sqldb.SqlDatabase)
+	}{
+		{"Insert", conformInsert},
+		{"Update", conformUpdate},
+		{"Delete", conformDelete},
+		{"Filter", conformFilter},
+		{"Order", conformOrder},
+		{"Page", conformPage},
+		{"Distinct", conformDistinct},
+		{"Aggregate", conformAggregate},
+		{"Tx", conformTx},
+		{"NoRows", conformNoRows},
+		{"Metadata", conformMetadata},
+	}
+	for _, test := range tests {
+		run := test.run
+		t.Run(test.name, func(t *testing.T) {
+			run(t, factory(t))
+		})
+	}
+}
+
+// the rows of the conformance, inserted in the order of the names
+var conformanceRows = []struct {
+	name string
+	age  int
+}{{"Alice", 30}, {"Bob", 20}, {"Carol", 40}, {"Dave", 20}, {"Anna", 25}}
+
+func conformSeed(t *testing.T, db sqldb.SqlDatabase) {
+	t.Helper()
+
+	for _, item := range conformanceRows {
+		_, err := db.Insert(&ConformanceItem{Name: item.name, Age: item.age})
+		if err != nil {
+			t.Fatal("seed: ", err)
+		}
+	}
+}
+
+func conformAge(value int) *int {
+	return &value
+}
+
+// conformNames returns the names of the rows selected by SelectList
+func conformNames(t *testing.T, db sqldb.SqlDatabase, order interface{}, filters ...sqldb.SqlFilter) string {
+	t.Helper()
+
+	dbEntity := &ConformanceItem{}
+	names := make([]string, 0)
+	err := db.SelectList(dbEntity, func() {
+		names = append(names, dbEntity.Name)
+	}, order, filters...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.Join(names, ",")
+}
+
+func conformSelect(t *testing.T, db sqldb.SqlDatabase, name string) *ConformanceItem {
+	t.Helper()
+
+	dbEntity := &ConformanceItem{}
+	err := db.SelectOne(dbEntity, db.NewFilter(&conformanceFilter{Name: name}, false, false))
+	if err != nil {
+		t.Fatal("select ", name, ": ", err)
+	}
+
+	return dbEntity
+}
+
+func conformInsert(t *testing.T, db sqldb.SqlDatabase) {
+	email := "alice@example.com"
+	first, err := db.Insert(&ConformanceItem{Name: "Alice", Age: 30, Email: &email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := db.InsertSelective(&ConformanceItem{Name: "Bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first < 1 || second <= first {
+		t.Error("auto increment ids should increase: ", first, second)
+	}
+
+	dbEntity := conformSelect(t, db, "Alice")
+	if dbEntity.ID != first || dbEntity.Age != 30 || dbEntity.Email == nil || *dbEntity.Email != email {
+		t.Errorf("inserted row error: %+v", dbEntity)
+	}
+	dbEntity = conformSelect(t, db, "Bob")
+	if dbEntity.ID != second || dbEntity.Email != nil {
+		t.Errorf("selective inserted row error: %+v", dbEntity)
+	}
+}
+
+func conformUpdate(t *testing.T, db sqldb.SqlDatabase) {
+	conformSeed(t, db)
+	email := "alice@example.com"
+	alice := conformSelect(t, db, "Alice")
+	count, err := db.UpdateByPrimaryKey(&ConformanceItem{ID: alice.ID, Name: "Alice", Age: 31, Email: &email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Error("update by primary key count error: ", count)
+	}
+	count, err = db.UpdateSelectiveByPrimaryKey(&ConformanceItem{ID: alice.ID, Age: 32})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Error("selective update by primary key count error: ", count)
+	}
+	alice = conformSelect(t, db, "Alice")
+	if alice.Age != 32 || alice.Email == nil || *alice.Email != email {
+		t.Errorf("selective update should keep the empty fields: %+v", alice)
+	}
+
+	count, err = db.UpdateSelective(&ConformanceItem{Age: 21}, db.NewFilter(&conformanceFilter{MaxAge: conformAge(21)}, false, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Error("selective update count error: ", count)
+	}
+	count, err = db.Update(&ConformanceItem{Name: "Carl", Age: 41}, db.NewFilter(&conformanceFilter{Name: "Carol"}, false, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Error("update count error: ", count)
+	}
+	if names := conformNames(t, db, &conformanceOrder{}); names != "Carl,Alice,Anna,Bob,Dave" {
+		t.Error("updated rows error: ", names)
+	}
+	if carl := conformSelect(t, db, "Carl"); carl.Email != nil {
+		t.Errorf("update should write the empty fields: %+v", carl)
+	}
+
+	count, err = db.UpdateByPrimaryKey(&ConformanceItem{ID: alice.ID + 1000, Name: "Nobody"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Error("update of missing row count error: ", count)
+	}
+}
+
+func conformDelete(t *testing.T, db sqldb.SqlDatabase) {
+	conformSeed(t, db)
+	count, err := db.Delete(&ConformanceItem{}, db.NewFilter(&conformanceFilter{Name: "A%"}, false, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Error("delete count error: ", count)
+	}
+	if names := conformNames(t, db, &conformanceOrder{}); names != "Carol,Bob,Dave" {
+		t.Error("rows after delete error: ", names)
+	}
+	count, err = db.Delete(&ConformanceItem{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Error("delete all count error: ", count)
+	}
+}
+
+func conformFilter(t *testing.T, db sqldb.SqlDatabase) {
+	conformSeed(t, db)
+	order := &conformanceOrder{}
+	cases := []struct {
+		name    string
+		filters []sqldb.SqlFilter
+		expect  string
+	}{
+		{"like", []sqldb.SqlFilter{db.NewFilter(&conformanceFilter{Name: "A%"}, false, false)}, "Alice,Anna"},
+		{"in", []sqldb.SqlFilter{db.NewFilter(&conformanceFilter{Names: "('Bob', 'Dave')"}, false, false)}, "Bob,Dave"},
+		{"range", []sqldb.SqlFilter{db.NewFilter(&conformanceFilter{MinAge: conformAge(25), MaxAge: conformAge(40)}, false, false)}, "Alice,Anna"},
+		{"field or", []sqldb.SqlFilter{db.NewFilter(&conformanceFilter{Name: "Bob", MinAge: conformAge(40)}, true, false)}, "Carol,Bob"},
+		{"group and", []sqldb.SqlFilter{
+			db.NewFilter(&conformanceFilter{Name: "A%"}, false, false),
+			db.NewFilter(&conformanceFilter{MinAge: conformAge(26)}, false, false),
+		}, "Alice"},
+		{"group or", []sqldb.SqlFilter{
+			db.NewFilter(&conformanceFilter{MinAge: conformAge(25)}, false, false),
+			db.NewFilter(&conformanceFilter{Name: "A%"}, false, false),
+			db.NewFilter(&conformanceFilter{Name: "Dave"}, false, true),
+		}, "Alice,Anna,Dave"},
+		{"empty", []sqldb.SqlFilter{db.NewFilter(&conformanceFilter{}, false, false)}, "Carol,Alice,Anna,Bob,Dave"},
+	}
+	for _, c := range cases {
+		if names := conformNames(t, db, order, c.filters...); names != c.expect {
+			t.Error("filter ", c.name, " error: expect=", c.expect, ", actual=", names)
+		}
+	}
+}
+
+func conformOrder(t *testing.T, db sqldb.SqlDatabase) {
+	conformSeed(t, db)
+	if names := conformNames(t, db, &conformanceOrder{}); names != "Carol,Alice,Anna,Bob,Dave" {
+		t.Error("order error: ", names)
+	}
+
+	ages := make([]string, 0)
+	dbEntity := &conformanceAge{}
+	err := db.SelectEach(dbEntity, func() error {
+		ages = append(ages, fmt.Sprint(dbEntity.Age))
+		if len(ages) == 3 {
+			return fmt.Errorf("three ages: %w", sqldb.ErrStop)
+		}
+		return nil
+	}, &conformanceAge{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(ages, ",") != "20,20,25" {
+		t.Error("ascending order error: ", ages)
+	}
+}
+
+func conformPage(t *testing.T, db sqldb.SqlDatabase) {
+	page := func(filter sqldb.SqlFilter, size, index uint64) string {
+		dbEntity := &ConformanceItem{}
+		pages := ""
+		names := make([]string, 0)
+		filters := make([]sqldb.SqlFilter, 0)
+		if filter != nil {
+			filters = append(filters, filter)
+		}
+		err := db.SelectPage(dbEntity, func(total, page, size, index uint64) {
+			pages = fmt.Sprint(total, page, size, index)
+		}, func() {
+			names = append(names, dbEntity.Name)
+		}, size, index, &conformanceOrder{}, filters...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pages + ":" + strings.Join(names, ",")
+	}
+
+	if result := page(nil, 2, 1); result != "0 0 2 0:" {
+		t.Error("page of empty table error: ", result)
+	}
+	conformSeed(t, db)
+	cases := []struct {
+		name   string
+		size   uint64
+		index  uint64
+		expect string
+	}{
+		{"first", 2, 1, "5 3 2 1:Carol,Alice"},
+		{"last", 2, 3, "5 3 2 3:Dave"},
+		{"index 0", 2, 0, "5 3 2 1:Carol,Alice"},
+		{"index beyond last", 2, 9, "5 3 2 3:Dave"},
+		{"size 0", 0, 2, "5 5 1 2:Alice"},
+	}
+	for _, c := range cases {
+		if result := page(nil, c.size, c.index); result != c.expect {
+			t.Error("page ", c.name, " error: expect=", c.expect, ", actual=", result)
+		}
+	}
+	filter := db.NewFilter(&conformanceFilter{Name: "Nobody"}, false, false)
+	if result := page(filter, 2, 1); result != "0 0 2 0:" {
+		t.Error("page without rows error: ", result)
+	}
+}
+
+func conformDistinct(t *testing.T, db sqldb.SqlDatabase) {
+	conformSeed(t, db)
+	dbEntity := &conformanceAge{}
+	ages := make([]string, 0)
+	err := db.SelectDistinct(dbEntity, func() {
+		ages = append(ages, fmt.Sprint(dbEntity.Age))
+	}, &conformanceAge{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(ages, ",") != "20,25,30,40" {
+		t.Error("distinct error: ", ages)
+	}
+}
+
+func conformAggregate(t *testing.T, db sqldb.SqlDatabase) {
+	conformSeed(t, db)
+	count, err := db.SelectCount(&ConformanceItem{}, db.NewFilter(&conformanceFilter{MaxAge: conformAge(25)}, false, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Error("count error: ", count)
+	}
+	exists, err := db.Exists(&ConformanceItem{}, db.NewFilter(&conformanceFilter{Name: "Nobody"}, false, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Error("exists error")
+	}
+
+	sum, err := db.SelectSum(&ConformanceItem{}, "age")
+	if err != nil {
+		t.Fatal(err)
+	}
+	avg, err := db.SelectAvg(&ConformanceItem{}, "age")
+	if err != nil {
+		t.Fatal(err)
+	}
+	max, err := db.SelectMax(&ConformanceItem{}, "age")
+	if err != nil {
+		t.Fatal(err)
+	}
+	min, err := db.SelectMin(&ConformanceItem{}, "age")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != 135 || avg != 27 || fmt.Sprint(max) != "40" || fmt.Sprint(min) != "20" {
+		t.Error("aggregate error: ", sum, avg, max, min)
+	}
+
+	groups, err := db.SelectGroupCount(&ConformanceItem{}, []string{"age"}, db.NewFilter(&conformanceFilter{MaxAge: conformAge(25)}, false, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || fmt.Sprint(groups[0].Values...) != "20" || groups[0].Count != 2 {
+		t.Error("group count error: ", groups)
+	}
+}
+
+func conformTx(t *testing.T, db sqldb.SqlDatabase) {
+	conformSeed(t, db)
+	sqlAccess, err := db.NewAccess(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sqlAccess.Commit() != sqldb.ErrNotInTransaction || sqlAccess.Rollback() != sqldb.ErrNotInTransaction {
+		t.Error("commit and rollback without transaction should be error")
+	}
+	sqlAccess.Close()
+
+	tx, err := db.NewAccess(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tx.InTransaction() {
+		t.Error("access should be in transaction")
+	}
+	_, err = tx.Insert(&ConformanceItem{Name: "Eve", Age: 50})
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+	count, err := tx.SelectCount(&ConformanceItem{})
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+	if count != 6 {
+		t.Error("row inserted in transaction should be visible to it: ", count)
+	}
+	rolledBack := false
+	tx.OnRollback(func() {
+		rolledBack = true
+	})
+	err = tx.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Close()
+	if count, _ = db.SelectCount(&ConformanceItem{}); count != 5 {
+		t.Error("rollback error: ", count)
+	}
+	if !rolledBack || tx.InTransaction() {
+		t.Error("transaction should be finished by rollback")
+	}
+
+	// the callbacks registered after commit are called immediately
+	tx, err = db.NewAccess(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	committed := false
+	tx.OnCommit(func() {
+		committed = true
+	})
+	tx.Close()
+	if !committed || tx.InTransaction() {
+		t.Error("transaction should be finished by commit")
+	}
+
+	err = db.RunInTx(context.Background(), sqldb.TxOptions{}, func(tx sqldb.SqlAccess) error {
+		_, err := tx.Insert(&ConformanceItem{Name: "Eve", Age: 50})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count, _ = db.SelectCount(&ConformanceItem{}); count != 6 {
+		t.Error("commit error: ", count)
+	}
+
+	errRollback := errors.New("rollback")
+	err = db.RunInTx(context.Background(), sqldb.TxOptions{}, func(tx sqldb.SqlAccess) error {
+		_, err := tx.Delete(&ConformanceItem{})
+		if err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Error("error of the transaction expected: ", err)
+	}
+	if count, _ = db.SelectCount(&ConformanceItem{}); count != 6 {
+		t.Error("rollback of failed transaction error: ", count)
+	}
+}
+
+func conformNoRows(t *testing.T, db sqldb.SqlDatabase) {
+	conformSeed(t, db)
+	err := db.SelectOne(&ConformanceItem{}, db.NewFilter(&conformanceFilter{Name: "Nobody"}, false, false))
+	if err == nil || !db.IsNoRows(err) {
+		t.Error("no rows error expected: ", err)
+	}
+	if db.IsNoRows(nil) || db.IsNoRows(errors.New("other")) {
+		t.Error("only no rows error should be reported")
+	}
+}
+
+func conformMetadata(t *testing.T, db sqldb.SqlDatabase) {
+	conformSeed(t, db)
+	tables, err := db.Tables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, table := range tables {
+		if strings.EqualFold(table.Name, ConformanceTable) {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("table ", ConformanceTable, " not found")
+	}
+	if _, err = db.Views(); err != nil {
+		t.Error(err)
+	}
+
+	columns, err := db.Columns(ConformanceTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, column := range columns {
+		names = append(names, strings.ToLower(column.Name))
+		if strings.EqualFold(column.Name, "id") && !(column.PrimaryKey && column.AutoIncrement) {
+			t.Errorf("column id should be the auto increment primary key: %+v", column)
+		}
+		if strings.EqualFold(column.Name, "email") && !column.Nullable {
+			t.Errorf("column email should be nullable: %+v", column)
+		}
+	}
+	if strings.Join(names, ",") != "id,name,age,email" {
+		t.Error("columns error: ", names)
+	}
+}
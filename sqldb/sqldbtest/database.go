@@ -0,0 +1,338 @@
+// Package sqldbtest provides an in-memory sqldb.SqlDatabase for the unit tests of the services built on sqldb.
+//
+// The entities are parsed by the tags of the mysql driver and stored by table name,
+// the filters, orders, pages, auto increment columns, hooks and transactions behave as on a server.
+// Raw statements of Exec, Query, QueryRow, Prepare and QueryInto are not supported,
+// neither are the audit, soft delete, version and codec columns nor the relations, whose entities fail with ErrNotSupported.
+package sqldbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/ktpswjz/database/sqldb"
+	"github.com/ktpswjz/database/sqldb/mysql"
+	"sort"
+	"sync"
+)
+
+var (
+	// returned by the raw statements, which can not be executed in memory,
+	// and wrapped by the errors of the entities tagged with the columns or relations not supported
+	ErrNotSupported = errors.New("sqldbtest: not supported")
+
+	// returned by Commit when a table changed by the transaction has been changed by others since the transaction started,
+	// the transaction is rolled back then
+	ErrConflict = errors.New("sqldbtest: transaction conflicts with concurrent changes")
+)
+
+type row map[string]interface{}
+
+type table struct {
+	// columns of the entity first inserted
+	columns []*sqldb.SqlColumn
+	rows    []row
+	lastID  uint64
+	// increased by each change committed, to detect the conflicts of the transactions
+	version uint64
+}
+
+func (s *table) clone() *table {
+	t := &table{
+		columns: s.columns,
+		rows:    make([]row, len(s.rows)),
+		lastID:  s.lastID,
+		version: s.version,
+	}
+	for i, r := range s.rows {
+		t.rows[i] = make(row, len(r))
+		for name, value := range r {
+			t.rows[i][name] = value
+		}
+	}
+
+	return t
+}
+
+// store is the tables of the database or the snapshot of a transaction
+type store struct {
+	tables map[string]*table
+}
+
+func newStore() *store {
+	return &store{tables: make(map[string]*table)}
+}
+
+func (s *store) clone() *store {
+	data := newStore()
+	for name, t := range s.tables {
+		data.tables[name] = t.clone()
+	}
+
+	return data
+}
+
+func (s *store) table(name string) *table {
+	t, ok := s.tables[name]
+	if !ok {
+		t = &table{}
+		s.tables[name] = t
+	}
+
+	return t
+}
+
+type memory struct {
+	sync.RWMutex
+
+	data *store
+}
+
+// Database is the in-memory database, the copies returned by WithContext and ForTenant share the tables
+type Database struct {
+	memory *memory
+	config *sqldb.Config
+	// parses the entities, filters and orders
+	driver sqldb.SqlDatabase
+	// rejects the raw statements
+	raw *sql.DB
+	ctx context.Context
+}
+
+// NewDatabase returns an empty database, options such as sqldb.WithNaming apply as to the drivers
+func NewDatabase(options ...sqldb.Option) *Database {
+	return &Database{
+		memory: &memory{data: newStore()},
+		config: sqldb.NewConfig(options...),
+		driver: mysql.NewDatabase(nil, options...),
+		raw:    sql.OpenDB(rawConnector{}),
+	}
+}
+
+// Reset removes all tables
+func (s *Database) Reset() {
+	s.memory.Lock()
+	defer s.memory.Unlock()
+
+	s.memory.data = newStore()
+}
+
+func (s *Database) newAccess(transactional bool) *access {
+	sqlAccess := &access{db: s}
+	if transactional {
+		s.memory.RLock()
+		sqlAccess.tx = s.memory.data.clone()
+		s.memory.RUnlock()
+		sqlAccess.dirty = make(map[string]bool)
+	}
+
+	return sqlAccess
+}
+
+func (s *Database) Test() (string, error) {
+	return "sqldbtest", nil
+}
+
+func (s *Database) Tables() ([]*sqldb.SqlTable, error) {
+	s.memory.RLock()
+	defer s.memory.RUnlock()
+
+	tables := make([]*sqldb.SqlTable, 0, len(s.memory.data.tables))
+	for name := range s.memory.data.tables {
+		tables = append(tables, &sqldb.SqlTable{Name: unquote(name)})
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		return tables[i].Name < tables[j].Name
+	})
+
+	return tables, nil
+}
+
+func (s *Database) Views() ([]*sqldb.SqlTable, error) {
+	return make([]*sqldb.SqlTable, 0), nil
+}
+
+// Columns returns the columns of the entity first inserted into the table
+func (s *Database) Columns(tableName string) ([]*sqldb.SqlColumn, error) {
+	s.memory.RLock()
+	defer s.memory.RUnlock()
+
+	columns := make([]*sqldb.SqlColumn, 0)
+	t, ok := s.memory.data.tables[quote(tableName)]
+	if !ok {
+		return columns, nil
+	}
+	for _, column := range t.columns {
+		copied := *column
+		columns = append(columns, &copied)
+	}
+
+	return columns, nil
+}
+
+func (s *Database) NewAccess(transactional bool) (sqldb.SqlAccess, error) {
+	return s.newAccess(transactional), nil
+}
+
+// NewAccessTx starts a transaction, whose isolation is always snapshot isolation whatever the options:
+// the transaction reads the snapshot taken at start and its commit fails by ErrConflict
+// if the tables it changed have been changed by others since, so that no change is lost
+func (s *Database) NewAccessTx(ctx context.Context, opts sqldb.TxOptions) (sqldb.SqlAccess, error) {
+	if ctx != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	return s.newAccess(true), nil
+}
+
+func (s *Database) RunInTx(ctx context.Context, opts sqldb.TxOptions, fn func(tx sqldb.SqlAccess) error) error {
+	begin := func() (sqldb.SqlAccess, error) {
+		return s.NewAccessTx(ctx, opts)
+	}
+
+	return sqldb.RunInTx(ctx, opts, begin, s.IsRetryable, fn)
+}
+
+func (s *Database) WithContext(ctx context.Context) sqldb.SqlDatabase {
+	return &Database{memory: s.memory, config: s.config, driver: s.driver.WithContext(ctx), raw: s.raw, ctx: ctx}
+}
+
+// ForTenant returns a copy of the database scoped to the tenant id by the tenant column or schema
+func (s *Database) ForTenant(id interface{}, options ...sqldb.Option) sqldb.SqlDatabase {
+	return &Database{
+		memory: s.memory,
+		config: s.config.With(sqldb.WithTenant(id)).With(options...),
+		driver: s.driver.ForTenant(id, options...),
+		raw:    s.raw,
+		ctx:    s.ctx,
+	}
+}
+
+func (s *Database) NewEntity() sqldb.SqlEntity {
+	return s.driver.NewEntity()
+}
+
+func (s *Database) NewBuilder() sqldb.SqlBuilder {
+	return s.driver.NewBuilder()
+}
+
+func (s *Database) NewFilter(entity interface{}, fieldOr, groupOr bool) sqldb.SqlFilter {
+	return s.driver.NewFilter(entity, fieldOr, groupOr)
+}
+
+func (s *Database) IsNoRows(err error) bool {
+	return errors.Is(err, sql.ErrNoRows)
+}
+
+// IsRetryable is always false since the transactions in memory do not deadlock
+func (s *Database) IsRetryable(err error) bool {
+	return false
+}
+
+func (s *Database) QueryInto(dest interface{}, query string, args ...interface{}) error {
+	return s.newAccess(false).QueryInto(dest, query, args...)
+}
+
+func (s *Database) Insert(entity interface{}) (uint64, error) {
+	return s.newAccess(false).Insert(entity)
+}
+
+func (s *Database) InsertSelective(entity interface{}) (uint64, error) {
+	return s.newAccess(false).InsertSelective(entity)
+}
+
+func (s *Database) Delete(entity interface{}, filters ...sqldb.SqlFilter) (uint64, error) {
+	return s.newAccess(false).Delete(entity, filters...)
+}
+
+func (s *Database) Update(entity interface{}, filters ...sqldb.SqlFilter) (uint64, error) {
+	return s.newAccess(false).Update(entity, filters...)
+}
+
+func (s *Database) UpdateSelective(entity interface{}, filters ...sqldb.SqlFilter) (uint64, error) {
+	return s.newAccess(false).UpdateSelective(entity, filters...)
+}
+
+func (s *Database) UpdateByPrimaryKey(entity interface{}) (uint64, error) {
+	return s.newAccess(false).UpdateByPrimaryKey(entity)
+}
+
+func (s *Database) UpdateSelectiveByPrimaryKey(entity interface{}) (uint64, error) {
+	return s.newAccess(false).UpdateSelectiveByPrimaryKey(entity)
+}
+
+func (s *Database) SelectCount(entity interface{}, filters ...sqldb.SqlFilter) (uint64, error) {
+	return s.newAccess(false).SelectCount(entity, filters...)
+}
+
+func (s *Database) SelectSum(entity interface{}, column string, filters ...sqldb.SqlFilter) (float64, error) {
+	return s.newAccess(false).SelectSum(entity, column, filters...)
+}
+
+func (s *Database) SelectAvg(entity interface{}, column string, filters ...sqldb.SqlFilter) (float64, error) {
+	return s.newAccess(false).SelectAvg(entity, column, filters...)
+}
+
+func (s *Database) SelectMax(entity interface{}, column string, filters ...sqldb.SqlFilter) (interface{}, error) {
+	return s.newAccess(false).SelectMax(entity, column, filters...)
+}
+
+func (s *Database) SelectMin(entity interface{}, column string, filters ...sqldb.SqlFilter) (interface{}, error) {
+	return s.newAccess(false).SelectMin(entity, column, filters...)
+}
+
+func (s *Database) SelectGroupCount(entity interface{}, groupColumns []string, filters ...sqldb.SqlFilter) ([]*sqldb.SqlGroupCount, error) {
+	return s.newAccess(false).SelectGroupCount(entity, groupColumns, filters...)
+}
+
+func (s *Database) Exists(entity interface{}, filters ...sqldb.SqlFilter) (bool, error) {
+	return s.newAccess(false).Exists(entity, filters...)
+}
+
+func (s *Database) SelectOne(entity interface{}, filters ...sqldb.SqlFilter) error {
+	return s.newAccess(false).SelectOne(entity, filters...)
+}
+
+func (s *Database) SelectDistinct(entity interface{}, row func(), order interface{}, filters ...sqldb.SqlFilter) error {
+	return s.newAccess(false).SelectDistinct(entity, row, order, filters...)
+}
+
+func (s *Database) SelectList(entity interface{}, row func(), order interface{}, filters ...sqldb.SqlFilter) error {
+	return s.newAccess(false).SelectList(entity, row, order, filters...)
+}
+
+func (s *Database) SelectEach(entity interface{}, row func() error, order interface{}, filters ...sqldb.SqlFilter) error {
+	return s.newAccess(false).SelectEach(entity, row, order, filters...)
+}
+
+func (s *Database) Iterate(entity interface{}, order interface{}, filters ...sqldb.SqlFilter) (sqldb.SqlIterator, error) {
+	return s.newAccess(false).Iterate(entity, order, filters...)
+}
+
+func (s *Database) SelectPage(entity interface{}, page func(total, page, size, index uint64), row func(), size, index uint64, order interface{}, filters ...sqldb.SqlFilter) error {
+	return s.newAccess(false).SelectPage(entity, page, row, size, index, order, filters...)
+}
+
+func (s *Database) SelectAfter(entity interface{}, page func(total uint64), row func(), cursor string, size uint64, order interface{}, filters ...sqldb.SqlFilter) (*sqldb.SqlCursor, error) {
+	return s.newAccess(false).SelectAfter(entity, page, row, cursor, size, order, filters...)
+}
+
+// rawConnector fails each connection, so that the raw statements report ErrNotSupported
+type rawConnector struct {
+}
+
+func (s rawConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return nil, ErrNotSupported
+}
+
+func (s rawConnector) Driver() driver.Driver {
+	return rawDriver{}
+}
+
+type rawDriver struct {
+}
+
+func (s rawDriver) Open(name string) (driver.Conn, error) {
+	return nil, ErrNotSupported
+}
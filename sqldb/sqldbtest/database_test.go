@@ -0,0 +1,485 @@
+package sqldbtest
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ktpswjz/database/sqldb"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testUser struct {
+	ID    uint64  `sql:"id" auto:"true" primary:"true"`
+	Name  string  `sql:"name"`
+	Age   int     `sql:"age"`
+	Email *string `sql:"email"`
+}
+
+func (s testUser) TableName() string {
+	return "tabUser"
+}
+
+type testUserFilter struct {
+	Name   string `sql:"name" filter:"like"`
+	MinAge *int   `sql:"age" filter:">="`
+	MaxAge *int   `sql:"age" filter:"<"`
+}
+
+type testUserNameFilter struct {
+	Name string `sql:"name"`
+}
+
+type testTypoFilter struct {
+	Name string `db:"name,fitler=like"`
+}
+
+type testUserOrder struct {
+	Age int    `sql:"age" order:"DESC"`
+	ID  uint64 `sql:"id"`
+}
+
+func (s testUserOrder) TableName() string {
+	return "tabUser"
+}
+
+type testTenantUser struct {
+	ID       uint64 `sql:"id" auto:"true" primary:"true"`
+	TenantID uint64 `sql:"tenant_id"`
+	Name     string `sql:"name"`
+}
+
+func (s testTenantUser) TableName() string {
+	return "tabTenantUser"
+}
+
+// testTenantName is a partial entity of tabTenantUser without the tenant column
+type testTenantName struct {
+	Name string `sql:"name"`
+}
+
+func (s testTenantName) TableName() string {
+	return "tabTenantUser"
+}
+
+func age(value int) *int {
+	return &value
+}
+
+func newTestDatabase(t *testing.T) *Database {
+	db := NewDatabase()
+	for _, item := range []struct {
+		name string
+		age  int
+	}{{"Alice", 30}, {"Bob", 20}, {"Carol", 40}, {"Dave", 20}, {"Anna", 25}} {
+		_, err := db.Insert(&testUser{Name: item.name, Age: item.age})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return db
+}
+
+func selectNames(t *testing.T, db sqldb.SqlDatabase, order interface{}, filters ...sqldb.SqlFilter) string {
+	dbEntity := &testUser{}
+	names := make([]string, 0)
+	err := db.SelectList(dbEntity, func() {
+		names = append(names, dbEntity.Name)
+	}, order, filters...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.Join(names, ",")
+}
+
+func TestMemory_Insert(t *testing.T) {
+	db := newTestDatabase(t)
+	dbEntity := &testUser{Name: "Eve"}
+	id, err := db.Insert(dbEntity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 6 {
+		t.Error("auto increment error: ", id)
+	}
+	// the value of the auto increment field is ignored as by the drivers
+	if id, _ = db.Insert(&testUser{ID: 3}); id != 7 {
+		t.Error("auto increment field should be generated: ", id)
+	}
+
+	email := "eve@example.com"
+	_, err = db.InsertSelective(&testUser{Name: "Frank", Email: &email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbEntity = &testUser{}
+	err = db.SelectOne(dbEntity, db.NewFilter(&testUserNameFilter{Name: "Frank"}, false, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dbEntity.Email == nil || *dbEntity.Email != email {
+		t.Error("pointer field error: ", dbEntity.Email)
+	}
+
+	err = db.SelectOne(&testUser{}, db.NewFilter(&testUserNameFilter{Name: "Nobody"}, false, false))
+	if !db.IsNoRows(err) {
+		t.Error("no rows expected: ", err)
+	}
+	tables, _ := db.Tables()
+	if len(tables) != 1 || tables[0].Name != "tabUser" {
+		t.Error("tables error: ", tables)
+	}
+}
+
+func TestMemory_Filter(t *testing.T) {
+	db := newTestDatabase(t)
+	order := &testUserOrder{}
+	cases := []struct {
+		filters []sqldb.SqlFilter
+		expect  string
+	}{
+		{nil, "Carol,Alice,Anna,Bob,Dave"},
+		{[]sqldb.SqlFilter{db.NewFilter(&testUserFilter{Name: "a%"}, false, false)}, "Alice,Anna"},
+		{[]sqldb.SqlFilter{db.NewFilter(&testUserFilter{MinAge: age(25), MaxAge: age(40)}, false, false)}, "Alice,Anna"},
+		{[]sqldb.SqlFilter{db.NewFilter(&testUserFilter{Name: "Bob", MinAge: age(40)}, true, false)}, "Carol,Bob"},
+		{[]sqldb.SqlFilter{
+			db.NewFilter(&testUserFilter{MinAge: age(25)}, false, false),
+			db.NewFilter(&testUserFilter{Name: "A%"}, false, false),
+			db.NewFilter(&testUserNameFilter{Name: "Dave"}, false, true),
+		}, "Alice,Anna,Dave"},
+	}
+	for i, c := range cases {
+		if names := selectNames(t, db, order, c.filters...); names != c.expect {
+			t.Error("filter ", i, " error: expect=", c.expect, ", actual=", names)
+		}
+	}
+
+	count, err := db.SelectCount(&testUser{}, db.NewFilter(&testUserFilter{MaxAge: age(25)}, false, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Error("count error: ", count)
+	}
+	avg, _ := db.SelectAvg(&testUser{}, "age")
+	max, _ := db.SelectMax(&testUser{}, "age")
+	if avg != 27 || fmt.Sprint(max) != "40" {
+		t.Error("aggregate error: ", avg, max)
+	}
+	groups, _ := db.SelectGroupCount(&testUser{}, []string{"age"})
+	if len(groups) != 4 || fmt.Sprint(groups[0].Values[0]) != "20" || groups[0].Count != 2 {
+		t.Error("group count error: ", groups)
+	}
+}
+
+func TestMemory_Page(t *testing.T) {
+	db := newTestDatabase(t)
+	dbEntity := &testUser{}
+	names := make([]string, 0)
+	pages := ""
+	err := db.SelectPage(dbEntity, func(total, page, size, index uint64) {
+		pages = fmt.Sprint(total, page, size, index)
+	}, func() {
+		names = append(names, dbEntity.Name)
+	}, 2, 3, &testUserOrder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages != "5 3 2 3" || strings.Join(names, ",") != "Dave" {
+		t.Error("page error: ", pages, names)
+	}
+
+	names = names[:0]
+	cursor, err := db.SelectAfter(dbEntity, nil, func() {
+		names = append(names, dbEntity.Name)
+	}, "", 2, &testUserOrder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor, err = db.SelectAfter(dbEntity, nil, func() {
+		names = append(names, dbEntity.Name)
+	}, cursor.Next, 2, &testUserOrder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, ",") != "Carol,Alice,Anna,Bob" || cursor.Previous == "" || cursor.Next == "" {
+		t.Error("cursor error: ", names, cursor)
+	}
+}
+
+func TestMemory_Update(t *testing.T) {
+	db := newTestDatabase(t)
+	count, err := db.UpdateSelective(&testUser{Age: 21}, db.NewFilter(&testUserFilter{MaxAge: age(21)}, false, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Error("update count error: ", count)
+	}
+	count, err = db.UpdateByPrimaryKey(&testUser{ID: 1, Name: "Alex"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Error("update by primary key count error: ", count)
+	}
+	if names := selectNames(t, db, &testUserOrder{}, db.NewFilter(&testUserFilter{MaxAge: age(22)}, false, false)); names != "Bob,Dave,Alex" {
+		t.Error("update error: ", names)
+	}
+
+	count, err = db.Delete(&testUser{}, db.NewFilter(&testUserFilter{Name: "A%"}, false, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Error("delete count error: ", count)
+	}
+
+	// the filter of a typo is an error rather than no condition
+	_, err = db.Delete(&testUser{}, db.NewFilter(&testTypoFilter{Name: "Bob"}, false, false))
+	if err == nil {
+		t.Error("delete by invalid filter should be error")
+	}
+	if count, _ = db.SelectCount(&testUser{}); count != 3 {
+		t.Error("rows should not be deleted by invalid filter: ", count)
+	}
+}
+
+func TestMemory_Tx(t *testing.T) {
+	db := newTestDatabase(t)
+	tx, err := db.NewAccess(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	committed := false
+	tx.OnCommit(func() {
+		committed = true
+	})
+	if _, err = tx.Insert(&testUser{Name: "Eve"}); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := db.SelectCount(&testUser{}); count != 5 {
+		t.Error("uncommitted row should be invisible: ", count)
+	}
+	if err = tx.Savepoint("before_delete"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.Delete(&testUser{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.RollbackTo("before_delete"); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := db.SelectCount(&testUser{}); count != 6 || !committed {
+		t.Error("commit error: ", count, committed)
+	}
+
+	err = db.RunInTx(nil, sqldb.TxOptions{}, func(tx sqldb.SqlAccess) error {
+		_, err := tx.Delete(&testUser{})
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("rollback")
+	})
+	if err == nil {
+		t.Error("error of the transaction expected")
+	}
+	if count, _ := db.SelectCount(&testUser{}); count != 6 {
+		t.Error("rollback error: ", count)
+	}
+	if _, err = tx.Insert(&testUser{}); err == nil {
+		t.Error("insert after commit should be error")
+	}
+
+	// the changes made by others since the snapshot are not overwritten
+	tx, err = db.NewAccess(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rolledBack := false
+	tx.OnRollback(func() {
+		rolledBack = true
+	})
+	if _, err = tx.Insert(&testUser{Name: "Frank"}); err != nil {
+		t.Fatal(err)
+	}
+	id, err := db.Insert(&testUser{Name: "Grace"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Commit(); err != ErrConflict || !rolledBack {
+		t.Error("commit of conflicting transaction should be error: ", err, rolledBack)
+	}
+	if names := selectNames(t, db, &testUserOrder{}, db.NewFilter(&testUserFilter{MinAge: age(0)}, false, false)); !strings.Contains(names, "Grace") || strings.Contains(names, "Frank") {
+		t.Error("change of others should be kept: ", names)
+	}
+	if next, _ := db.Insert(&testUser{Name: "Heidi"}); next != id+1 {
+		t.Error("auto increment of others should be kept: ", id, next)
+	}
+
+	// the transactions changing other tables do not conflict
+	tx, _ = db.NewAccess(true)
+	if _, err = tx.Insert(&testTenantUser{Name: "Ivan"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Insert(&testUser{Name: "Judy"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Error("commit of other table should not conflict: ", err)
+	}
+}
+
+type testFile struct {
+	ID   uint64 `sql:"id" auto:"true" primary:"true"`
+	Data []byte `sql:"data"`
+}
+
+func (s testFile) TableName() string {
+	return "tabFile"
+}
+
+func TestMemory_Bytes(t *testing.T) {
+	db := NewDatabase()
+	dbEntity := &testFile{Data: []byte("abc")}
+	if _, err := db.Insert(dbEntity); err != nil {
+		t.Fatal(err)
+	}
+	// the bytes are copied when written and read, as sent to and received from a server
+	dbEntity.Data[0] = 'x'
+	selected := &testFile{}
+	if err := db.SelectOne(selected); err != nil {
+		t.Fatal(err)
+	}
+	if string(selected.Data) != "abc" {
+		t.Error("stored bytes should not be changed by the inserted entity: ", string(selected.Data))
+	}
+	selected.Data[1] = 'x'
+	again := &testFile{}
+	if err := db.SelectOne(again); err != nil {
+		t.Fatal(err)
+	}
+	if string(again.Data) != "abc" {
+		t.Error("stored bytes should not be changed by the selected entity: ", string(again.Data))
+	}
+}
+
+type testSoftUser struct {
+	ID      uint64 `sql:"id" auto:"true" primary:"true"`
+	Deleted bool   `sql:"deleted" softdelete:"true"`
+}
+
+func (s testSoftUser) TableName() string {
+	return "tabSoftUser"
+}
+
+type AuditBase struct {
+	UpdatedAt time.Time `db:"updatedAt,updated"`
+}
+
+type testAuditUser struct {
+	AuditBase
+	ID uint64 `sql:"id" auto:"true" primary:"true"`
+}
+
+func (s testAuditUser) TableName() string {
+	return "tabAuditUser"
+}
+
+type testProfile struct {
+	ID    uint64            `sql:"id" auto:"true" primary:"true"`
+	Extra map[string]string `sql:"extra" codec:"json"`
+}
+
+func (s testProfile) TableName() string {
+	return "tabProfile"
+}
+
+func TestMemory_Unsupported(t *testing.T) {
+	db := NewDatabase()
+	for _, entity := range []interface{}{&testSoftUser{}, &testAuditUser{}, &testProfile{}} {
+		if _, err := db.Insert(entity); !errors.Is(err, ErrNotSupported) {
+			t.Errorf("insert of %T should not be supported: %v", entity, err)
+		}
+		if err := db.SelectList(entity, func() {}, nil); !errors.Is(err, ErrNotSupported) {
+			t.Errorf("select of %T should not be supported: %v", entity, err)
+		}
+	}
+	if _, err := db.Insert(&testFile{}); err != nil {
+		t.Error("entity of supported tags should be inserted: ", err)
+	}
+}
+
+func TestMemory_Tenant(t *testing.T) {
+	db := NewDatabase()
+	first, second := db.ForTenant(uint64(1)), db.ForTenant(uint64(2))
+	if _, err := first.Insert(&testTenantUser{Name: "Alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.Insert(&testTenantUser{Name: "Bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := first.SelectCount(&testTenantUser{}); count != 1 {
+		t.Error("tenant count error: ", count)
+	}
+	if count, _ := db.SelectCount(&testTenantUser{}); count != 2 {
+		t.Error("unscoped count error: ", count)
+	}
+	if count, _ := second.UpdateByPrimaryKey(&testTenantUser{ID: 1, Name: "Eve"}); count != 0 {
+		t.Error("row of other tenant should not be updated: ", count)
+	}
+	sqlAccess, _ := first.NewAccess(false)
+	if _, err := sqlAccess.Exec("DELETE FROM tabTenantUser"); err != ErrNotSupported {
+		t.Error("raw sql should not be supported: ", err)
+	}
+
+	// the partial entities would read and write the rows of all tenants
+	filter := first.NewFilter(&testUserNameFilter{Name: "Bob"}, false, false)
+	if err := first.SelectDistinct(&testTenantName{}, func() {}, nil); err != sqldb.ErrNoTenantColumn {
+		t.Error("select of partial entity should be error: ", err)
+	}
+	if _, err := first.UpdateSelective(&testTenantName{Name: "Eve"}, filter); err != sqldb.ErrNoTenantColumn {
+		t.Error("update of partial entity should be error: ", err)
+	}
+	if _, err := first.Delete(&testTenantName{}, filter); err != sqldb.ErrNoTenantColumn {
+		t.Error("delete of partial entity should be error: ", err)
+	}
+	if _, err := first.Insert(&testTenantName{Name: "Eve"}); err != sqldb.ErrNoTenantColumn {
+		t.Error("insert of partial entity should be error: ", err)
+	}
+	if count, _ := db.SelectCount(&testTenantUser{}, filter); count != 1 {
+		t.Error("rows of other tenants should not be changed: ", count)
+	}
+
+	// the entities of the projection keep the tenant column
+	names := make([]string, 0)
+	dbEntity := &testTenantUser{}
+	err := first.SelectDistinct(dbEntity, func() {
+		names = append(names, dbEntity.Name)
+	}, nil, sqldb.Columns("name"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, ",") != "Alice" {
+		t.Error("projection of tenant error: ", names)
+	}
+
+	shared := db.ForTenant(uint64(1), sqldb.WithSharedTables("tabUser"))
+	if _, err = shared.Insert(&testUser{Name: "Shared"}); err != nil {
+		t.Error("shared table should not be scoped: ", err)
+	}
+	if count, _ := db.ForTenant(uint64(2), sqldb.WithSharedTables("tabUser")).SelectCount(&testUser{}); count != 1 {
+		t.Error("shared rows should be visible to all tenants: ", count)
+	}
+}
+
+func TestConformance_Memory(t *testing.T) {
+	RunConformance(t, func(t *testing.T) sqldb.SqlDatabase {
+		return NewDatabase()
+	})
+}
@@ -0,0 +1,343 @@
+package sqldbtest
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"fmt"
+	"github.com/ktpswjz/database/sqldb"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func quote(name string) string {
+	return fmt.Sprintf("`%s`", strings.Trim(strings.TrimSpace(name), "`"))
+}
+
+func unquote(name string) string {
+	return strings.Replace(name, "`", "", -1)
+}
+
+type condition struct {
+	name     string
+	operator string
+	value    interface{}
+}
+
+// group is the conditions of a filter, joined by OR if fieldOr
+type group struct {
+	conditions []condition
+	fieldOr    bool
+	groupOr    bool
+}
+
+func (s *group) match(r row) (bool, error) {
+	for _, c := range s.conditions {
+		ok, err := matchCondition(r[c.name], c.operator, c.value)
+		if err != nil {
+			return false, err
+		}
+		if ok && s.fieldOr {
+			return true, nil
+		}
+		if !ok && !s.fieldOr {
+			return false, nil
+		}
+	}
+
+	return !s.fieldOr, nil
+}
+
+// where is the scope of the tenant and primary keys, joined by AND with the groups of the filters
+type where struct {
+	scope   *group
+	filters []*group
+	// the tenant column of the scope, which is never updated, empty if not scoped
+	tenant string
+}
+
+func (s *where) match(r row) (bool, error) {
+	ok, err := s.scope.match(r)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	return matchGroups(r, s.filters)
+}
+
+// matchGroups joins the groups by AND or OR, AND binds tighter than OR as in sql
+func matchGroups(r row, groups []*group) (bool, error) {
+	if len(groups) < 1 {
+		return true, nil
+	}
+
+	result := false
+	term := true
+	for i, g := range groups {
+		ok, err := g.match(r)
+		if err != nil {
+			return false, err
+		}
+		if i == 0 {
+			term = ok
+		} else if g.groupOr {
+			result = result || term
+			term = ok
+		} else {
+			term = term && ok
+		}
+	}
+
+	return result || term, nil
+}
+
+// matchCondition compares the column value with the filter value, NULL matches no condition as in sql
+func matchCondition(value interface{}, operator string, arg interface{}) (bool, error) {
+	value = plainValue(value)
+	if value == nil {
+		return false, nil
+	}
+
+	switch strings.ToLower(strings.Join(strings.Fields(operator), " ")) {
+	case "=":
+		return compareValues(value, arg) == 0, nil
+	case "<>", "!=":
+		return compareValues(value, arg) != 0, nil
+	case ">":
+		return compareValues(value, arg) > 0, nil
+	case ">=":
+		return compareValues(value, arg) >= 0, nil
+	case "<":
+		return compareValues(value, arg) < 0, nil
+	case "<=":
+		return compareValues(value, arg) <= 0, nil
+	case "like":
+		return matchLike(value, arg)
+	case "not like":
+		ok, err := matchLike(value, arg)
+		return !ok, err
+	case "in":
+		return matchIn(value, arg), nil
+	case "not in":
+		return !matchIn(value, arg), nil
+	}
+
+	return false, fmt.Errorf("sqldbtest: filter '%s' not supported", operator)
+}
+
+// matchLike matches the pattern of % and _ ignoring case, like the default collation of mysql
+func matchLike(value, pattern interface{}) (bool, error) {
+	sb := &strings.Builder{}
+	sb.WriteString("(?is)^")
+	for _, r := range fmt.Sprint(plainValue(pattern)) {
+		switch r {
+		case '%':
+			sb.WriteString(".*")
+		case '_':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return false, err
+	}
+
+	return re.MatchString(fmt.Sprint(value)), nil
+}
+
+// matchIn accepts a slice or the text of the list, e.g. "(1, 2)" or "('a', 'b')"
+func matchIn(value, list interface{}) bool {
+	items := make([]interface{}, 0)
+	v := reflect.ValueOf(list)
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 {
+		for i := 0; i < v.Len(); i++ {
+			items = append(items, v.Index(i).Interface())
+		}
+	} else {
+		text := strings.TrimSpace(fmt.Sprint(plainValue(list)))
+		text = strings.TrimSuffix(strings.TrimPrefix(text, "("), ")")
+		for _, item := range strings.Split(text, ",") {
+			items = append(items, strings.Trim(strings.TrimSpace(item), "'\""))
+		}
+	}
+
+	for _, item := range items {
+		if compareValues(value, item) == 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+type sortColumn struct {
+	name string
+	desc bool
+}
+
+// sortRows sorts the rows by the columns of the order entity, the order is ignored if invalid as by the drivers
+func sortRows(rows []row, orderEntity sqldb.SqlEntity, order interface{}) {
+	if order == nil || orderEntity.Parse(order) != nil {
+		return
+	}
+	columns := make([]sortColumn, orderEntity.FieldCount())
+	for i := range columns {
+		field := orderEntity.Field(i)
+		columns[i] = sortColumn{name: field.Name(), desc: strings.EqualFold(field.Order(), "DESC")}
+	}
+
+	sort.SliceStable(rows, func(a, b int) bool {
+		for _, column := range columns {
+			result := compareValues(rows[a][column.name], rows[b][column.name])
+			if result == 0 {
+				continue
+			}
+			if column.desc {
+				return result > 0
+			}
+			return result < 0
+		}
+		return false
+	})
+}
+
+// plainValue dereferences the pointers and driver.Valuer values, nil for NULL
+func plainValue(value interface{}) interface{} {
+	if valuer, ok := value.(driver.Valuer); ok {
+		v, err := valuer.Value()
+		if err != nil {
+			return nil
+		}
+		value = v
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		return plainValue(v.Elem().Interface())
+	}
+
+	return value
+}
+
+// storedValue is the plain value kept by the row, the bytes are copied since the field may be changed after written
+func storedValue(value interface{}) interface{} {
+	return copyBytes(plainValue(value))
+}
+
+// copyBytes returns a copy of the []byte value, so that the stored rows and the scanned fields never share the bytes
+func copyBytes(value interface{}) interface{} {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice || v.Type().Elem().Kind() != reflect.Uint8 || v.IsNil() {
+		return value
+	}
+	copied := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+	reflect.Copy(copied, v)
+
+	return copied.Interface()
+}
+
+// compareValues compares the values of a column, NULL is less than any value,
+// numbers are compared with the numeric text of the filters
+func compareValues(a, b interface{}) int {
+	a, b = plainValue(a), plainValue(b)
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+
+	switch x := a.(type) {
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			switch {
+			case x.Before(y):
+				return -1
+			case x.After(y):
+				return 1
+			}
+			return 0
+		}
+	case []byte:
+		if y, ok := b.([]byte); ok {
+			return bytes.Compare(x, y)
+		}
+	}
+
+	fa, aNumber := toFloat(a)
+	fb, bNumber := toFloat(b)
+	_, aText := a.(string)
+	_, bText := b.(string)
+	if aNumber && bNumber && !(aText && bText) {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// toFloat converts the numbers, booleans and numeric texts
+func toFloat(value interface{}) (float64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.Bool:
+		if v.Bool() {
+			return 1, true
+		}
+		return 0, true
+	case reflect.String:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v.String()), 64)
+		return f, err == nil
+	}
+
+	return 0, false
+}
+
+// setField sets the stored value to the field address, NULL sets the zero value
+func setField(address interface{}, value interface{}) error {
+	target := reflect.ValueOf(address).Elem()
+	if value == nil {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+
+	v := reflect.ValueOf(value)
+	switch {
+	case v.Type().AssignableTo(target.Type()):
+		target.Set(v)
+	case v.Type().ConvertibleTo(target.Type()):
+		target.Set(v.Convert(target.Type()))
+	case target.Kind() == reflect.Ptr && v.Type().ConvertibleTo(target.Type().Elem()):
+		elem := reflect.New(target.Type().Elem())
+		elem.Elem().Set(v.Convert(target.Type().Elem()))
+		target.Set(elem)
+	default:
+		return fmt.Errorf("sqldbtest: can not set %T to %s", value, target.Type())
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package sqldbtest
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// the tags of the mysql driver not supported in memory, as legacy tags or options of the combined tag `db`
+var unsupportedTags = []string{"softdelete", "version", "created", "updated", "createdBy", "updatedBy", "codec"}
+
+// checkTags returns ErrNotSupported if a field of the entity is tagged with the columns or relations
+// not supported in memory, the embedded structs and the struct fields tagged with `prefix` are checked as by the driver
+func checkTags(entity interface{}) error {
+	t := reflect.TypeOf(entity)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	tag := unsupportedTag(t, nil)
+	if tag == "" {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s of %s", ErrNotSupported, tag, t.Name())
+}
+
+// unsupportedTag returns the first tag not supported, as "field `tag`", empty if none
+func unsupportedTag(t reflect.Type, visiting []reflect.Type) string {
+	for _, visited := range visiting {
+		if visited == t {
+			return ""
+		}
+	}
+	visiting = append(visiting, t)
+
+	for i := 0; i < t.NumField(); i++ {
+		typeField := t.Field(i)
+		if typeField.PkgPath != "" {
+			continue
+		}
+		if _, ok := typeField.Tag.Lookup("rel"); ok {
+			return fmt.Sprintf("field %s `rel`", typeField.Name)
+		}
+
+		fieldType := typeField.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		_, prefixed := typeField.Tag.Lookup("prefix")
+		if (typeField.Anonymous || prefixed) && fieldType.Kind() == reflect.Struct {
+			if tag := unsupportedTag(fieldType, visiting); tag != "" {
+				return tag
+			}
+			continue
+		}
+
+		options := make(map[string]bool)
+		if combined, ok := typeField.Tag.Lookup("db"); ok {
+			for _, part := range strings.Split(combined, ",")[1:] {
+				options[strings.TrimSpace(strings.SplitN(part, "=", 2)[0])] = true
+			}
+		}
+		for _, name := range unsupportedTags {
+			value := typeField.Tag.Get(name)
+			if options[name] || (value != "" && !strings.EqualFold(value, "false")) {
+				return fmt.Sprintf("field %s `%s`", typeField.Name, name)
+			}
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,144 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+const (
+	DefaultTxMaxAttempts = 3
+	DefaultTxMinBackoff  = 20 * time.Millisecond
+	DefaultTxMaxBackoff  = time.Second
+)
+
+// TxOptions of the transactions, ReadOnly is rejected by mssql with ErrNotSupported as sql server has no read only transaction
+type TxOptions struct {
+	Isolation sql.IsolationLevel `json:"isolation" note:"隔离级别, 默认为数据库默认级别"`
+	ReadOnly  bool               `json:"readOnly" note:"是否只读"`
+
+	MaxAttempts int           `json:"maxAttempts" note:"最大执行次数(含首次), 默认3, 1表示不重试"`
+	MinBackoff  time.Duration `json:"minBackoff" note:"首次重试前等待时间, 默认20毫秒"`
+	MaxBackoff  time.Duration `json:"maxBackoff" note:"重试等待时间上限, 默认1秒"`
+}
+
+func (s TxOptions) SqlOptions() *sql.TxOptions {
+	return &sql.TxOptions{
+		Isolation: s.Isolation,
+		ReadOnly:  s.ReadOnly,
+	}
+}
+
+func (s TxOptions) maxAttempts() int {
+	if s.MaxAttempts < 1 {
+		return DefaultTxMaxAttempts
+	}
+
+	return s.MaxAttempts
+}
+
+// backoff returns the wait time before the given retry (1 based),
+// doubling from MinBackoff up to MaxBackoff with half of it as random jitter
+func (s TxOptions) backoff(retry int) time.Duration {
+	min := s.MinBackoff
+	if min <= 0 {
+		min = DefaultTxMinBackoff
+	}
+	max := s.MaxBackoff
+	if max <= 0 {
+		max = DefaultTxMaxBackoff
+	}
+	if max < min {
+		max = min
+	}
+
+	delay := min
+	for i := 1; i < retry && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+
+	half := int64(delay / 2)
+	if half < 1 {
+		return delay
+	}
+
+	return time.Duration(half + rand.Int63n(half))
+}
+
+// RunInTx executes fn in a transaction created by begin, commits when fn returns nil
+// and rolls back when fn returns an error or panics.
+// The whole function is executed again while retryable reports the error as transient,
+// except for the errors of Commit, as the transaction may have been committed when the connection failed.
+// It is the shared implementation behind SqlDatabase.RunInTx.
+func RunInTx(ctx context.Context, opts TxOptions, begin func() (SqlAccess, error), retryable func(err error) bool, fn func(tx SqlAccess) error) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	maxAttempts := opts.maxAttempts()
+	for attempt := 1; ; attempt++ {
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		err = runInTx(begin, fn)
+		if err == nil {
+			return nil
+		}
+		var commitErr *commitError
+		if errors.As(err, &commitErr) {
+			return commitErr.err
+		}
+		if attempt >= maxAttempts || retryable == nil || !retryable(err) {
+			return err
+		}
+
+		timer := time.NewTimer(opts.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+func runInTx(begin func() (SqlAccess, error), fn func(tx SqlAccess) error) error {
+	tx, err := begin()
+	if err != nil {
+		return err
+	}
+	// rolls back unless committed, also when fn panics
+	defer tx.Close()
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return &commitError{err: err}
+	}
+
+	return nil
+}
+
+// commitError is a failure of Commit, whose outcome is unknown to the client
+type commitError struct {
+	err error
+}
+
+func (s *commitError) Error() string {
+	return s.err.Error()
+}
+
+func (s *commitError) Unwrap() error {
+	return s.err
+}
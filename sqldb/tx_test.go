@@ -0,0 +1,133 @@
+package sqldb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type testTxAccess struct {
+	SqlAccess
+
+	commits   int
+	closes    int
+	commitErr error
+}
+
+func (s *testTxAccess) Commit() error {
+	s.commits++
+	return s.commitErr
+}
+
+func (s *testTxAccess) Close() error {
+	s.closes++
+	return nil
+}
+
+func TestRunInTx(t *testing.T) {
+	errTransient := errors.New("deadlock")
+	errFatal := errors.New("fatal")
+	retryable := func(err error) bool {
+		return err == errTransient
+	}
+	opts := TxOptions{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+
+	accesses := make([]*testTxAccess, 0)
+	begin := func() (SqlAccess, error) {
+		tx := &testTxAccess{}
+		accesses = append(accesses, tx)
+		return tx, nil
+	}
+
+	calls := 0
+	err := RunInTx(context.Background(), opts, begin, retryable, func(tx SqlAccess) error {
+		calls++
+		if calls < 3 {
+			return errTransient
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Fatal("calls error: expect=3, actual=", calls)
+	}
+	if accesses[2].commits != 1 || accesses[0].commits != 0 {
+		t.Error("only the last attempt should be committed")
+	}
+	for i, tx := range accesses {
+		if tx.closes != 1 {
+			t.Error("access ", i, " should be closed once, actual=", tx.closes)
+		}
+	}
+
+	calls = 0
+	err = RunInTx(context.Background(), opts, begin, retryable, func(tx SqlAccess) error {
+		calls++
+		return errFatal
+	})
+	if err != errFatal {
+		t.Error("error expect=", errFatal, ", actual=", err)
+	}
+	if calls != 1 {
+		t.Error("non retryable error should not be retried, calls=", calls)
+	}
+
+	calls = 0
+	err = RunInTx(context.Background(), opts, begin, retryable, func(tx SqlAccess) error {
+		calls++
+		return errTransient
+	})
+	if err != errTransient {
+		t.Error("error expect=", errTransient, ", actual=", err)
+	}
+	if calls != 3 {
+		t.Error("calls error: expect=3, actual=", calls)
+	}
+
+	accesses = accesses[:0]
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Error("panic should be propagated")
+			}
+		}()
+		RunInTx(context.Background(), opts, begin, retryable, func(tx SqlAccess) error {
+			panic("boom")
+		})
+	}()
+	if len(accesses) != 1 || accesses[0].closes != 1 || accesses[0].commits != 0 {
+		t.Error("panicking transaction should be rolled back")
+	}
+
+	// the transaction may be committed before the connection failed, so commit is not retried
+	accesses = accesses[:0]
+	begin = func() (SqlAccess, error) {
+		tx := &testTxAccess{commitErr: errTransient}
+		accesses = append(accesses, tx)
+		return tx, nil
+	}
+	calls = 0
+	err = RunInTx(context.Background(), opts, begin, retryable, func(tx SqlAccess) error {
+		calls++
+		return nil
+	})
+	if err != errTransient {
+		t.Error("commit error expect=", errTransient, ", actual=", err)
+	}
+	if calls != 1 || len(accesses) != 1 {
+		t.Error("failed commit should not be retried, calls=", calls)
+	}
+}
+
+func TestTxOptions_Backoff(t *testing.T) {
+	opts := TxOptions{MinBackoff: 10 * time.Millisecond, MaxBackoff: 40 * time.Millisecond}
+	for retry := 1; retry < 6; retry++ {
+		delay := opts.backoff(retry)
+		if delay < 5*time.Millisecond || delay > 40*time.Millisecond {
+			t.Error("backoff out of range: retry=", retry, ", delay=", delay)
+		}
+	}
+}